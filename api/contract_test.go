@@ -0,0 +1,226 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shanehowearth/kart/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestContract runs requests through the real routes, and checks that every
+// response matches the OpenAPI document served by the API.
+func TestContract(t *testing.T) {
+	server := httptest.NewServer(newTestMux(t, api.CORSPolicy{}))
+	defer server.Close()
+
+	// Fetch the document the same way a client would.
+	specResponse, err := http.Get(server.URL + "/api/openapi.json")
+	require.NoError(t, err)
+
+	defer specResponse.Body.Close()
+
+	specData, err := io.ReadAll(specResponse.Body)
+	require.NoError(t, err)
+	assert.Equal(t, api.OpenAPISpec, specData)
+
+	doc := loadOpenAPIDocument(t, specData)
+
+	// createdOrderID is filled in by the order creation request, so that it
+	// can be fetched afterwards.
+	createdOrderID := ""
+
+	// Requests run in order, as later requests depend on earlier ones.
+	testcases := []struct {
+		name           string
+		method         string
+		path           func() string
+		body           string
+		expectedStatus int
+		inspect        func(t *testing.T, body map[string]any)
+	}{
+		{
+			name:           "List products",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/product" },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Fetch a product",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/product/2" },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Fetch an unknown product",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/product/288" },
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Create an order",
+			method:         http.MethodPost,
+			path:           func() string { return "/api/order" },
+			body:           `{"couponCode": "", "items": [{"productId": "1", "quantity": 2}]}`,
+			expectedStatus: http.StatusCreated,
+			inspect: func(t *testing.T, body map[string]any) {
+				t.Helper()
+
+				id, ok := body["id"].(string)
+				require.True(t, ok, "order id missing from %v", body)
+
+				createdOrderID = id
+			},
+		},
+		{
+			name:           "Create an order with a malformed body",
+			method:         http.MethodPost,
+			path:           func() string { return "/api/order" },
+			body:           `{"items": `,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Create an order for unknown products",
+			method:         http.MethodPost,
+			path:           func() string { return "/api/order" },
+			body:           `{"items": [{"productId": "288", "quantity": 1}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Fetch the created order",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/order/" + createdOrderID },
+			expectedStatus: http.StatusOK,
+			inspect: func(t *testing.T, body map[string]any) {
+				t.Helper()
+				assert.Equal(t, createdOrderID, body["id"])
+			},
+		},
+		{
+			name:           "Cancel the created order",
+			method:         http.MethodPost,
+			path:           func() string { return "/api/order/" + createdOrderID + "/cancel" },
+			expectedStatus: http.StatusOK,
+			inspect: func(t *testing.T, body map[string]any) {
+				t.Helper()
+				assert.Equal(t, true, body["cancelled"])
+			},
+		},
+		{
+			name:           "Cancel an unknown order",
+			method:         http.MethodPost,
+			path:           func() string { return "/api/order/does-not-exist/cancel" },
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Fetch an unknown order",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/order/does-not-exist" },
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Validate coupon codes",
+			method:         http.MethodPost,
+			path:           func() string { return "/api/promotion/validate" },
+			body:           `{"codes": ["FIFTYOFF", "tenoff"]}`,
+			expectedStatus: http.StatusOK,
+			inspect: func(t *testing.T, body map[string]any) {
+				t.Helper()
+
+				results, ok := body["results"].([]any)
+				require.True(t, ok, "results missing from %v", body)
+				require.Len(t, results, 2)
+
+				// Only the answer is returned, not how it was reached.
+				assert.Equal(t, map[string]any{"code": "FIFTYOFF", "valid": true}, results[0])
+				assert.Equal(t, map[string]any{"code": "tenoff", "valid": false}, results[1])
+			},
+		},
+		{
+			name:           "Validate coupon codes with a malformed body",
+			method:         http.MethodPost,
+			path:           func() string { return "/api/promotion/validate" },
+			body:           `{"codes": "FIFTYOFF"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Fetch a coupon code",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/promotion/FIFTYOFF" },
+			expectedStatus: http.StatusOK,
+			inspect: func(t *testing.T, body map[string]any) {
+				t.Helper()
+				assert.Equal(t, map[string]any{"code": "FIFTYOFF", "valid": true}, body)
+			},
+		},
+		{
+			name:           "Fetch a coupon code once the client is rate limited",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/promotion/TENOFF" },
+			expectedStatus: http.StatusTooManyRequests,
+			inspect: func(t *testing.T, body map[string]any) {
+				t.Helper()
+				assert.Equal(t, "RATE_LIMITED", body["code"])
+			},
+		},
+		{
+			name:           "Fetch the API document",
+			method:         http.MethodGet,
+			path:           func() string { return "/api/openapi.json" },
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	// covered records the documented operations that were exercised.
+	covered := map[string]bool{}
+
+	for _, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(tc.name, func(t *testing.T) {
+			path := tc.path()
+
+			request, err := http.NewRequest(tc.method, server.URL+path, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", "application/json")
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+
+			defer response.Body.Close()
+
+			assert.Equal(t, tc.expectedStatus, response.StatusCode)
+			assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+
+			template, operation, ok := doc.operation(tc.method, path)
+			require.True(t, ok, "%s %s is not documented", tc.method, path)
+
+			covered[tc.method+" "+template] = true
+
+			schema, err := doc.responseSchema(operation, response.StatusCode)
+			require.NoError(t, err)
+
+			var body any
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&body))
+
+			assert.Empty(t, doc.validate(schema, body, "response"))
+
+			if tc.inspect != nil {
+				object, _ := body.(map[string]any)
+				tc.inspect(t, object)
+			}
+		})
+	}
+
+	// Every documented operation must be exercised, so that the document
+	// cannot drift from the handlers unnoticed.
+	for template, operations := range doc.Paths {
+		for method := range operations {
+			key := strings.ToUpper(method) + " " + template
+			assert.True(t, covered[key], "documented operation %s has no contract test", key)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/kart/internal/requestid"
+)
+
+// CORSPolicy describes which cross origin requests the API allows.
+type CORSPolicy struct {
+	// AllowedOrigins may contain exact origins ("https://shop.example.com"),
+	// wildcard subdomains ("https://*.example.com"), or "*" to allow any
+	// origin.
+	AllowedOrigins []string
+	// AllowedHeaders are the request headers a browser may send.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers a browser may read.
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and Authorization headers to be sent.
+	// A "*" in AllowedOrigins is ignored when it is set.
+	AllowCredentials bool
+	// MaxAge is how long a browser may cache the result of a preflight
+	// request. Zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// allowOrigin returns the value for the Access-Control-Allow-Origin header, or
+// "" if the origin is not allowed.
+func (p CORSPolicy) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			// Browsers refuse a wildcard when credentials are allowed,
+			// which stops any site making credentialed requests, so with
+			// credentials only the origins listed are allowed.
+			if p.AllowCredentials {
+				continue
+			}
+
+			return "*"
+		}
+
+		if matchOrigin(allowed, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// matchOrigin reports whether the origin matches the allowed pattern. A
+// pattern may contain a single "*", which matches one or more subdomain
+// labels, eg. "https://*.example.com" matches "https://shop.example.com" but
+// not "https://example.com".
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return strings.EqualFold(pattern, origin)
+	}
+
+	origin = strings.ToLower(origin)
+	prefix = strings.ToLower(prefix)
+	suffix = strings.ToLower(suffix)
+
+	if len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) ||
+		!strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	// The wildcard must only cover host labels.
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+
+	return !strings.ContainsAny(subdomain, "/:@")
+}
+
+// setAllowOrigin sets the headers common to preflight and actual responses,
+// returning false if the origin is not allowed.
+func (p CORSPolicy) setAllowOrigin(header http.Header, origin string) bool {
+	// The response differs by origin, so caches must take it into account.
+	header.Add("Vary", "Origin")
+
+	allowOrigin := p.allowOrigin(origin)
+	if allowOrigin == "" {
+		return false
+	}
+
+	header.Set("Access-Control-Allow-Origin", allowOrigin)
+
+	if p.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
+
+// Middleware adds the CORS headers to the responses of actual (non preflight)
+// requests.
+func (p CORSPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.setAllowOrigin(w.Header(), r.Header.Get("Origin")) && len(p.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+
+		// Call the next handler in the chain.
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflightHandler answers OPTIONS requests for a route that accepts the
+// supplied methods.
+// Disallowed origins, or methods, get a response without any CORS headers,
+// which the browser treats as a refusal.
+func (p CORSPolicy) preflightHandler(methods []string) http.Handler {
+	allowMethods := strings.Join(append(slices.Clone(methods), http.MethodOptions), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Allow", allowMethods)
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+
+		if slices.Contains(methods, requestMethod) &&
+			p.setAllowOrigin(header, r.Header.Get("Origin")) {
+			header.Set("Access-Control-Allow-Methods", allowMethods)
+
+			if len(p.AllowedHeaders) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+			}
+
+			if p.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// router registers routes on a mux, recording the methods for each path so
+// that every path gets a matching preflight (OPTIONS) route.
+type router struct {
+	mux  *http.ServeMux
+	cors CORSPolicy
+	// methods k=path, v=methods registered for the path.
+	methods map[string][]string
+	// paths holds the paths in registration order, so that preflight routes
+	// are registered deterministically.
+	paths []string
+}
+
+func newRouter(mux *http.ServeMux, cors CORSPolicy) *router {
+	return &router{
+		mux:     mux,
+		cors:    cors,
+		methods: map[string][]string{},
+	}
+}
+
+// handle registers the handler for the method and path, wrapped in the
+// request ID, and CORS, middleware.
+func (rt *router) handle(method, path string, handler http.Handler) {
+	if _, ok := rt.methods[path]; !ok {
+		rt.paths = append(rt.paths, path)
+	}
+
+	rt.methods[path] = append(rt.methods[path], method)
+	rt.mux.Handle(method+" "+path, requestid.Middleware(rt.cors.Middleware(handler)))
+}
+
+// registerPreflights adds an OPTIONS route for every registered path. It must
+// be called after all other routes have been registered.
+func (rt *router) registerPreflights() {
+	for _, path := range rt.paths {
+		rt.mux.Handle(http.MethodOptions+" "+path, rt.cors.preflightHandler(rt.methods[path]))
+	}
+}
@@ -0,0 +1,187 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/api"
+	"github.com/shanehowearth/kart/api/handlers"
+	"github.com/shanehowearth/kart/internal/ratelimit"
+	"github.com/shanehowearth/kart/order"
+	"github.com/shanehowearth/kart/order/datastore/inmemoryorderdatastore"
+	"github.com/shanehowearth/kart/product"
+	"github.com/shanehowearth/kart/product/datastore"
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMux(t *testing.T, cors api.CORSPolicy) *http.ServeMux {
+	t.Helper()
+
+	productService, err := product.NewProductService(datastore.NewSeededInMemoryProductStore())
+	require.NoError(t, err)
+
+	orderService, err := order.NewOrderService(inmemoryorderdatastore.NewInMemoryOrderStore(), productService)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	api.RegisterRoutes(mux, cors, orderService, productService, newTestCoupons(t))
+
+	return mux
+}
+
+// testCouponBurst is the number of codes a test client may look up, they are
+// not returned during a test.
+const testCouponBurst = 3
+
+// newTestCoupons validates codes against two coupon files, FIFTYOFF is in
+// both, and so valid, TENOFF is only in one.
+func newTestCoupons(t *testing.T) *handlers.PromotionHandler {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")}
+
+	require.NoError(t, os.WriteFile(files[0], []byte("FIFTYOFF\nTENOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(files[1], []byte("FIFTYOFF\n"), 0o600))
+
+	search, err := promotion.NewSearch(memory.New())
+	require.NoError(t, err)
+
+	return handlers.NewPromotionHandler(search, files, time.Minute, ratelimit.New(1, testCouponBurst))
+}
+
+func TestCORSPreflight(t *testing.T) {
+	policy := api.CORSPolicy{
+		AllowedOrigins:   []string{"http://localhost:3000", "https://*.example.com"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+
+	testcases := map[string]struct {
+		path                string
+		origin              string
+		requestMethod       string
+		expectedAllowOrigin string
+		expectedMethods     string
+	}{
+		"Exact origin preflight for order creation": {
+			path:                "/api/order",
+			origin:              "http://localhost:3000",
+			requestMethod:       http.MethodPost,
+			expectedAllowOrigin: "http://localhost:3000",
+			expectedMethods:     "POST, OPTIONS",
+		},
+		"Wildcard subdomain preflight for a product": {
+			path:                "/api/product/2",
+			origin:              "https://shop.example.com",
+			requestMethod:       http.MethodGet,
+			expectedAllowOrigin: "https://shop.example.com",
+			expectedMethods:     "GET, OPTIONS",
+		},
+		"Wildcard does not match the bare domain": {
+			path:          "/api/product",
+			origin:        "https://example.com",
+			requestMethod: http.MethodGet,
+		},
+		"Unknown origin is refused": {
+			path:          "/api/order",
+			origin:        "https://evil.test",
+			requestMethod: http.MethodPost,
+		},
+		"Method not registered for the route is refused": {
+			path:          "/api/product",
+			origin:        "http://localhost:3000",
+			requestMethod: http.MethodDelete,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			mux := newTestMux(t, policy)
+
+			request := httptest.NewRequest(http.MethodOptions, tc.path, nil)
+			request.Header.Set("Origin", tc.origin)
+			request.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusNoContent, recorder.Code)
+			assert.Equal(t, tc.expectedAllowOrigin, recorder.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tc.expectedMethods, recorder.Header().Get("Access-Control-Allow-Methods"))
+
+			if tc.expectedAllowOrigin != "" {
+				assert.Equal(t, "true", recorder.Header().Get("Access-Control-Allow-Credentials"))
+				assert.Equal(t, "600", recorder.Header().Get("Access-Control-Max-Age"))
+				assert.Equal(t, "Content-Type", recorder.Header().Get("Access-Control-Allow-Headers"))
+			}
+		})
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	testcases := map[string]struct {
+		policy              api.CORSPolicy
+		origin              string
+		expectedAllowOrigin string
+		expectedExposed     string
+	}{
+		"Any origin without credentials gets a wildcard": {
+			policy:              api.CORSPolicy{AllowedOrigins: []string{"*"}},
+			origin:              "https://anywhere.test",
+			expectedAllowOrigin: "*",
+		},
+		"Any origin with credentials is not echoed": {
+			policy:              api.CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			origin:              "https://anywhere.test",
+			expectedAllowOrigin: "",
+		},
+		"Listed origin with credentials is allowed alongside a wildcard": {
+			policy: api.CORSPolicy{
+				AllowedOrigins:   []string{"*", "https://shop.example.com"},
+				AllowCredentials: true,
+			},
+			origin:              "https://shop.example.com",
+			expectedAllowOrigin: "https://shop.example.com",
+		},
+		"Exposed headers are listed for allowed origins": {
+			policy: api.CORSPolicy{
+				AllowedOrigins: []string{"http://localhost:3000"},
+				ExposedHeaders: []string{"X-Request-ID", "Retry-After"},
+			},
+			origin:              "http://localhost:3000",
+			expectedAllowOrigin: "http://localhost:3000",
+			expectedExposed:     "X-Request-ID, Retry-After",
+		},
+		"Disallowed origin gets no CORS headers": {
+			policy: api.CORSPolicy{
+				AllowedOrigins: []string{"http://localhost:3000"},
+				ExposedHeaders: []string{"X-Request-ID"},
+			},
+			origin: "http://localhost:4000",
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			mux := newTestMux(t, tc.policy)
+
+			request := httptest.NewRequest(http.MethodGet, "/api/product", nil)
+			request.Header.Set("Origin", tc.origin)
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, tc.expectedAllowOrigin, recorder.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tc.expectedExposed, recorder.Header().Get("Access-Control-Expose-Headers"))
+			assert.Contains(t, recorder.Header().Values("Vary"), "Origin")
+		})
+	}
+}
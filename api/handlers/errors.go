@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/shanehowearth/kart/internal/requestid"
+	"github.com/shanehowearth/kart/order"
+	"github.com/shanehowearth/kart/product"
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// Machine readable error codes, clients should branch on these rather than the
+// message.
+const (
+	ErrorCodeInvalidRequest      = "INVALID_REQUEST"
+	ErrorCodeInvalidOrder        = "INVALID_ORDER"
+	ErrorCodeOrderNotFound       = "ORDER_NOT_FOUND"
+	ErrorCodeOrderCreateFailed   = "ORDER_CREATE_FAILED"
+	ErrorCodeOrderCancelFailed   = "ORDER_CANCEL_FAILED"
+	ErrorCodeCouponNotRedeemable = "COUPON_NOT_REDEEMABLE"
+	ErrorCodeProductNotFound     = "PRODUCT_NOT_FOUND"
+	ErrorCodeRateLimited         = "RATE_LIMITED"
+	ErrorCodeCouponSearch        = "COUPON_SEARCH_FAILED"
+	ErrorCodeCouponUnavailable   = "COUPON_SEARCH_UNAVAILABLE"
+	ErrorCodeInternal            = "INTERNAL_ERROR"
+)
+
+// ErrInvalidRequest is returned when the request cannot be decoded.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrorResponse is the envelope returned for every failed request.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// errorMapping links a domain (sentinel) error to the response it produces.
+type errorMapping struct {
+	err     error
+	status  int
+	code    string
+	message string
+}
+
+// errorMappings are checked in order, the first match wins. More specific
+// errors must come before the errors that they are wrapped with, eg.
+// order.ErrInvalidOrder is always wrapped with order.ErrCreateFailed.
+var errorMappings = []errorMapping{
+	{
+		err:     ErrInvalidRequest,
+		status:  http.StatusBadRequest,
+		code:    ErrorCodeInvalidRequest,
+		message: "invalid request body",
+	},
+	{
+		err:     order.ErrInvalidOrder,
+		status:  http.StatusUnprocessableEntity,
+		code:    ErrorCodeInvalidOrder,
+		message: "order cannot be created as requested",
+	},
+	{
+		err:     order.ErrNotFound,
+		status:  http.StatusNotFound,
+		code:    ErrorCodeOrderNotFound,
+		message: "order not found",
+	},
+	{
+		err:     promotion.ErrCouponNotActive,
+		status:  http.StatusUnprocessableEntity,
+		code:    ErrorCodeCouponNotRedeemable,
+		message: "coupon is not active",
+	},
+	{
+		err:     promotion.ErrCouponUsedUp,
+		status:  http.StatusUnprocessableEntity,
+		code:    ErrorCodeCouponNotRedeemable,
+		message: "coupon has been used up",
+	},
+	{
+		err:     promotion.ErrCustomerRequired,
+		status:  http.StatusUnprocessableEntity,
+		code:    ErrorCodeCouponNotRedeemable,
+		message: "coupon can only be used by a known customer",
+	},
+	{
+		err:     promotion.ErrCustomerLimit,
+		status:  http.StatusUnprocessableEntity,
+		code:    ErrorCodeCouponNotRedeemable,
+		message: "coupon has been used up by the customer",
+	},
+	{
+		// Coupon lookups, including an order's, are limited, and may be
+		// unavailable.
+		err:     ErrRateLimited,
+		status:  http.StatusTooManyRequests,
+		code:    ErrorCodeRateLimited,
+		message: "too many coupon lookups, try again later",
+	},
+	{
+		err:     ErrPromotionUnavailable,
+		status:  http.StatusServiceUnavailable,
+		code:    ErrorCodeCouponUnavailable,
+		message: "coupon validation is unavailable",
+	},
+	{
+		// Timeouts, and clients that have gone away.
+		err:     promotion.ErrCancelled,
+		status:  http.StatusServiceUnavailable,
+		code:    ErrorCodeCouponUnavailable,
+		message: "coupon validation is unavailable",
+	},
+	{
+		err:     order.ErrCreateFailed,
+		status:  http.StatusInternalServerError,
+		code:    ErrorCodeOrderCreateFailed,
+		message: "failed to create order",
+	},
+	{
+		err:     order.ErrCancelFailed,
+		status:  http.StatusInternalServerError,
+		code:    ErrorCodeOrderCancelFailed,
+		message: "failed to cancel order",
+	},
+	{
+		err:     product.ErrNotFound,
+		status:  http.StatusNotFound,
+		code:    ErrorCodeProductNotFound,
+		message: "product not found",
+	},
+	{
+		err:     promotion.ErrSearchFailed,
+		status:  http.StatusInternalServerError,
+		code:    ErrorCodeCouponSearch,
+		message: "failed to validate coupon codes",
+	},
+}
+
+// writeError writes the error envelope for err. Errors without a mapping are
+// treated as internal errors, and their detail is only logged, never returned
+// to the client.
+func writeError(writer http.ResponseWriter, request *http.Request, err error, details any) {
+	requestID := requestid.FromContext(request.Context())
+
+	mapping := errorMapping{
+		status:  http.StatusInternalServerError,
+		code:    ErrorCodeInternal,
+		message: "internal server error",
+	}
+
+	for _, candidate := range errorMappings {
+		if errors.Is(err, candidate.err) {
+			mapping = candidate
+			break
+		}
+	}
+
+	if mapping.status >= http.StatusInternalServerError {
+		log.Printf("request %s %s %s failed: %v", requestID, request.Method, request.URL.Path, err)
+	}
+
+	setRetryAfter(writer, err)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(mapping.status)
+
+	response := ErrorResponse{
+		Code:      mapping.code,
+		Message:   mapping.message,
+		Details:   details,
+		RequestID: requestID,
+	}
+
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		log.Printf("writeError Encoding JSON failed: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shanehowearth/kart/api/handlers"
+	"github.com/shanehowearth/kart/internal/requestid"
+	"github.com/shanehowearth/kart/order"
+	"github.com/shanehowearth/kart/order/datastore/inmemoryorderdatastore"
+	"github.com/shanehowearth/kart/product"
+	"github.com/shanehowearth/kart/product/datastore"
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingOrderStore simulates a broken database.
+type failingOrderStore struct{}
+
+func (failingOrderStore) CreateOrder(*order.Order) error {
+	return errors.New("connection refused")
+}
+
+func (failingOrderStore) GetByID(string) (order.Order, error) {
+	return order.Order{}, errors.New("connection refused")
+}
+
+func (failingOrderStore) CancelOrder(string) (order.Order, error) {
+	return order.Order{}, errors.New("connection refused")
+}
+
+// usedUpCoupons refuses every coupon.
+type usedUpCoupons struct{}
+
+func (usedUpCoupons) RedeemCoupon(string, string, string) error {
+	return promotion.ErrCouponUsedUp
+}
+
+func (usedUpCoupons) ReleaseCoupon(string) error { return nil }
+
+// knownCoupons are the valid coupons.
+type knownCoupons map[string]bool
+
+func (k knownCoupons) IsValidCoupon(_ context.Context, code, _ string) (bool, error) {
+	return k[code], nil
+}
+
+func TestErrorResponses(t *testing.T) {
+	testcases := map[string]struct {
+		orderStore      order.Store
+		coupons         order.CouponRedeemer
+		method          string
+		pattern         string
+		target          string
+		body            string
+		expectedStatus  int
+		expectedCode    string
+		expectedDetails any
+	}{
+		"Malformed order body": {
+			method:         http.MethodPost,
+			pattern:        "POST /api/order",
+			target:         "/api/order",
+			body:           `{"items": [`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   handlers.ErrorCodeInvalidRequest,
+		},
+		"Order without items": {
+			method:          http.MethodPost,
+			pattern:         "POST /api/order",
+			target:          "/api/order",
+			body:            `{"items": []}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedCode:    handlers.ErrorCodeInvalidRequest,
+			expectedDetails: "order must contain at least one item",
+		},
+		"Order for unknown products": {
+			method:         http.MethodPost,
+			pattern:        "POST /api/order",
+			target:         "/api/order",
+			body:           `{"items": [{"productId": "does-not-exist", "quantity": 1}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   handlers.ErrorCodeInvalidOrder,
+		},
+		"Order store failure while creating": {
+			orderStore:     failingOrderStore{},
+			method:         http.MethodPost,
+			pattern:        "POST /api/order",
+			target:         "/api/order",
+			body:           `{"items": [{"productId": "1", "quantity": 1}]}`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   handlers.ErrorCodeOrderCreateFailed,
+		},
+		"Unknown order": {
+			method:         http.MethodGet,
+			pattern:        "GET /api/order/{id}",
+			target:         "/api/order/does-not-exist",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   handlers.ErrorCodeOrderNotFound,
+		},
+		"Order store failure while fetching is not a 404": {
+			orderStore:     failingOrderStore{},
+			method:         http.MethodGet,
+			pattern:        "GET /api/order/{id}",
+			target:         "/api/order/some-id",
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   handlers.ErrorCodeInternal,
+		},
+		"Order with a used up coupon": {
+			coupons:        usedUpCoupons{},
+			method:         http.MethodPost,
+			pattern:        "POST /api/order",
+			target:         "/api/order",
+			body:           `{"couponCode": "FIFTYOFF", "items": [{"productId": "1", "quantity": 1}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   handlers.ErrorCodeCouponNotRedeemable,
+		},
+		"Order with an invalid coupon": {
+			coupons:        usedUpCoupons{},
+			method:         http.MethodPost,
+			pattern:        "POST /api/order",
+			target:         "/api/order",
+			body:           `{"couponCode": "MADEUP", "items": [{"productId": "1", "quantity": 1}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   handlers.ErrorCodeInvalidOrder,
+		},
+		"Order with a coupon, when coupons are not accepted": {
+			method:         http.MethodPost,
+			pattern:        "POST /api/order",
+			target:         "/api/order",
+			body:           `{"couponCode": "FIFTYOFF", "items": [{"productId": "1", "quantity": 1}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   handlers.ErrorCodeInvalidOrder,
+		},
+		"Cancel an unknown order": {
+			method:         http.MethodPost,
+			pattern:        "POST /api/order/{id}/cancel",
+			target:         "/api/order/does-not-exist/cancel",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   handlers.ErrorCodeOrderNotFound,
+		},
+		"Unknown product": {
+			method:          http.MethodGet,
+			pattern:         "GET /api/product/{id}",
+			target:          "/api/product/288",
+			expectedStatus:  http.StatusNotFound,
+			expectedCode:    handlers.ErrorCodeProductNotFound,
+			expectedDetails: map[string]any{"notFound": []any{"288"}},
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			productService, err := product.NewProductService(datastore.NewSeededInMemoryProductStore())
+			require.NoError(t, err)
+
+			orderStore := tc.orderStore
+			if orderStore == nil {
+				orderStore = inmemoryorderdatastore.NewInMemoryOrderStore()
+			}
+
+			opts := []order.Option{}
+			if tc.coupons != nil {
+				opts = append(opts,
+					order.WithCouponRedeemer(tc.coupons),
+					order.WithCouponValidator(knownCoupons{"FIFTYOFF": true}),
+				)
+			}
+
+			orderService, err := order.NewOrderService(orderStore, productService, opts...)
+			require.NoError(t, err)
+
+			orderHandler := handlers.NewOrderHandler(orderService)
+			productHandler := handlers.NewProductHandler(productService)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /api/order", orderHandler.CreateOrder)
+			mux.HandleFunc("GET /api/order/{id}", orderHandler.GetOrder)
+			mux.HandleFunc("POST /api/order/{id}/cancel", orderHandler.CancelOrder)
+			mux.HandleFunc("GET /api/product/{id}", productHandler.GetProduct)
+
+			request := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			request.Header.Set(requestid.Header, "test-request-1")
+
+			recorder := httptest.NewRecorder()
+			requestid.Middleware(mux).ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+			var actual handlers.ErrorResponse
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&actual))
+
+			assert.Equal(t, tc.expectedCode, actual.Code)
+			assert.NotEmpty(t, actual.Message)
+			assert.Equal(t, "test-request-1", actual.RequestID)
+
+			if tc.expectedDetails != nil {
+				assert.Equal(t, tc.expectedDetails, actual.Details)
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/shanehowearth/kart/order"
 )
@@ -15,6 +17,7 @@ type OrderHandler struct {
 
 // CreateOrderRequest defines the data in the request.
 type CreateOrderRequest struct {
+	CustomerID string `json:"customerId"`
 	CouponCode string `json:"couponCode"`
 	Items      []struct {
 		ProductID string `json:"productId"`
@@ -22,6 +25,53 @@ type CreateOrderRequest struct {
 	} `json:"items"`
 }
 
+// OrderItemResponse is a single line of an order.
+type OrderItemResponse struct {
+	ProductID string `json:"productId"`
+	Quantity  int    `json:"quantity"`
+}
+
+// OrderResponse details what data and how it is formatted is responded for an
+// order - it's a DTO.
+type OrderResponse struct {
+	ID         string              `json:"id"`
+	CustomerID string              `json:"customerId,omitempty"`
+	CouponCode string              `json:"couponCode,omitempty"`
+	Items      []OrderItemResponse `json:"items"`
+	Products   []ProductResponse   `json:"products"`
+	Cancelled  bool                `json:"cancelled"`
+}
+
+// newOrderResponse converts a domain order into its displayable form.
+func newOrderResponse(domainOrder order.Order) OrderResponse {
+	response := OrderResponse{
+		ID:         domainOrder.ID,
+		CustomerID: domainOrder.CustomerID,
+		CouponCode: domainOrder.CouponCode,
+		Items:      make([]OrderItemResponse, 0, len(domainOrder.Items)),
+		Products:   make([]ProductResponse, 0, len(domainOrder.Products)),
+		Cancelled:  domainOrder.Cancelled,
+	}
+
+	for _, item := range domainOrder.Items {
+		response.Items = append(response.Items, OrderItemResponse{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	for _, productReference := range domainOrder.Products {
+		response.Products = append(response.Products, ProductResponse{
+			ID:           productReference.ID,
+			Name:         productReference.Name,
+			PriceDisplay: formatPrice(productReference.PriceCents),
+			Category:     productReference.Category,
+		})
+	}
+
+	return response
+}
+
 // NewOrderHandler creates and initialises a new order handler.
 func NewOrderHandler(osvc *order.Service) *OrderHandler {
 	return &OrderHandler{orderService: osvc}
@@ -32,7 +82,7 @@ func (handler *OrderHandler) CreateOrder(writer http.ResponseWriter, request *ht
 	var req CreateOrderRequest
 
 	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
-		http.Error(writer, "invalid request body", http.StatusBadRequest)
+		writeError(writer, request, fmt.Errorf("%w %w", ErrInvalidRequest, err), err.Error())
 		return
 	}
 
@@ -40,7 +90,7 @@ func (handler *OrderHandler) CreateOrder(writer http.ResponseWriter, request *ht
 	// TODO: This is an assumption on my part, need to discover if this fits
 	// requirements.
 	if len(req.Items) == 0 {
-		http.Error(writer, "order must contain at least one item", http.StatusBadRequest)
+		writeError(writer, request, ErrInvalidRequest, "order must contain at least one item")
 		return
 	}
 
@@ -54,16 +104,24 @@ func (handler *OrderHandler) CreateOrder(writer http.ResponseWriter, request *ht
 	}
 
 	// Create order.
-	newOrder, err := handler.orderService.NewOrder(items)
+	// The coupon code is looked up on behalf of the client, who is limited
+	// as they are when looking up codes directly.
+	newOrder, err := handler.orderService.NewOrder(
+		request.Context(),
+		items,
+		strings.TrimSpace(req.CustomerID),
+		strings.TrimSpace(req.CouponCode),
+		clientAddress(request),
+	)
 	if err != nil {
-		http.Error(writer, "failed to create order", http.StatusInternalServerError)
+		writeError(writer, request, err, nil)
 		return
 	}
 
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusCreated)
 
-	if err := json.NewEncoder(writer).Encode(newOrder); err != nil {
+	if err := json.NewEncoder(writer).Encode(newOrderResponse(newOrder)); err != nil {
 		log.Printf("CreateOrder Encoding JSON failed failed: %v", err)
 	}
 }
@@ -74,13 +132,32 @@ func (handler *OrderHandler) GetOrder(writer http.ResponseWriter, request *http.
 
 	fetchedOrder, err := handler.orderService.GetOrderByID(id)
 	if err != nil {
-		http.Error(writer, "order not found", http.StatusNotFound)
+		// Only order.ErrNotFound is a 404, anything else is a store failure.
+		writeError(writer, request, err, nil)
 		return
 	}
 
 	writer.Header().Set("Content-Type", "application/json")
 
-	if err := json.NewEncoder(writer).Encode(fetchedOrder); err != nil {
+	if err := json.NewEncoder(writer).Encode(newOrderResponse(fetchedOrder)); err != nil {
 		log.Printf("GetOrder Encoding JSON failed failed: %v", err)
 	}
 }
+
+// CancelOrder cancels an order, as specified by its id, returning the use of
+// its coupon code.
+func (handler *OrderHandler) CancelOrder(writer http.ResponseWriter, request *http.Request) {
+	id := request.PathValue("id")
+
+	cancelledOrder, err := handler.orderService.CancelOrder(id)
+	if err != nil {
+		writeError(writer, request, err, nil)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(writer).Encode(newOrderResponse(cancelledOrder)); err != nil {
+		log.Printf("CancelOrder Encoding JSON failed failed: %v", err)
+	}
+}
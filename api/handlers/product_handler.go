@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -28,6 +29,12 @@ type ProductResponse struct {
 	Category     string `json:"category"`
 }
 
+// ProductLookupResponse holds the products found, and the IDs that were not.
+type ProductLookupResponse struct {
+	Products []ProductResponse `json:"products"`
+	NotFound []string          `json:"notFound"`
+}
+
 const centsPerDollar = 100
 
 func formatPrice(cents int64) string {
@@ -40,10 +47,10 @@ func formatPrice(cents int64) string {
 }
 
 // ListProducts lists all the products.
-func (h *ProductHandler) ListProducts(writer http.ResponseWriter, _ *http.Request) {
+func (h *ProductHandler) ListProducts(writer http.ResponseWriter, request *http.Request) {
 	products, err := h.productService.GetAvailableProducts()
 	if err != nil {
-		http.Error(writer, "failed to fetch products", http.StatusInternalServerError)
+		writeError(writer, request, err, nil)
 		return
 	}
 
@@ -72,8 +79,15 @@ func (h *ProductHandler) GetProduct(writer http.ResponseWriter, request *http.Re
 
 	fetchedProducts, missed, err := h.productService.GetProductsByIDs([]string{id})
 	if err != nil {
-		// Unexpected error (database failure, etc.)
-		http.Error(writer, "internal server error", http.StatusInternalServerError)
+		// product.ErrNotFound is a 404, anything else is unexpected (database
+		// failure, etc.)
+		var details any
+		if errors.Is(err, product.ErrNotFound) {
+			details = map[string][]string{"notFound": missed}
+		}
+
+		writeError(writer, request, err, details)
+
 		return
 	}
 
@@ -90,10 +104,7 @@ func (h *ProductHandler) GetProduct(writer http.ResponseWriter, request *http.Re
 	writer.Header().Set("Content-Type", "application/json")
 
 	// Return whatever was found (might be empty array)
-	response := struct {
-		Products []ProductResponse `json:"products"`
-		NotFound []string          `json:"not found"`
-	}{
+	response := ProductLookupResponse{
 		Products: productsResponse,
 		NotFound: missed,
 	}
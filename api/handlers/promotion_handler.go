@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/kart/internal/ratelimit"
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// maxCodesPerRequest is the most codes that can be validated by one request.
+const maxCodesPerRequest = 50
+
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrRateLimited          = errors.New("too many coupon lookups")
+	ErrPromotionUnavailable = errors.New("coupon validation is not configured")
+)
+
+// PromotionHandler provides the HTTP handlers for validating coupon codes.
+type PromotionHandler struct {
+	search *promotion.Search
+	// files are the coupon files that codes are searched for in.
+	files []string
+	// timeout bounds each search, zero is no limit.
+	timeout time.Duration
+	// limiter is keyed by the client's address, and charged a token per
+	// code, so that codes cannot be guessed by brute force.
+	limiter *ratelimit.Limiter
+}
+
+// ValidatePromotionRequest holds the codes to validate.
+type ValidatePromotionRequest struct {
+	Codes []string `json:"codes"`
+}
+
+// PromotionResponse is the validity of a single code - it's a DTO.
+// Only the answer is returned, how it was reached (the files the code was
+// found in, the rules it failed, the cache) is not the client's business.
+type PromotionResponse struct {
+	Code  string `json:"code"`
+	Valid bool   `json:"valid"`
+}
+
+// ValidatePromotionResponse holds the validity of each code, in the order
+// requested.
+type ValidatePromotionResponse struct {
+	Results []PromotionResponse `json:"results"`
+}
+
+// NewPromotionHandler creates a promotion handler that searches the files. A
+// nil search, or no files, answers every request as unavailable, and a nil
+// limiter does not limit.
+func NewPromotionHandler(
+	search *promotion.Search, files []string, timeout time.Duration, limiter *ratelimit.Limiter,
+) *PromotionHandler {
+	return &PromotionHandler{search: search, files: files, timeout: timeout, limiter: limiter}
+}
+
+// maxCodes is the most codes that a request may hold, a request that could
+// never be afforded is an invalid request rather than rate limited.
+func (handler *PromotionHandler) maxCodes() int {
+	return min(maxCodesPerRequest, handler.limiter.Burst())
+}
+
+// Validate validates a batch of codes.
+func (handler *PromotionHandler) Validate(writer http.ResponseWriter, request *http.Request) {
+	var req ValidatePromotionRequest
+
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		writeError(writer, request, fmt.Errorf("%w %w", ErrInvalidRequest, err), err.Error())
+		return
+	}
+
+	if len(req.Codes) == 0 {
+		writeError(writer, request, ErrInvalidRequest, "at least one code must be supplied")
+		return
+	}
+
+	if len(req.Codes) > handler.maxCodes() {
+		writeError(writer, request, ErrInvalidRequest,
+			fmt.Sprintf("at most %d codes may be validated at once", handler.maxCodes()))
+
+		return
+	}
+
+	for _, code := range req.Codes {
+		if strings.TrimSpace(code) == "" {
+			writeError(writer, request, ErrInvalidRequest, "codes cannot be empty")
+			return
+		}
+	}
+
+	results, err := handler.validate(request, req.Codes)
+	if err != nil {
+		writeError(writer, request, err, nil)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(writer).Encode(ValidatePromotionResponse{Results: results}); err != nil {
+		log.Printf("Validate Encoding JSON failed failed: %v", err)
+	}
+}
+
+// GetPromotion validates a single code.
+func (handler *PromotionHandler) GetPromotion(writer http.ResponseWriter, request *http.Request) {
+	code := request.PathValue("code")
+
+	if strings.TrimSpace(code) == "" {
+		writeError(writer, request, ErrInvalidRequest, "codes cannot be empty")
+		return
+	}
+
+	results, err := handler.validate(request, []string{code})
+	if err != nil {
+		writeError(writer, request, err, nil)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(writer).Encode(results[0]); err != nil {
+		log.Printf("GetPromotion Encoding JSON failed failed: %v", err)
+	}
+}
+
+// validate searches for the codes on behalf of the request's client.
+func (handler *PromotionHandler) validate(request *http.Request, codes []string) ([]PromotionResponse, error) {
+	validities, err := handler.lookUp(request.Context(), clientAddress(request), codes)
+	// An incomplete search is an error, rather than a guess.
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PromotionResponse, 0, len(codes))
+
+	for _, code := range codes {
+		results = append(results, PromotionResponse{Code: code, Valid: validities[code].Valid})
+	}
+
+	return results, nil
+}
+
+// IsValidCoupon searches the coupon files for the code, so that orders only
+// redeem valid codes. The client is charged for the code, as it is by the
+// promotion routes, so that orders cannot be used to guess codes. An
+// incomplete search is an error, rather than a guess.
+func (handler *PromotionHandler) IsValidCoupon(ctx context.Context, code, client string) (bool, error) {
+	validities, err := handler.lookUp(ctx, client, []string{code})
+	if err != nil {
+		return false, err
+	}
+
+	return validities[code].Valid, nil
+}
+
+// Available reports whether codes can be validated, that is there is a search
+// and files to search.
+func (handler *PromotionHandler) Available() bool {
+	return handler.search != nil && len(handler.files) > 0
+}
+
+// lookUp charges the client for the codes, and searches for them, for no
+// longer than the timeout.
+func (handler *PromotionHandler) lookUp(
+	ctx context.Context, client string, codes []string,
+) (map[string]promotion.Validity, error) {
+	if !handler.Available() {
+		return nil, ErrPromotionUnavailable
+	}
+
+	if ok, retryAfter := handler.limiter.Take(client, len(codes)); !ok {
+		return nil, rateLimitedError{retryAfter: retryAfter}
+	}
+
+	if handler.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, handler.timeout)
+		defer cancel()
+	}
+
+	return handler.search.IsValidBatch(ctx, codes, handler.files)
+}
+
+// rateLimitedError is ErrRateLimited, with how long the client must wait
+// before trying again.
+type rateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func (e rateLimitedError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrRateLimited, e.retryAfter)
+}
+
+func (e rateLimitedError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// setRetryAfter sets the Retry-After header, in whole seconds, when the
+// client has been rate limited.
+func setRetryAfter(writer http.ResponseWriter, err error) {
+	var limited rateLimitedError
+	if !errors.As(err, &limited) {
+		return
+	}
+
+	seconds := int64(math.Ceil(limited.retryAfter.Seconds()))
+	writer.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+}
+
+// clientAddress is the key the client is rate limited by, the host of the
+// connection's remote address. Forwarding headers are not trusted, as the
+// client can set them to anything.
+func clientAddress(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,247 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/api/handlers"
+	"github.com/shanehowearth/kart/internal/ratelimit"
+	"github.com/shanehowearth/kart/order"
+	"github.com/shanehowearth/kart/order/datastore/inmemoryorderdatastore"
+	"github.com/shanehowearth/kart/product"
+	"github.com/shanehowearth/kart/product/datastore"
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nullStore is a promotion.Store that caches nothing.
+type nullStore struct{}
+
+func (nullStore) GetCodeFileMatchCounts(string, []string) (map[string]promotion.CacheResult, error) {
+	return map[string]promotion.CacheResult{}, nil
+}
+
+func (nullStore) AddCodeFileMatchCounts(string, map[string]int, time.Time, time.Duration) error {
+	return nil
+}
+
+func (nullStore) DeleteCodeFileMatchCounts(string, []string) error { return nil }
+
+func (nullStore) InitialiseDataStore() error { return nil }
+
+func TestPromotionErrorResponses(t *testing.T) {
+	dir := t.TempDir()
+	coupons := filepath.Join(dir, "coupons.txt")
+	require.NoError(t, os.WriteFile(coupons, []byte("FIFTYOFF\n"), 0o600))
+
+	search, err := promotion.NewSearch(nullStore{})
+	require.NoError(t, err)
+
+	// httptest requests come from 192.0.2.1, which has used its only code.
+	exhausted := ratelimit.New(60, 1)
+	exhausted.Take("192.0.2.1", 1)
+
+	testcases := map[string]struct {
+		search             *promotion.Search
+		files              []string
+		limiter            *ratelimit.Limiter
+		cancelled          bool
+		method             string
+		target             string
+		body               string
+		expectedStatus     int
+		expectedCode       string
+		expectedRetryAfter string
+	}{
+		"No coupon files": {
+			search:         search,
+			method:         http.MethodGet,
+			target:         "/api/promotion/FIFTYOFF",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   handlers.ErrorCodeCouponUnavailable,
+		},
+		"Malformed body": {
+			search:         search,
+			files:          []string{coupons},
+			method:         http.MethodPost,
+			target:         "/api/promotion/validate",
+			body:           `{"codes": [`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   handlers.ErrorCodeInvalidRequest,
+		},
+		"No codes": {
+			search:         search,
+			files:          []string{coupons},
+			method:         http.MethodPost,
+			target:         "/api/promotion/validate",
+			body:           `{"codes": []}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   handlers.ErrorCodeInvalidRequest,
+		},
+		"Empty code": {
+			search:         search,
+			files:          []string{coupons},
+			method:         http.MethodPost,
+			target:         "/api/promotion/validate",
+			body:           `{"codes": ["FIFTYOFF", " "]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   handlers.ErrorCodeInvalidRequest,
+		},
+		"More codes than the burst": {
+			search:         search,
+			files:          []string{coupons},
+			limiter:        ratelimit.New(60, 2),
+			method:         http.MethodPost,
+			target:         "/api/promotion/validate",
+			body:           `{"codes": ["A", "B", "C"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   handlers.ErrorCodeInvalidRequest,
+		},
+		"Rate limited": {
+			search:             search,
+			files:              []string{coupons},
+			limiter:            exhausted,
+			method:             http.MethodGet,
+			target:             "/api/promotion/FIFTYOFF",
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedCode:       handlers.ErrorCodeRateLimited,
+			expectedRetryAfter: "1",
+		},
+		"Unreadable coupon file": {
+			search:         search,
+			files:          []string{coupons, filepath.Join(dir, "missing.txt")},
+			method:         http.MethodGet,
+			target:         "/api/promotion/FIFTYOFF",
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   handlers.ErrorCodeCouponSearch,
+		},
+		"Client went away": {
+			search:         search,
+			files:          []string{coupons},
+			cancelled:      true,
+			method:         http.MethodGet,
+			target:         "/api/promotion/FIFTYOFF",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   handlers.ErrorCodeCouponUnavailable,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			promotionHandler := handlers.NewPromotionHandler(tc.search, tc.files, 0, tc.limiter)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /api/promotion/validate", promotionHandler.Validate)
+			mux.HandleFunc("GET /api/promotion/{code}", promotionHandler.GetPromotion)
+
+			request := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+
+			if tc.cancelled {
+				ctx, cancel := context.WithCancel(request.Context())
+				cancel()
+
+				request = request.WithContext(ctx)
+			}
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			assert.Equal(t, tc.expectedRetryAfter, recorder.Header().Get("Retry-After"))
+
+			var actual handlers.ErrorResponse
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&actual))
+
+			assert.Equal(t, tc.expectedCode, actual.Code)
+		})
+	}
+}
+
+// unlimitedCoupons redeems every coupon.
+type unlimitedCoupons struct{}
+
+func (unlimitedCoupons) RedeemCoupon(string, string, string) error { return nil }
+
+func (unlimitedCoupons) ReleaseCoupon(string) error { return nil }
+
+func TestOrderCouponLookups(t *testing.T) {
+	// Valid codes are in at least two files.
+	dir := t.TempDir()
+	coupons := []string{filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")}
+
+	for _, file := range coupons {
+		require.NoError(t, os.WriteFile(file, []byte("FIFTYOFF\n"), 0o600))
+	}
+
+	search, err := promotion.NewSearch(nullStore{})
+	require.NoError(t, err)
+
+	productService, err := product.NewProductService(datastore.NewSeededInMemoryProductStore())
+	require.NoError(t, err)
+
+	body := `{"couponCode": "FIFTYOFF", "items": [{"productId": "1", "quantity": 1}]}`
+
+	testcases := map[string]struct {
+		orders             int
+		cancelled          bool
+		expectedStatus     int
+		expectedRetryAfter string
+	}{
+		"Within the limit": {
+			orders:         1,
+			expectedStatus: http.StatusCreated,
+		},
+		"Orders share the limit of coupon lookups": {
+			orders:             2,
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRetryAfter: "60",
+		},
+		"Client went away": {
+			orders:         1,
+			cancelled:      true,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			// Each client may look up one code a minute.
+			promotionHandler := handlers.NewPromotionHandler(search, coupons, 0, ratelimit.New(1, 1))
+
+			orderService, err := order.NewOrderService(
+				inmemoryorderdatastore.NewInMemoryOrderStore(),
+				productService,
+				order.WithCouponRedeemer(unlimitedCoupons{}),
+				order.WithCouponValidator(promotionHandler),
+			)
+			require.NoError(t, err)
+
+			orderHandler := handlers.NewOrderHandler(orderService)
+
+			var recorder *httptest.ResponseRecorder
+
+			for range tc.orders {
+				request := httptest.NewRequest(http.MethodPost, "/api/order", strings.NewReader(body))
+
+				if tc.cancelled {
+					ctx, cancel := context.WithCancel(request.Context())
+					cancel()
+
+					request = request.WithContext(ctx)
+				}
+
+				recorder = httptest.NewRecorder()
+				orderHandler.CreateOrder(recorder, request)
+			}
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			assert.Equal(t, tc.expectedRetryAfter, recorder.Header().Get("Retry-After"))
+		})
+	}
+}
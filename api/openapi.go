@@ -0,0 +1,22 @@
+package api
+
+import (
+	_ "embed" // Required for go:embed.
+	"log"
+	"net/http"
+)
+
+// OpenAPISpec is the OpenAPI document describing the API. It is the contract
+// that the handlers are tested against.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// serveOpenAPISpec returns the OpenAPI document.
+func serveOpenAPISpec(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	if _, err := writer.Write(OpenAPISpec); err != nil {
+		log.Printf("serveOpenAPISpec writing failed: %v", err)
+	}
+}
@@ -8,55 +8,37 @@ import (
 	"github.com/shanehowearth/kart/product"
 )
 
-// Allow anyone access to the API (Cross origin requests)
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// NOTE: In production, replace "http://localhost:3000" with actual domain.
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-
-		// Set allowed methods (needed for preflight and actual request)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-
-		// Set allowed headers (essential for custom headers like Authorization and Content-Type)
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		// If it's an OPTIONS request, we send the headers and respond with 200 OK immediately,
-		// preventing the request from reaching the actual handler.
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		// Call the next handler in the chain
-		next.ServeHTTP(w, r)
-	})
-}
-
 // RegisterRoutes register all the routes for the API.
+// Every route gets a preflight (OPTIONS) route, which answers according to the
+// CORS policy. The promotion handler is made by the caller, as it also
+// validates the coupon codes of orders.
 func RegisterRoutes(
 	mux *http.ServeMux,
+	cors CORSPolicy,
 	orderService *order.Service,
 	productService *product.Service,
+	promotionHandler *handlers.PromotionHandler,
 ) {
 	productHandler := handlers.NewProductHandler(productService)
 	orderHandler := handlers.NewOrderHandler(orderService)
 
+	routes := newRouter(mux, cors)
+
 	// Order routes.
-	mux.Handle("GET /api/order/{id}", CORSMiddleware(http.HandlerFunc(orderHandler.GetOrder)))
-	mux.Handle("POST /api/order", CORSMiddleware(http.HandlerFunc(orderHandler.CreateOrder)))
-	// Allow OPTIONS in order to prevent a CORS issue.
-	mux.Handle(
-		"OPTIONS /api/order",
-		CORSMiddleware(
-			http.HandlerFunc(
-				func(w http.ResponseWriter, r *http.Request) {
-					w.WriteHeader(http.StatusOK)
-				},
-			),
-		),
-	)
+	routes.handle(http.MethodGet, "/api/order/{id}", http.HandlerFunc(orderHandler.GetOrder))
+	routes.handle(http.MethodPost, "/api/order", http.HandlerFunc(orderHandler.CreateOrder))
+	routes.handle(http.MethodPost, "/api/order/{id}/cancel", http.HandlerFunc(orderHandler.CancelOrder))
 
 	// Product routes.
-	mux.Handle("GET /api/product", CORSMiddleware(http.HandlerFunc(productHandler.ListProducts)))
-	mux.Handle("GET /api/product/{id}", CORSMiddleware(http.HandlerFunc(productHandler.GetProduct)))
+	routes.handle(http.MethodGet, "/api/product", http.HandlerFunc(productHandler.ListProducts))
+	routes.handle(http.MethodGet, "/api/product/{id}", http.HandlerFunc(productHandler.GetProduct))
+
+	// Promotion routes.
+	routes.handle(http.MethodPost, "/api/promotion/validate", http.HandlerFunc(promotionHandler.Validate))
+	routes.handle(http.MethodGet, "/api/promotion/{code}", http.HandlerFunc(promotionHandler.GetPromotion))
+
+	// Documentation.
+	routes.handle(http.MethodGet, "/api/openapi.json", http.HandlerFunc(serveOpenAPISpec))
+
+	routes.registerPreflights()
 }
@@ -0,0 +1,204 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// openAPIDocument is the subset of an OpenAPI 3 document that the contract
+// tests need.
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse `json:"responses"`
+		Schemas   map[string]*jsonSchema     `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *jsonSchema `json:"schema"`
+	} `json:"content"`
+}
+
+// jsonSchema is the subset of JSON Schema used by the API document.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+}
+
+func loadOpenAPIDocument(t *testing.T, data []byte) *openAPIDocument {
+	t.Helper()
+
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(data, &doc))
+
+	return &doc
+}
+
+// operation finds the documented operation matching the request method and
+// path, returning the path template it was found under. Several templates can
+// match a path, eg. /api/promotion/validate and /api/promotion/{code}, so the
+// method decides between them.
+func (d *openAPIDocument) operation(method, path string) (string, openAPIOperation, bool) {
+	for template, operations := range d.Paths {
+		if !matchPathTemplate(template, path) {
+			continue
+		}
+
+		if operation, ok := operations[strings.ToLower(method)]; ok {
+			return template, operation, true
+		}
+	}
+
+	return "", openAPIOperation{}, false
+}
+
+// matchPathTemplate reports whether the path matches an OpenAPI path template
+// such as /api/order/{id}.
+func matchPathTemplate(template, path string) bool {
+	templateParts := strings.Split(template, "/")
+	pathParts := strings.Split(path, "/")
+
+	if len(templateParts) != len(pathParts) {
+		return false
+	}
+
+	for i, part := range templateParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			continue
+		}
+
+		if part != pathParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// responseSchema returns the JSON schema of the documented response for the
+// status code.
+func (d *openAPIDocument) responseSchema(operation openAPIOperation, status int) (*jsonSchema, error) {
+	response, ok := operation.Responses[fmt.Sprint(status)]
+	if !ok {
+		return nil, fmt.Errorf("status %d is not documented for %s", status, operation.OperationID)
+	}
+
+	if response.Ref != "" {
+		response, ok = d.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+		if !ok {
+			return nil, fmt.Errorf("unresolved response reference %s", response.Ref)
+		}
+	}
+
+	content, ok := response.Content["application/json"]
+	if !ok || content.Schema == nil {
+		return nil, fmt.Errorf("status %d of %s has no JSON schema", status, operation.OperationID)
+	}
+
+	return content.Schema, nil
+}
+
+// validate checks the decoded JSON value against the schema, returning every
+// violation found.
+func (d *openAPIDocument) validate(schema *jsonSchema, value any, location string) []string {
+	if schema.Ref != "" {
+		resolved, ok := d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved schema reference %s", location, schema.Ref)}
+		}
+
+		return d.validate(resolved, value, location)
+	}
+
+	problems := []string{}
+
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", location, value, schema.Enum))
+	}
+
+	switch schema.Type {
+	case "":
+		// Any value is allowed.
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected object, got %T", location, value))
+		}
+
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required property %q", location, name))
+			}
+		}
+
+		for name, propertyValue := range object {
+			propertySchema, ok := schema.Properties[name]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					problems = append(problems, fmt.Sprintf("%s: unexpected property %q", location, name))
+				}
+
+				continue
+			}
+
+			problems = append(problems, d.validate(propertySchema, propertyValue, location+"."+name)...)
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected array, got %T", location, value))
+		}
+
+		if schema.Items != nil {
+			for i, item := range array {
+				problems = append(problems, d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", location, i))...)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected string, got %T", location, value))
+		}
+
+		if schema.Pattern != "" && !regexp.MustCompile(schema.Pattern).MatchString(str) {
+			problems = append(problems, fmt.Sprintf("%s: %q does not match %s", location, str, schema.Pattern))
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			problems = append(problems, fmt.Sprintf("%s: expected integer, got %v", location, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected number, got %T", location, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected boolean, got %T", location, value))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s: unsupported schema type %q", location, schema.Type))
+	}
+
+	return problems
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// runCache inspects, and maintains, the cached search results.
+func runCache(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 1
+	}
+
+	switch args[0] {
+	case "stats":
+		return runCacheStats(args[1:])
+	case "list":
+		return runCacheList(args[1:])
+	case "get":
+		return runCacheGet(args[1:])
+	case "delete":
+		return runCacheDelete(args[1:])
+	case "prune":
+		return runCachePrune(args[1:])
+	case "export":
+		return runCacheExport(args[1:])
+	case "import":
+		return runCacheImport(args[1:])
+	default:
+		usage()
+		return 1
+	}
+}
+
+// addFileSetFlag adds the -file-set flag.
+func addFileSetFlag(flags *flag.FlagSet) *string {
+	return flags.String("file-set", "", "only the results of the file set, as shown by cache list")
+}
+
+// addCouponsFlag adds the -coupons flag, for the coupon files that results
+// are moved between machines with.
+func addCouponsFlag(flags *flag.FlagSet, usage string) *stringSlice {
+	coupons := &stringSlice{}
+	flags.Var(coupons, "coupons", usage+" (can be specified multiple times)")
+
+	return coupons
+}
+
+// runCacheStats summarises the cache.
+func runCacheStats(args []string) int {
+	flags := flag.NewFlagSet("cache stats", flag.ContinueOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 0 {
+		usage()
+		return 1
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the cache with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	stats, err := store.CacheStats(time.Now())
+	if err != nil {
+		log.Printf("cannot summarise the cache with error %v", err)
+		return 1
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(table, "entries\t%d\n", stats.Entries)
+	fmt.Fprintf(table, "file sets\t%d\n", stats.FileSets)
+	fmt.Fprintf(table, "expired\t%d\n", stats.Expired)
+	fmt.Fprintf(table, "oldest\t%s\n", formatCacheTime(stats.Oldest))
+	fmt.Fprintf(table, "newest\t%s\n", formatCacheTime(stats.Newest))
+
+	if err := table.Flush(); err != nil {
+		log.Printf("cannot write the stats with error %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// runCacheList lists the cached results.
+func runCacheList(args []string) int {
+	flags := flag.NewFlagSet("cache list", flag.ContinueOnError)
+	fileSet := addFileSetFlag(flags)
+	expired := flags.Bool("expired", false, "only the results that have expired")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 0 {
+		usage()
+		return 1
+	}
+
+	filter := promotion.CacheFilter{FileSet: *fileSet}
+	if *expired {
+		filter.ExpiredAt = time.Now()
+	}
+
+	return listCacheEntries(filter)
+}
+
+// runCacheGet lists the cached results of the codes.
+func runCacheGet(args []string) int {
+	flags := flag.NewFlagSet("cache get", flag.ContinueOnError)
+	fileSet := addFileSetFlag(flags)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() == 0 {
+		usage()
+		return 1
+	}
+
+	return listCacheEntries(promotion.CacheFilter{FileSet: *fileSet, Codes: cacheCodes(flags.Args())})
+}
+
+func listCacheEntries(filter promotion.CacheFilter) int {
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the cache with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	entries, err := store.ListCacheEntries(filter)
+	if err != nil {
+		log.Printf("cannot list the cache with error %v", err)
+		return 1
+	}
+
+	now := time.Now()
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "FILE SET\tCODE\tFILES\tCREATED\tEXPIRES")
+
+	for _, entry := range entries {
+		expires := "never"
+		if entry.TTL > 0 {
+			expires = formatCacheTime(entry.CreatedAt.Add(entry.TTL))
+		}
+
+		if entry.Expired(now) {
+			expires += " (expired)"
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n",
+			entry.FileSet, entry.Code, entry.MatchCount, formatCacheTime(entry.CreatedAt), expires)
+	}
+
+	if err := table.Flush(); err != nil {
+		log.Printf("cannot write the cache with error %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// runCacheDelete removes cached results, so that the codes are searched for
+// again.
+func runCacheDelete(args []string) int {
+	flags := flag.NewFlagSet("cache delete", flag.ContinueOnError)
+	fileSet := addFileSetFlag(flags)
+	all := flags.Bool("all", false, "delete every cached result")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	// Deleting everything must be asked for, rather than being what a
+	// forgotten code does.
+	everything := flags.NArg() == 0 && *fileSet == ""
+	if everything != *all {
+		usage()
+		return 1
+	}
+
+	return deleteCacheEntries(promotion.CacheFilter{FileSet: *fileSet, Codes: cacheCodes(flags.Args())})
+}
+
+// runCachePrune removes the results that have expired.
+func runCachePrune(args []string) int {
+	flags := flag.NewFlagSet("cache prune", flag.ContinueOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 0 {
+		usage()
+		return 1
+	}
+
+	return deleteCacheEntries(promotion.CacheFilter{ExpiredAt: time.Now()})
+}
+
+func deleteCacheEntries(filter promotion.CacheFilter) int {
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the cache with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	deleted, err := store.DeleteCacheEntries(filter)
+	if err != nil {
+		log.Printf("cannot delete from the cache with error %v", err)
+		return 1
+	}
+
+	fmt.Printf("deleted %d cached results\n", deleted)
+
+	return 0
+}
+
+// runCacheExport writes the cached results as JSON lines. The results of
+// coupon files are exported with the files' content, so that they can be
+// imported for copies of the files elsewhere.
+func runCacheExport(args []string) int {
+	flags := flag.NewFlagSet("cache export", flag.ContinueOnError)
+	fileSet := addFileSetFlag(flags)
+	coupons := addCouponsFlag(flags, "only the results of the coupon file, recording its content for cache import")
+	output := flags.String("o", "", "file to write the results to, it must not exist (default standard output)")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 0 {
+		usage()
+		return 1
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the cache with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	entries, err := store.ListCacheEntries(promotion.CacheFilter{FileSet: *fileSet})
+	if err != nil {
+		log.Printf("cannot list the cache with error %v", err)
+		return 1
+	}
+
+	if len(*coupons) > 0 {
+		if entries, err = promotion.CacheEntriesOf(entries, *coupons); err != nil {
+			log.Printf("cannot export the results of the coupon files with error %v", err)
+			return 1
+		}
+	}
+
+	if err := writeCacheEntries(*output, entries); err != nil {
+		log.Printf("cannot export the cache with error %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// writeCacheEntries writes the entries to the file, or to standard output when
+// file is "". An existing file is never overwritten.
+func writeCacheEntries(file string, entries []promotion.CacheEntry) error {
+	if file == "" {
+		return promotion.WriteCacheEntries(os.Stdout, entries)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := promotion.WriteCacheEntries(f, entries); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// runCacheImport caches the results exported by cache export, replacing those
+// already cached. Results exported with their coupon files are moved to the
+// copies of the files given, wherever they are.
+func runCacheImport(args []string) int {
+	flags := flag.NewFlagSet("cache import", flag.ContinueOnError)
+	coupons := addCouponsFlag(flags, "the copy of a coupon file the results were exported with")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() > 1 {
+		usage()
+		return 1
+	}
+
+	entries, err := readCacheEntries(flags.Arg(0))
+	if err != nil {
+		log.Printf("cannot read the results with error %v", err)
+		return 1
+	}
+
+	if len(*coupons) > 0 {
+		if entries, err = promotion.RekeyCacheEntries(entries, *coupons); err != nil {
+			log.Printf("cannot import the results for the coupon files with error %v", err)
+			return 1
+		}
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the cache with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	if err := store.ImportCacheEntries(entries); err != nil {
+		log.Printf("cannot import the results with error %v", err)
+		return 1
+	}
+
+	fmt.Printf("imported %d cached results\n", len(entries))
+
+	return 0
+}
+
+// readCacheEntries reads the entries from the file, or from standard input
+// when file is "" or -.
+func readCacheEntries(file string) ([]promotion.CacheEntry, error) {
+	if file == "" || file == promotion.StdinPath {
+		return promotion.ReadCacheEntries(os.Stdin)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := promotion.ReadCacheEntries(f)
+	if errors.Is(err, promotion.ErrInvalidCacheEntry) {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return entries, err
+}
+
+// cacheCodes upper cases the codes, as they are cached.
+func cacheCodes(codes []string) []string {
+	upper := make([]string, 0, len(codes))
+
+	for _, code := range codes {
+		upper = append(upper, strings.ToUpper(code))
+	}
+
+	return upper
+}
+
+// formatCacheTime shows a time, or "-" for the zero time.
+func formatCacheTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.DateTime)
+}
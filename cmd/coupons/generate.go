@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// defaultCodeLength gives 31^8 (almost a trillion) possible codes from the
+// default alphabet, far too many to guess.
+const defaultCodeLength = 8
+
+// runGenerate writes new, unique, codes, one per line, none of which are in
+// the files.
+func runGenerate(args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	count := flags.Int("n", 0, "number of codes to generate")
+	length := flags.Int("length", defaultCodeLength, "number of random characters in each code")
+	alphabet := flags.String("alphabet", "", "characters the codes are made of (default "+promotion.DefaultAlphabet+")")
+	prefix := flags.String("prefix", "", "prefix of every code, eg. SUMMER")
+	checkCharacter := flags.Bool("check-digit", false, "append a check character, to catch mistyped codes")
+	allowAmbiguous := flags.Bool("allow-ambiguous", false,
+		"allow the ambiguous characters "+promotion.AmbiguousCharacters+" in -alphabet")
+	output := flags.String("o", "", "file to write the codes to, it must not exist (default standard output)")
+	reader := addReaderFlag(flags)
+	limits := addLimitFlags(flags)
+	timeout := addTimeoutFlag(flags)
+	progressMode := addProgressFlag(flags)
+	source := addSourceFlag(flags)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if *count < 1 {
+		log.Printf("-n must be at least 1")
+		return 1
+	}
+
+	// Remaining args are the files that the codes must not already be in.
+	files, err := resolveFiles(*source, flags.Args())
+	if err != nil {
+		log.Printf("cannot find the files with error %v", err)
+		return 1
+	}
+
+	generator, err := promotion.NewCodeGenerator(promotion.CodeFormat{
+		Alphabet:       *alphabet,
+		Length:         *length,
+		Prefix:         *prefix,
+		CheckCharacter: *checkCharacter,
+		AllowAmbiguous: *allowAmbiguous,
+	}, rand.Reader)
+	if err != nil {
+		log.Printf("cannot generate codes with error %v", err)
+		return 1
+	}
+
+	pool, err := limits.pool()
+	if err != nil {
+		log.Printf("cannot limit the search with error %v", err)
+		return 1
+	}
+
+	progress, err := newProgressPrinter(*progressMode)
+	if err != nil {
+		log.Printf("cannot show progress with error %v", err)
+		return 1
+	}
+
+	ctx, cancel := searchContext(*timeout)
+	defer cancel()
+
+	// Codes are compared with the files the same way a search compares them,
+	// so a code is not issued twice in a different case.
+	scanner := promotion.Scanner{
+		Normalisation: promotion.DefaultNormalisation,
+		Reader:        reader.ReadStrategy,
+		Pool:          pool,
+		Progress:      progress.callback(),
+	}
+
+	codes, err := generator.GenerateAvoiding(ctx, *count, scanner, files)
+	progress.finish()
+
+	if errors.Is(err, promotion.ErrCancelled) {
+		log.Printf("generate stopped: %v", err)
+		return exitCancelled
+	}
+
+	if err != nil {
+		log.Printf("cannot generate codes with error %v", err)
+		return 1
+	}
+
+	if err := writeCodes(*output, codes); err != nil {
+		log.Printf("cannot write the codes with error %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// writeCodes writes the codes, one per line, to the file, or to standard
+// output when file is "". An existing file is never overwritten, so codes
+// already issued are not lost.
+func writeCodes(file string, codes []string) error {
+	if file == "" {
+		return writeCodeLines(os.Stdout, codes)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := writeCodeLines(f, codes); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeCodeLines(w io.Writer, codes []string) error {
+	writer := bufio.NewWriter(w)
+
+	for _, code := range codes {
+		fmt.Fprintln(writer, code)
+	}
+
+	return writer.Flush()
+}
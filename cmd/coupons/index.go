@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// runIndex builds (or refreshes) the index of each file. Files whose index is
+// up to date are skipped, so it is cheap to run after every feed update.
+func runIndex(args []string) int {
+	flags := flag.NewFlagSet("index", flag.ContinueOnError)
+	indexDir := flags.String("index-dir", defaultIndexDir, "directory to write the indexes to")
+	normalisation := addNormalisationFlag(flags)
+	source := addSourceFlag(flags)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	files, err := resolveFiles(*source, flags.Args())
+	if err != nil {
+		log.Printf("cannot find the files with error %v", err)
+		return 1
+	}
+
+	if len(files) == 0 {
+		usage()
+		return 1
+	}
+
+	indexes, err := promotion.NewIndexDir(*indexDir, normalisation.Normalisation)
+	if err != nil {
+		log.Printf("cannot use index directory with error %v", err)
+		return 1
+	}
+
+	exitCode := 0
+
+	for _, file := range files {
+		rebuilt, err := indexes.Build(file)
+		if err != nil {
+			log.Printf("cannot index %s with error %v", file, err)
+
+			exitCode = 1
+
+			continue
+		}
+
+		status := "up to date"
+		if rebuilt {
+			status = "indexed"
+		}
+
+		fmt.Printf("%s: %s\n", file, status)
+	}
+
+	return exitCode
+}
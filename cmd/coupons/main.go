@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/shanehowearth/kart/promotion"
-	"github.com/shanehowearth/kart/promotion/datastore/sqlite"
 )
 
+// exitCancelled is the exit code when a search is interrupted, or runs out of
+// time.
+const exitCancelled = 3
+
+// defaultIndexDir is where `coupons index` writes the indexes, and where a
+// search looks for them.
+const defaultIndexDir = ".coupon-index"
+
 // Prepare some storage for the patterns passed in by users.
 type stringSlice []string
 
@@ -23,52 +37,259 @@ func (s *stringSlice) Set(value string) error {
 	return nil
 }
 
-func main() {
-	// Initialise dependencies.
-	promotionStore := &sqlite.Driver{}
-	if err := promotionStore.InitialiseDataStore(); err != nil {
-		log.Fatalf("cannot initialise promotion datastore with error: %v", err)
+var errStdinTwice = errors.New("standard input cannot be both the patterns file and a coupon file")
+
+// patternFlags are the flags for the codes to look for.
+type patternFlags struct {
+	patterns stringSlice
+	file     *string
+}
+
+// addPatternFlags adds the -p and -patterns-file flags.
+func addPatternFlags(flags *flag.FlagSet, usage string) *patternFlags {
+	patterns := &patternFlags{}
+	flags.Var(&patterns.patterns, "p", usage+" (can be specified multiple times)")
+	patterns.file = flags.String("patterns-file", "",
+		"file of codes, one per line, to use as well as -p (- reads standard input)")
+
+	return patterns
+}
+
+// load returns the -p codes, followed by those in the -patterns-file, without
+// duplicates. The files are those to be searched, which cannot also read
+// standard input.
+func (p *patternFlags) load(files []string) ([]string, error) {
+	patterns := slices.Clone(p.patterns)
+
+	if *p.file == "" {
+		return promotion.UniquePatterns(patterns), nil
 	}
 
-	promotionSearch, err := promotion.NewSearch(promotionStore)
+	reader := io.Reader(os.Stdin)
+
+	if *p.file == promotion.StdinPath {
+		if slices.Contains(files, promotion.StdinPath) {
+			return nil, errStdinTwice
+		}
+	} else {
+		f, err := os.Open(*p.file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		reader = f
+	}
+
+	read, err := promotion.ReadPatterns(reader)
 	if err != nil {
-		log.Fatalf("cannot create a promotion search with error %v", err)
+		return nil, fmt.Errorf("%s: %w", *p.file, err)
 	}
 
-	// Parse flags
-	var patterns stringSlice
-	flag.Var(&patterns, "p", "promotion code to search (can be specified multiple times)")
-	flag.Parse()
+	return promotion.UniquePatterns(append(patterns, read...)), nil
+}
 
-	// Remaining args are files
-	files := flag.Args()
+// normalisationFlag is a flag for the normalisation applied to lines, eg.
+// "case,crlf,space", or "none".
+type normalisationFlag struct {
+	promotion.Normalisation
+}
 
-	if len(patterns) == 0 || len(files) == 0 {
-		// Require at least one pattern and at least one file to be passed in.
-		log.Fatalf("Usage: %s -p <pattern> [-p <pattern2>...] <file1> [file2] [file3]...\n", os.Args[0])
+func (n *normalisationFlag) Set(value string) error {
+	normalisation, err := promotion.ParseNormalisation(value)
+	if err != nil {
+		return err
 	}
 
-	// Crude check that the files aren't gzipped.
-	// A deeper inspection is possible once the files have been opened, and the
-	// magic number inspected.
-	for _, file := range files {
-		if _, err := os.Stat(file); err != nil {
-			log.Fatalf("File does not exist: %s", file)
-		}
-		if strings.HasSuffix(file, ".gz") {
-			log.Fatalf("ERROR: Found gzipped file %s - all files must be uncompressed.",
-				file)
-		}
+	n.Normalisation = normalisation
+
+	return nil
+}
+
+// addNormalisationFlag adds the -normalise flag, defaulting to
+// promotion.DefaultNormalisation.
+func addNormalisationFlag(flags *flag.FlagSet) *normalisationFlag {
+	normalisation := &normalisationFlag{promotion.DefaultNormalisation}
+	flags.Var(normalisation, "normalise",
+		"changes made to lines before matching, comma separated: case, crlf, space, nfkc, or none")
+
+	return normalisation
+}
+
+// readerFlag is a flag for the strategy used to read files.
+type readerFlag struct {
+	promotion.ReadStrategy
+}
+
+func (r *readerFlag) Set(value string) error {
+	strategy, err := promotion.ParseReadStrategy(value)
+	if err != nil {
+		return err
 	}
 
-	results := promotionSearch.IsValidBatch(patterns, files)
+	r.ReadStrategy = strategy
 
-	for pattern, isValid := range results {
-		validity := "an invalid"
-		if isValid {
-			validity = "a valid"
-		}
+	return nil
+}
+
+// addReaderFlag adds the -reader flag, defaulting to promotion.ReadAuto.
+func addReaderFlag(flags *flag.FlagSet) *readerFlag {
+	reader := &readerFlag{promotion.ReadAuto}
+	flags.Var(reader, "reader",
+		"how files are read: auto, mmap, pread, or stream (auto uses pread for network file systems, stream for pipes)")
 
-		fmt.Printf("%s is %s coupon\n", pattern, validity)
+	return reader
+}
+
+// errInvalidSize is returned for a -max-mapped that is not a size.
+var errInvalidSize = errors.New("invalid size, use bytes, or a number with a K, M, or G suffix")
+
+// byteSize is a flag for a number of bytes, with an optional binary suffix,
+// eg. "512M", or "2GiB".
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(value), "B"), "I")
+	shift := 0
+
+	switch {
+	case strings.HasSuffix(number, "K"):
+		shift = 10
+	case strings.HasSuffix(number, "M"):
+		shift = 20
+	case strings.HasSuffix(number, "G"):
+		shift = 30
+	}
+
+	if shift > 0 {
+		number = number[:len(number)-1]
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 || size > (1<<63-1)>>shift {
+		return fmt.Errorf("%w: %q", errInvalidSize, value)
+	}
+
+	*b = byteSize(size << shift)
+
+	return nil
+}
+
+// limitFlags are the flags for the resources a search may use.
+type limitFlags struct {
+	workers   *int
+	maxFiles  *int
+	maxMapped byteSize
+}
+
+// addLimitFlags adds the -workers, -max-files, and -max-mapped flags, which
+// default to no limits beyond GOMAXPROCS workers.
+func addLimitFlags(flags *flag.FlagSet) *limitFlags {
+	limits := &limitFlags{
+		workers:  flags.Int("workers", 0, "number of chunks searched at once, across all files (default GOMAXPROCS)"),
+		maxFiles: flags.Int("max-files", 0, "number of files searched at once (default no limit)"),
 	}
+	flags.Var(&limits.maxMapped, "max-mapped",
+		"total size of the files mmapped at once, eg. 512M, larger files are read with pread (default no limit)")
+
+	return limits
+}
+
+// pool returns a pool, shared by every file searched, that enforces the
+// limits.
+func (l *limitFlags) pool() (*promotion.Pool, error) {
+	return promotion.NewPool(promotion.Limits{
+		Workers:        *l.workers,
+		MaxFiles:       *l.maxFiles,
+		MaxMappedBytes: int64(l.maxMapped),
+	})
+}
+
+// addTimeoutFlag adds the -timeout flag, zero is no timeout.
+func addTimeoutFlag(flags *flag.FlagSet) *time.Duration {
+	return flags.Duration("timeout", 0, "stop searching after this long, eg. 30s (default no timeout)")
+}
+
+// searchContext returns a context that is cancelled by Ctrl-C (or SIGTERM),
+// or when the timeout, if there is one, runs out.
+func searchContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to the subcommand and returns the process exit code. The exit
+// code is returned, rather than calling os.Exit directly, so that deferred
+// clean up (eg. closing the promotion store) always happens.
+// A search is the default, so `coupons -p CODE file...` keeps working.
+func run(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "search":
+			return runSearch(args[1:])
+		case "index":
+			return runIndex(args[1:])
+		case "report":
+			return runReport(args[1:])
+		case "source":
+			return runSource(args[1:])
+		case "redemption":
+			return runRedemption(args[1:])
+		case "generate":
+			return runGenerate(args[1:])
+		case "cache":
+			return runCache(args[1:])
+		case "help", "-h", "-help", "--help":
+			usage()
+			return 0
+		}
+	}
+
+	return runSearch(args)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  %[1]s [search] [-normalise LIST] [-reader auto|mmap|pread|stream] [LIMITS] [-no-cache | -refresh] [-cache-ttl DURATION] [-rules FILE -campaign NAME] [-on-error fail|partial] PATTERNS FILES
+  %[1]s index [-normalise LIST] [-index-dir DIR] FILES
+  %[1]s report [-normalise LIST] [-reader auto|mmap|pread|stream] [LIMITS] [-format table|json|csv] PATTERNS FILES
+  %[1]s source add [-description TEXT] <set> <file1> [file2]...
+  %[1]s source list [set]
+  %[1]s source remove <set> [file1]...
+  %[1]s source verify [set]
+  %[1]s redemption limit [-max-uses N] [-max-uses-per-customer N] [-from TIME] [-until TIME] <code>
+  %[1]s redemption show <code>
+  %[1]s generate -n N [-length N] [-alphabet CHARS] [-prefix PREFIX] [-check-digit] [-allow-ambiguous] [-o FILE] [LIMITS] [FILES]
+  %[1]s cache stats
+  %[1]s cache list [-file-set KEY] [-expired]
+  %[1]s cache get [-file-set KEY] <code1> [code2]...
+  %[1]s cache delete [-file-set KEY] [code1]... | -all
+  %[1]s cache prune
+  %[1]s cache export [-file-set KEY] [-coupons FILE]... [-o FILE]
+  %[1]s cache import [-coupons FILE]... [FILE]
+
+PATTERNS are -p <pattern> [-p <pattern2>...], and/or -patterns-file <file> of one code per line (- reads standard input).
+
+FILES are <file1> [file2]..., or -source <set> for the files registered with source add.
+
+LIMITS are [-workers N] [-max-files N] [-max-mapped SIZE] [-timeout DURATION].
+
+A file name of - searches standard input.
+`, os.Args[0])
 }
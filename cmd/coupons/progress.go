@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// -progress values.
+const (
+	progressAuto = "auto"
+	progressBar  = "bar"
+	progressLog  = "log"
+	progressNone = "none"
+)
+
+// progressLogInterval is the least time between progress log lines, a bar is
+// redrawn for every event.
+const progressLogInterval = 5 * time.Second
+
+// progressBarWidth is the number of characters in the bar itself.
+const progressBarWidth = 30
+
+var errUnknownProgress = errors.New("unknown -progress, use auto, bar, log, or none")
+
+// addProgressFlag adds the -progress flag.
+func addProgressFlag(flags *flag.FlagSet) *string {
+	return flags.String("progress", progressAuto,
+		"how progress is shown: auto (a bar on a terminal, log lines otherwise), bar, log, or none")
+}
+
+// progressPrinter shows the progress of a search on stderr.
+type progressPrinter struct {
+	mode string
+	w    io.Writer
+	// lastLog is when the last log line was written, searches that finish
+	// within progressLogInterval log nothing.
+	lastLog time.Time
+	logged  bool
+	// width is the length of the last bar drawn, so that it can be erased.
+	width int
+}
+
+// newProgressPrinter returns a printer for the -progress mode.
+func newProgressPrinter(mode string) (*progressPrinter, error) {
+	switch mode {
+	case progressAuto:
+		mode = progressLog
+
+		if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			mode = progressBar
+		}
+	case progressBar, progressLog, progressNone:
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownProgress, mode)
+	}
+
+	return &progressPrinter{mode: mode, w: os.Stderr, lastLog: time.Now()}, nil
+}
+
+// callback is the promotion.Scanner.Progress func, nil when progress is not
+// shown, so that it is not tracked either.
+func (p *progressPrinter) callback() func(promotion.Progress) {
+	if p.mode == progressNone {
+		return nil
+	}
+
+	return p.publish
+}
+
+// publish shows the progress.
+func (p *progressPrinter) publish(progress promotion.Progress) {
+	if p.mode == progressBar {
+		line := "\r" + progressLine(progress, true)
+		fmt.Fprint(p.w, line+strings.Repeat(" ", max(p.width-len(line), 0)))
+		p.width = len(line)
+
+		return
+	}
+
+	// The last line is logged, once anything has been, to show the search
+	// finished.
+	allDone := p.logged && progress.FilesDone == progress.Files
+	if !allDone && time.Since(p.lastLog) < progressLogInterval {
+		return
+	}
+
+	p.lastLog = time.Now()
+	p.logged = true
+	log.Print(progressLine(progress, false))
+}
+
+// finish ends the bar's line, so that what is printed next starts on a line
+// of its own.
+func (p *progressPrinter) finish() {
+	if p.width > 0 {
+		fmt.Fprintln(p.w)
+		p.width = 0
+	}
+}
+
+// progressLine describes the progress, eg.
+// "[#######-------] 45% 1.2GiB/2.7GiB 310.0MiB/s ETA 5s (1/3 files)".
+func progressLine(progress promotion.Progress, bar bool) string {
+	parts := []string{}
+
+	if progress.Total > 0 {
+		fraction := min(float64(progress.Scanned)/float64(progress.Total), 1)
+
+		if bar {
+			filled := int(fraction * progressBarWidth)
+			parts = append(parts,
+				"["+strings.Repeat("#", filled)+strings.Repeat("-", progressBarWidth-filled)+"]")
+		}
+
+		parts = append(parts,
+			fmt.Sprintf("%3.0f%%", fraction*100),
+			formatBytes(progress.Scanned)+"/"+formatBytes(progress.Total))
+	} else {
+		parts = append(parts, formatBytes(progress.Scanned))
+	}
+
+	parts = append(parts, formatBytes(int64(progress.Throughput))+"/s")
+
+	if progress.ETA >= 0 {
+		parts = append(parts, "ETA "+progress.ETA.Round(time.Second).String())
+	}
+
+	parts = append(parts, fmt.Sprintf("(%d/%d files)", progress.FilesDone, progress.Files))
+
+	return strings.Join(parts, " ")
+}
+
+// formatBytes formats a number of bytes with a binary unit, eg. 1.5GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	value := float64(n)
+	suffix := ""
+
+	for _, s := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		value /= unit
+		suffix = s
+
+		if value < unit {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%.1f%s", value, suffix)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// runRedemption manages the limits of coupon codes, and shows their
+// redemptions.
+func runRedemption(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 1
+	}
+
+	switch args[0] {
+	case "limit":
+		return runRedemptionLimit(args[1:])
+	case "show":
+		return runRedemptionShow(args[1:])
+	default:
+		usage()
+		return 1
+	}
+}
+
+// runRedemptionLimit replaces the limits of a code. Without any limit flags
+// the code has no limits.
+func runRedemptionLimit(args []string) int {
+	flags := flag.NewFlagSet("redemption limit", flag.ContinueOnError)
+	maxUses := flags.Int("max-uses", 0, "orders that can use the code, 0 is no limit")
+	maxUsesPerCustomer := flags.Int("max-uses-per-customer", 0, "orders each customer can use the code in, 0 is no limit")
+	validFrom := flags.String("from", "", "when the code can first be used, a date (2006-01-02) or RFC 3339 time")
+	validUntil := flags.String("until", "", "when the code can no longer be used, a date (2006-01-02) or RFC 3339 time")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 1 {
+		usage()
+		return 1
+	}
+
+	limits := promotion.CouponLimits{MaxUses: *maxUses, MaxUsesPerCustomer: *maxUsesPerCustomer}
+
+	var err error
+
+	if limits.ValidFrom, err = parseLimitTime(*validFrom); err != nil {
+		log.Printf("invalid -from with error %v", err)
+		return 1
+	}
+
+	if limits.ValidUntil, err = parseLimitTime(*validUntil); err != nil {
+		log.Printf("invalid -until with error %v", err)
+		return 1
+	}
+
+	if err := limits.Validate(); err != nil {
+		log.Printf("cannot limit the code with error %v", err)
+		return 1
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the promotion store with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	code := strings.ToUpper(flags.Arg(0))
+
+	if err := store.SetLimits(code, limits); err != nil {
+		log.Printf("cannot limit the code with error %v", err)
+		return 1
+	}
+
+	fmt.Printf("%s: %s\n", code, describeLimits(limits))
+
+	return 0
+}
+
+// runRedemptionShow shows the limits of a code, and its redemptions.
+func runRedemptionShow(args []string) int {
+	flags := flag.NewFlagSet("redemption show", flag.ContinueOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 1 {
+		usage()
+		return 1
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the promotion store with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	code := strings.ToUpper(flags.Arg(0))
+
+	limits, err := store.GetLimits(code)
+	if err != nil {
+		log.Printf("cannot get the limits with error %v", err)
+		return 1
+	}
+
+	redemptions, err := store.ListRedemptions(code)
+	if err != nil {
+		log.Printf("cannot list the redemptions with error %v", err)
+		return 1
+	}
+
+	uses := 0
+
+	for _, redemption := range redemptions {
+		if redemption.CancelledAt.IsZero() {
+			uses++
+		}
+	}
+
+	fmt.Printf("%s: %s, uses %d\n", code, describeLimits(limits), uses)
+
+	if len(redemptions) == 0 {
+		return 0
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "ORDER\tCUSTOMER\tREDEEMED\tCANCELLED")
+
+	for _, redemption := range redemptions {
+		cancelled := "-"
+		if !redemption.CancelledAt.IsZero() {
+			cancelled = redemption.CancelledAt.Local().Format(time.DateTime)
+		}
+
+		customer := redemption.CustomerID
+		if customer == "" {
+			customer = "-"
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n",
+			redemption.OrderID, customer, redemption.RedeemedAt.Local().Format(time.DateTime), cancelled)
+	}
+
+	if err := table.Flush(); err != nil {
+		log.Printf("cannot write the redemptions with error %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// parseLimitTime reads a date, as midnight UTC, or an RFC 3339 time. An empty
+// value is the zero time, no limit.
+func parseLimitTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// describeLimits lists the limits for people, eg. "max uses 100, valid from
+// 2026-03-01T00:00:00Z".
+func describeLimits(limits promotion.CouponLimits) string {
+	described := []string{}
+
+	if limits.MaxUses > 0 {
+		described = append(described, fmt.Sprintf("max uses %d", limits.MaxUses))
+	}
+
+	if limits.MaxUsesPerCustomer > 0 {
+		described = append(described, fmt.Sprintf("max uses per customer %d", limits.MaxUsesPerCustomer))
+	}
+
+	if !limits.ValidFrom.IsZero() {
+		described = append(described, "valid from "+limits.ValidFrom.Format(time.RFC3339))
+	}
+
+	if !limits.ValidUntil.IsZero() {
+		described = append(described, "valid until "+limits.ValidUntil.Format(time.RFC3339))
+	}
+
+	if len(described) == 0 {
+		return "no limits"
+	}
+
+	return strings.Join(described, ", ")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// Report output formats.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// codeReportOutput is the JSON form of a promotion.CodeReport.
+type codeReportOutput struct {
+	Code  string            `json:"code"`
+	Files []fileMatchOutput `json:"files"`
+}
+
+type fileMatchOutput struct {
+	File      string `json:"file"`
+	Count     int    `json:"count"`
+	FirstLine int    `json:"firstLine"`
+}
+
+// runReport reports where each pattern was found, for debugging disputed
+// coupons.
+func runReport(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+
+	patternFlags := addPatternFlags(flags, "promotion code to report on")
+	format := flags.String("format", formatTable, "output format: table, json, or csv")
+	normalisation := addNormalisationFlag(flags)
+	reader := addReaderFlag(flags)
+	limits := addLimitFlags(flags)
+	timeout := addTimeoutFlag(flags)
+	progressMode := addProgressFlag(flags)
+	source := addSourceFlag(flags)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	files, err := resolveFiles(*source, flags.Args())
+	if err != nil {
+		log.Printf("cannot find the files with error %v", err)
+		return 1
+	}
+
+	patterns, err := patternFlags.load(files)
+	if err != nil {
+		log.Printf("cannot read the patterns with error %v", err)
+		return 1
+	}
+
+	if len(patterns) == 0 || len(files) == 0 {
+		usage()
+		return 1
+	}
+
+	var write func(io.Writer, []promotion.CodeReport) error
+
+	switch *format {
+	case formatTable:
+		write = writeReportTable
+	case formatJSON:
+		write = writeReportJSON
+	case formatCSV:
+		write = writeReportCSV
+	default:
+		log.Printf("unknown format %q, use table, json, or csv", *format)
+		return 1
+	}
+
+	pool, err := limits.pool()
+	if err != nil {
+		log.Printf("cannot limit the search with error %v", err)
+		return 1
+	}
+
+	progress, err := newProgressPrinter(*progressMode)
+	if err != nil {
+		log.Printf("cannot show progress with error %v", err)
+		return 1
+	}
+
+	ctx, cancel := searchContext(*timeout)
+	defer cancel()
+
+	reports, err := promotion.Scanner{
+		Normalisation: normalisation.Normalisation,
+		Reader:        reader.ReadStrategy,
+		Pool:          pool,
+		Progress:      progress.callback(),
+	}.Report(ctx, patterns, files)
+	progress.finish()
+
+	if errors.Is(err, promotion.ErrCancelled) {
+		log.Printf("report stopped: %v", err)
+		return exitCancelled
+	}
+
+	if err != nil {
+		log.Printf("cannot create the report with error %v", err)
+		return 1
+	}
+
+	if err := write(os.Stdout, reports); err != nil {
+		log.Printf("cannot write the report with error %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+func writeReportTable(w io.Writer, reports []promotion.CodeReport) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "CODE\tFILE\tCOUNT\tFIRST LINE")
+
+	for _, report := range reports {
+		if len(report.Files) == 0 {
+			fmt.Fprintf(table, "%s\t(not found)\t0\t-\n", report.Code)
+			continue
+		}
+
+		for _, file := range report.Files {
+			fmt.Fprintf(table, "%s\t%s\t%d\t%d\n", report.Code, file.File, file.Count, file.FirstLine)
+		}
+	}
+
+	return table.Flush()
+}
+
+func writeReportJSON(w io.Writer, reports []promotion.CodeReport) error {
+	output := make([]codeReportOutput, 0, len(reports))
+
+	for _, report := range reports {
+		files := make([]fileMatchOutput, 0, len(report.Files))
+		for _, file := range report.Files {
+			files = append(files, fileMatchOutput{File: file.File, Count: file.Count, FirstLine: file.FirstLine})
+		}
+
+		output = append(output, codeReportOutput{Code: report.Code, Files: files})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(output)
+}
+
+// writeReportCSV writes a row per code and file. A code that was not found
+// has a single row, with no file.
+func writeReportCSV(w io.Writer, reports []promotion.CodeReport) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"code", "file", "count", "first_line"}); err != nil {
+		return err
+	}
+
+	for _, report := range reports {
+		if len(report.Files) == 0 {
+			if err := writer.Write([]string{report.Code, "", "0", ""}); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, file := range report.Files {
+			row := []string{report.Code, file.File, strconv.Itoa(file.Count), strconv.Itoa(file.FirstLine)}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
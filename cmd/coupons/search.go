@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// -on-error values.
+const (
+	onErrorFail    = "fail"
+	onErrorPartial = "partial"
+)
+
+// exitIncomplete is the exit code when some files could not be searched, and
+// the results printed may be wrong.
+const exitIncomplete = 2
+
+// runSearch reports whether each pattern is a valid coupon.
+func runSearch(args []string) int {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+
+	// Parse flags
+	patternFlags := addPatternFlags(flags, "promotion code to search")
+	indexDir := flags.String("index-dir", defaultIndexDir, "directory of pre-built indexes, used when it exists")
+	noCache := flags.Bool("no-cache", false, "search every file, without reading or writing the cache")
+	refresh := flags.Bool("refresh", false, "search every file, replacing the cached results")
+	cacheTTL := flags.Duration("cache-ttl", promotion.DefaultCacheTTL,
+		"how long new results are cached for, 0 is forever")
+	normalisation := addNormalisationFlag(flags)
+	reader := addReaderFlag(flags)
+	limits := addLimitFlags(flags)
+	timeout := addTimeoutFlag(flags)
+	progressMode := addProgressFlag(flags)
+	source := addSourceFlag(flags)
+	rulesFile := flags.String("rules", "", "JSON file of the validity rules for each campaign")
+	campaign := flags.String("campaign", "", "campaign, in the rules file, whose rules to apply")
+	onError := flags.String("on-error", onErrorFail,
+		"when a file cannot be searched: fail, or partial (report what was found, marked uncertain)")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if *noCache && *refresh {
+		log.Printf("-no-cache and -refresh cannot be used together")
+		return 1
+	}
+
+	if *cacheTTL < 0 {
+		log.Printf("-cache-ttl cannot be negative")
+		return 1
+	}
+
+	var errorPolicy promotion.ErrorPolicy
+
+	switch *onError {
+	case onErrorFail:
+		errorPolicy = promotion.FailFast
+	case onErrorPartial:
+		errorPolicy = promotion.Partial
+	default:
+		log.Printf("unknown -on-error %q, use fail or partial", *onError)
+		return 1
+	}
+
+	pool, err := limits.pool()
+	if err != nil {
+		log.Printf("cannot limit the search with error %v", err)
+		return 1
+	}
+
+	progress, err := newProgressPrinter(*progressMode)
+	if err != nil {
+		log.Printf("cannot show progress with error %v", err)
+		return 1
+	}
+
+	rules, err := campaignRules(*rulesFile, *campaign)
+	if err != nil {
+		log.Printf("cannot use the validity rules with error %v", err)
+		return 1
+	}
+
+	// Remaining args are files
+	files, err := resolveFiles(*source, flags.Args())
+	if err != nil {
+		log.Printf("cannot find the files with error %v", err)
+		return 1
+	}
+
+	patterns, err := patternFlags.load(files)
+	if err != nil {
+		log.Printf("cannot read the patterns with error %v", err)
+		return 1
+	}
+
+	if len(patterns) == 0 || len(files) == 0 {
+		// Require at least one pattern and at least one file to be passed in.
+		usage()
+		return 1
+	}
+
+	// Compressed files are detected, by their magic number, during the
+	// search, so only their existence is checked here.
+	for _, file := range files {
+		if file == promotion.StdinPath {
+			continue
+		}
+
+		if _, err := os.Stat(file); err != nil {
+			log.Printf("File does not exist: %s", file)
+			return 1
+		}
+	}
+
+	// Initialise dependencies.
+	promotionStore, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot initialise promotion datastore with error: %v", err)
+		return 1
+	}
+	defer func() {
+		if err := promotionStore.Close(); err != nil {
+			log.Printf("cannot close promotion datastore with error: %v", err)
+		}
+	}()
+
+	// Results are written as each code is resolved, those cached first, so
+	// that a long list shows them as they come. Each names its code, so
+	// they can be matched up with the input.
+	var writeErr error
+
+	printResult := func(pattern string, validity promotion.Validity) {
+		progress.finish()
+
+		if _, err := fmt.Fprintln(os.Stdout, resultLine(pattern, validity)); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	opts := []promotion.Option{
+		promotion.WithRules(rules),
+		promotion.WithErrorPolicy(errorPolicy),
+		promotion.WithNormalisation(normalisation.Normalisation),
+		promotion.WithReadStrategy(reader.ReadStrategy),
+		promotion.WithPool(pool),
+		promotion.WithProgress(progress.callback()),
+		promotion.WithCacheTTL(*cacheTTL),
+		promotion.WithResults(printResult),
+	}
+
+	if *source != "" {
+		opts = append(opts, promotion.WithSourceSet(promotionStore, *source))
+	}
+
+	switch {
+	case *noCache:
+		opts = append(opts, promotion.WithCacheMode(promotion.CacheOff))
+	case *refresh:
+		opts = append(opts, promotion.WithCacheMode(promotion.CacheRefresh))
+	}
+
+	// The index directory is optional, searches work (more slowly) without
+	// it, so it is not created here.
+	if _, err := os.Stat(*indexDir); err == nil {
+		indexes, err := promotion.NewIndexDir(*indexDir, normalisation.Normalisation)
+		if err != nil {
+			log.Printf("cannot use index directory with error %v", err)
+			return 1
+		}
+
+		opts = append(opts, promotion.WithIndexDir(indexes))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("cannot use index directory with error %v", err)
+		return 1
+	}
+
+	promotionSearch, err := promotion.NewSearch(promotionStore, opts...)
+	if err != nil {
+		log.Printf("cannot create a promotion search with error %v", err)
+		return 1
+	}
+
+	ctx, cancel := searchContext(*timeout)
+	defer cancel()
+
+	_, err = promotionSearch.IsValidBatch(ctx, patterns, files)
+	progress.finish()
+
+	if errors.Is(err, promotion.ErrCancelled) {
+		log.Printf("search stopped: %v", err)
+		return exitCancelled
+	}
+
+	if err != nil && !errors.Is(err, promotion.ErrIncompleteResults) {
+		log.Printf("search failed with error %v", err)
+		return 1
+	}
+
+	if writeErr != nil {
+		log.Printf("cannot write the results with error %v", writeErr)
+		return 1
+	}
+
+	if err != nil {
+		log.Printf("results are incomplete: %v", err)
+		return exitIncomplete
+	}
+
+	return 0
+}
+
+// resultLine describes the pattern's result.
+func resultLine(pattern string, validity promotion.Validity) string {
+	uncertain := ""
+	if validity.Uncertain {
+		uncertain = " (uncertain)"
+	}
+
+	if validity.Valid {
+		return fmt.Sprintf("%s is a valid coupon%s", pattern, uncertain)
+	}
+
+	reasons := []string{}
+	for _, failed := range validity.Failed() {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", failed.Rule, failed.Detail))
+	}
+
+	return fmt.Sprintf("%s is an invalid coupon (%s)%s", pattern, strings.Join(reasons, "; "), uncertain)
+}
+
+// campaignRules returns the rules for the campaign, or the default rules when
+// no rules file is given.
+func campaignRules(rulesFile, campaign string) (promotion.Rules, error) {
+	if rulesFile == "" {
+		if campaign != "" {
+			return promotion.Rules{}, fmt.Errorf("%w -campaign needs a -rules file", promotion.ErrInvalidRules)
+		}
+
+		return promotion.DefaultRules(), nil
+	}
+
+	campaigns, err := promotion.LoadCampaigns(rulesFile)
+	if err != nil {
+		return promotion.Rules{}, err
+	}
+
+	rules, ok := campaigns[campaign]
+	if !ok {
+		return promotion.Rules{}, fmt.Errorf("%w no campaign %q in %s", promotion.ErrInvalidRules, campaign, rulesFile)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore"
+)
+
+// shortChecksumLength is the number of checksum characters listed, enough to
+// tell versions of a file apart.
+const shortChecksumLength = 12
+
+var errSourceAndFiles = errors.New("-source cannot be used with files")
+
+// addSourceFlag adds the -source flag.
+func addSourceFlag(flags *flag.FlagSet) *string {
+	return flags.String("source", "",
+		"source set, registered with `coupons source add`, whose files are used instead of listing them")
+}
+
+// The environment variables that choose the promotion store, the same as the
+// API server's, so that both can share it.
+const (
+	promotionStoreEnv = "KART_PROMOTION_STORE"
+	promotionDSNEnv   = "KART_PROMOTION_DSN"
+)
+
+// openPromotionStore opens the store that the cached results, the sources, and
+// the coupon redemptions, are kept in. Unless the environment says otherwise
+// it is the SQLite database promotion_data.db, in the working directory.
+func openPromotionStore() (datastore.Store, error) {
+	backend := os.Getenv(promotionStoreEnv)
+	if backend == "" {
+		backend = datastore.BackendSQLite
+	}
+
+	return datastore.Open(backend, os.Getenv(promotionDSNEnv))
+}
+
+// resolveFiles returns the files named on the command line or, with -source,
+// the files of the source set.
+func resolveFiles(source string, files []string) ([]string, error) {
+	if source == "" {
+		return files, nil
+	}
+
+	if len(files) > 0 {
+		return nil, errSourceAndFiles
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	return promotion.SourceSetFiles(store, source)
+}
+
+// runSource manages the registered coupon sources.
+func runSource(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 1
+	}
+
+	switch args[0] {
+	case "add":
+		return runSourceAdd(args[1:])
+	case "list":
+		return runSourceList(args[1:])
+	case "remove":
+		return runSourceRemove(args[1:])
+	case "verify":
+		return runSourceVerify(args[1:])
+	default:
+		usage()
+		return 1
+	}
+}
+
+// runSourceAdd registers files under a source set, replacing the details of
+// files that are already registered.
+func runSourceAdd(args []string) int {
+	flags := flag.NewFlagSet("source add", flag.ContinueOnError)
+	description := flags.String("description", "", "what the files are, eg. where they came from")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() < 2 {
+		usage()
+		return 1
+	}
+
+	set, files := flags.Arg(0), flags.Args()[1:]
+	added := time.Now()
+
+	// Every file is checked before any are added, so that a set is not left
+	// half registered.
+	sources := make([]promotion.Source, 0, len(files))
+	failed := false
+
+	for _, file := range files {
+		source, err := promotion.NewSource(set, file, *description, added)
+		if err != nil {
+			log.Printf("cannot add %s with error %v", file, err)
+
+			failed = true
+
+			continue
+		}
+
+		sources = append(sources, source)
+	}
+
+	if failed {
+		return 1
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the source store with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	if err := store.AddSources(sources); err != nil {
+		log.Printf("cannot add the sources with error %v", err)
+		return 1
+	}
+
+	for _, source := range sources {
+		fmt.Printf("%s: added %s\n", set, source.Path)
+	}
+
+	return 0
+}
+
+// runSourceList lists the sources of a set, or of every set.
+func runSourceList(args []string) int {
+	flags := flag.NewFlagSet("source list", flag.ContinueOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() > 1 {
+		usage()
+		return 1
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the source store with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	set := flags.Arg(0)
+
+	sources, err := store.ListSources(set)
+	if err != nil {
+		log.Printf("cannot list the sources with error %v", err)
+		return 1
+	}
+
+	if len(sources) == 0 && set != "" {
+		log.Printf("%v %q", promotion.ErrUnknownSourceSet, set)
+		return 1
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "SET\tPATH\tADDED\tCHECKSUM\tDESCRIPTION")
+
+	for _, source := range sources {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n",
+			source.Set, source.Path, source.Added.Local().Format(time.DateTime),
+			source.Checksum[:min(shortChecksumLength, len(source.Checksum))], source.Description)
+	}
+
+	if err := table.Flush(); err != nil {
+		log.Printf("cannot write the sources with error %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// runSourceRemove removes files from a source set, or the whole set, along
+// with its cached results.
+func runSourceRemove(args []string) int {
+	flags := flag.NewFlagSet("source remove", flag.ContinueOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() < 1 {
+		usage()
+		return 1
+	}
+
+	set := flags.Arg(0)
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the source store with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	registered, err := promotion.SourceSetFiles(store, set)
+	if err != nil {
+		log.Printf("cannot remove %s with error %v", set, err)
+		return 1
+	}
+
+	// Files are registered by their absolute path.
+	paths := []string{}
+
+	for _, file := range flags.Args()[1:] {
+		path, err := filepath.Abs(file)
+		if err != nil || !slices.Contains(registered, path) {
+			log.Printf("%s is not in %s", file, set)
+			return 1
+		}
+
+		paths = append(paths, path)
+	}
+
+	if err := store.RemoveSources(set, paths); err != nil {
+		log.Printf("cannot remove the sources with error %v", err)
+		return 1
+	}
+
+	if len(paths) == 0 {
+		fmt.Printf("%s: removed\n", set)
+	}
+
+	for _, path := range paths {
+		fmt.Printf("%s: removed %s\n", set, path)
+	}
+
+	return 0
+}
+
+// runSourceVerify checks the files of a set, or of every set, against their
+// checksums, failing if any have changed or are missing.
+func runSourceVerify(args []string) int {
+	flags := flag.NewFlagSet("source verify", flag.ContinueOnError)
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() > 1 {
+		usage()
+		return 1
+	}
+
+	store, err := openPromotionStore()
+	if err != nil {
+		log.Printf("cannot open the source store with error %v", err)
+		return 1
+	}
+	defer store.Close()
+
+	set := flags.Arg(0)
+
+	sources, err := store.ListSources(set)
+	if err != nil {
+		log.Printf("cannot list the sources with error %v", err)
+		return 1
+	}
+
+	if len(sources) == 0 && set != "" {
+		log.Printf("%v %q", promotion.ErrUnknownSourceSet, set)
+		return 1
+	}
+
+	exitCode := 0
+
+	for _, source := range sources {
+		state, err := promotion.VerifySource(source)
+		if state != promotion.SourceOK {
+			exitCode = 1
+		}
+
+		if err != nil {
+			log.Printf("cannot read %s with error %v", source.Path, err)
+		}
+
+		fmt.Printf("%s: %s %s\n", source.Set, source.Path, state)
+	}
+
+	return exitCode
+}
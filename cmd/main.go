@@ -1,61 +1,304 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/shanehowearth/kart/api"
+	"github.com/shanehowearth/kart/api/handlers"
+	"github.com/shanehowearth/kart/internal/config"
+	"github.com/shanehowearth/kart/internal/ratelimit"
 	"github.com/shanehowearth/kart/order"
 	"github.com/shanehowearth/kart/order/datastore/inmemoryorderdatastore"
 	"github.com/shanehowearth/kart/product"
 	inmemoryproductdatastore "github.com/shanehowearth/kart/product/datastore"
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore"
 )
 
+// Process exit codes.
 const (
-	readTimeoutSeconds       = 5
-	readHeaderTimeoutSeconds = 3
-	writeTimeoutSeconds      = 10
-	idleTimeoutSeconds       = 120
+	exitOK = iota
+	// exitFailure - the server could not start, or failed while running.
+	exitFailure
+	// exitConfig - the configuration is invalid.
+	exitConfig
+	// exitUnclean - the server stopped, but in-flight requests were dropped,
+	// or the stores could not be closed cleanly.
+	exitUnclean
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the server, and blocks until it fails or a shutdown signal
+// (SIGINT, SIGTERM) is received, returning the process exit code.
+func run() (code int) {
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Printf("Failed to load configuration: %v", err)
+		return exitConfig
+	}
+
+	// Route everything, including the log package, through a handler that
+	// respects the configured level.
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+
+	// Stores are closed, in reverse order of creation, once the server has
+	// stopped handling requests.
+	closers := []io.Closer{}
+	defer func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i].Close(); err != nil {
+				log.Printf("Failed to close store: %v", err)
+
+				if code == exitOK {
+					code = exitUnclean
+				}
+			}
+		}
+	}()
+
 	// Initialise dependencies.
-	productStore := inmemoryproductdatastore.NewSeededInMemoryProductStore()
+	productStore, err := newProductStore(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize product store: %v", err)
+		return exitFailure
+	}
+
+	closers = appendCloser(closers, productStore)
 
 	productService, err := product.NewProductService(productStore)
 	if err != nil {
-		// Cannot continue, panic with the error message.
-		log.Fatalf("Failed to initialize product service: %v", err)
+		log.Printf("Failed to initialize product service: %v", err)
+		return exitFailure
 	}
 
-	orderStore := inmemoryorderdatastore.NewInMemoryOrderStore()
+	orderStore, err := newOrderStore(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize order store: %v", err)
+		return exitFailure
+	}
 
-	orderService, err := order.NewOrderService(orderStore, productService)
+	closers = appendCloser(closers, orderStore)
+
+	// The promotion store holds the coupon cache, and the ledger of the
+	// coupons redeemed by orders.
+	promotionStore, err := datastore.Open(cfg.PromotionStore, cfg.PromotionDSN)
 	if err != nil {
-		log.Fatalf("Failed to initialize order service: %v", err)
+		log.Printf("Failed to initialize promotion store: %v", err)
+		return exitFailure
+	}
+
+	closers = appendCloser(closers, promotionStore)
+
+	ledger, err := promotion.NewLedger(promotionStore)
+	if err != nil {
+		log.Printf("Failed to initialize coupon ledger: %v", err)
+		return exitFailure
+	}
+
+	promotionHandler, err := newPromotionHandler(cfg, promotionStore)
+	if err != nil {
+		log.Printf("Failed to initialize coupon validation: %v", err)
+		return exitFailure
+	}
+
+	// Orders only redeem codes that validate, without coupon files orders
+	// with a code are refused. Orders look codes up through the promotion
+	// handler, so that they share its rate limit.
+	orderOpts := []order.Option{order.WithCouponRedeemer(ledger)}
+	if promotionHandler.Available() {
+		orderOpts = append(orderOpts, order.WithCouponValidator(promotionHandler))
+	}
+
+	orderService, err := order.NewOrderService(orderStore, productService, orderOpts...)
+	if err != nil {
+		log.Printf("Failed to initialize order service: %v", err)
+		return exitFailure
 	}
 
 	// Routes.
 	mux := http.NewServeMux()
-	api.RegisterRoutes(mux, orderService, productService)
+	cors := api.CORSPolicy{
+		AllowedOrigins:   cfg.CORSOrigins,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		ExposedHeaders:   cfg.CORSExposedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+	}
+
+	api.RegisterRoutes(mux, cors, orderService, productService, promotionHandler)
 
 	// Serve front end.
-	fs := http.FileServer(http.Dir("./web/build"))
-	mux.Handle("/", fs)
+	if cfg.StaticDir != "" {
+		if _, err := os.Stat(cfg.StaticDir); err != nil {
+			slog.Warn("Front end directory is not available", "dir", cfg.StaticDir, "error", err)
+		}
+
+		fs := http.FileServer(http.Dir(cfg.StaticDir))
+		mux.Handle("/", fs)
+	}
 
 	server := &http.Server{
-		Addr:              ":8080",
+		Addr:              cfg.ListenAddr,
 		Handler:           mux,
-		ReadTimeout:       readTimeoutSeconds * time.Second,
-		ReadHeaderTimeout: readHeaderTimeoutSeconds * time.Second,
-		WriteTimeout:      writeTimeoutSeconds * time.Second,
-		IdleTimeout:       idleTimeoutSeconds * time.Second,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+
+	go func() {
+		log.Printf("Starting server on %s", cfg.ListenAddr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		// ListenAndServe only returns ErrServerClosed after Shutdown, which
+		// has not been called, so this is always a failure.
+		log.Printf("Server failed: %v", err)
+		return exitFailure
+	case <-ctx.Done():
+	}
+
+	// Restore the default signal behaviour, so a second signal kills the
+	// process immediately.
+	stop()
+
+	log.Printf("Shutdown requested, draining in-flight requests (up to %s)", cfg.ShutdownTimeout)
+
+	return shutdown(server, cfg)
+}
+
+// shutdown stops the server accepting new connections, and waits for
+// in-flight requests to complete, or the shutdown timeout to pass, whichever
+// happens first.
+func shutdown(server *http.Server, cfg config.Config) int {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err == nil {
+		log.Println("Server stopped")
+		return exitOK
 	}
 
-	log.Println("Starting server on :8080")
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Shutdown timeout of %s exceeded, dropping remaining requests", cfg.ShutdownTimeout)
+	} else {
+		log.Printf("Shutdown failed: %v", err)
+	}
+
+	if err := server.Close(); err != nil {
+		log.Printf("Closing server failed: %v", err)
+	}
+
+	return exitUnclean
+}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Server failed: %v", err)
+// appendCloser adds the store to the list of things to close on shutdown, if
+// it needs closing.
+func appendCloser(closers []io.Closer, store any) []io.Closer {
+	if closer, ok := store.(io.Closer); ok {
+		return append(closers, closer)
 	}
+
+	return closers
+}
+
+// newProductStore creates the product store backend named in the
+// configuration.
+func newProductStore(cfg config.Config) (product.Store, error) {
+	switch cfg.ProductStore {
+	case config.BackendSeeded:
+		return inmemoryproductdatastore.NewSeededInMemoryProductStore(), nil
+	case config.BackendMemory:
+		return inmemoryproductdatastore.NewInMemoryProductStore(), nil
+	default:
+		return nil, fmt.Errorf("%w unknown product store %q", config.ErrInvalidConfig, cfg.ProductStore)
+	}
+}
+
+// newOrderStore creates the order store backend named in the configuration.
+func newOrderStore(cfg config.Config) (order.Store, error) {
+	switch cfg.OrderStore {
+	case config.BackendMemory:
+		return inmemoryorderdatastore.NewInMemoryOrderStore(), nil
+	default:
+		return nil, fmt.Errorf("%w unknown order store %q", config.ErrInvalidConfig, cfg.OrderStore)
+	}
+}
+
+// newPromotionHandler creates the coupon search, caching in the store, for the
+// configured coupon files, or source set. Without either validation is
+// unavailable. The store must already be initialised.
+func newPromotionHandler(cfg config.Config, store datastore.Store) (*handlers.PromotionHandler, error) {
+	if len(cfg.CouponFiles) == 0 && cfg.CouponSource == "" {
+		slog.Warn("No coupon files are configured, coupon validation is unavailable, and orders with a coupon are refused")
+
+		return handlers.NewPromotionHandler(nil, nil, 0, nil), nil
+	}
+
+	var limiter *ratelimit.Limiter
+	if cfg.CouponRateLimit > 0 {
+		limiter = ratelimit.New(cfg.CouponRateLimit, cfg.CouponRateBurst)
+	}
+
+	// A search that cannot read every file fails, rather than answering
+	// from partial results.
+	opts := []promotion.Option{
+		promotion.WithErrorPolicy(promotion.FailFast),
+		promotion.WithCacheTTL(cfg.CouponCacheTTL),
+	}
+
+	files := cfg.CouponFiles
+
+	// The files of the source set are looked up once, files registered
+	// later are used after a restart.
+	if cfg.CouponSource != "" {
+		var err error
+
+		files, err = promotion.SourceSetFiles(store, cfg.CouponSource)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, promotion.WithSourceSet(store, cfg.CouponSource))
+	}
+
+	// The index directory is optional, searches work (more slowly) without
+	// it, so it is not created here.
+	if _, err := os.Stat(cfg.CouponIndexDir); err == nil {
+		indexes, err := promotion.NewIndexDir(cfg.CouponIndexDir, promotion.DefaultNormalisation)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, promotion.WithIndexDir(indexes))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("coupon index directory: %w", err)
+	}
+
+	search, err := promotion.NewSearch(store, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return handlers.NewPromotionHandler(search, files, cfg.CouponSearchTimeout, limiter), nil
 }
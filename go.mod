@@ -4,10 +4,13 @@ go 1.25.4
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.20.1
 	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/stretchr/testify v1.11.1
+	github.com/ulikunitz/xz v0.5.17
 	golang.org/x/sys v0.38.0
+	golang.org/x/text v0.34.0
 )
 
 require (
@@ -0,0 +1,627 @@
+// Package config loads the settings used to bootstrap the API server.
+//
+// Settings are resolved from (lowest to highest precedence):
+//   - built in defaults,
+//   - an optional JSON config file (-config flag, or KART_CONFIG),
+//   - environment variables (KART_*),
+//   - command line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore"
+)
+
+// Store backend names, the promotion store's are those of
+// datastore.Backends.
+const (
+	BackendMemory = "memory"
+	BackendSeeded = "seeded"
+)
+
+// envPrefix is prepended to every environment variable name.
+const envPrefix = "KART_"
+
+// configFileSetting is the flag, and environment variable suffix, that points
+// to the config file.
+const configFileSetting = "config"
+
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrInvalidConfig = errors.New("invalid configuration")
+	ErrUnknownKey    = errors.New("unknown configuration key")
+)
+
+// Config holds every setting needed to start the API server.
+type Config struct {
+	ListenAddr        string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long in-flight requests are given to complete
+	// once a shutdown has been requested. It must be at least the
+	// CouponSearchTimeout, so that a search in flight can finish.
+	ShutdownTimeout time.Duration
+	// StaticDir is the directory the front end is served from. An empty
+	// value disables serving the front end.
+	StaticDir    string
+	ProductStore string
+	ProductDSN   string
+	OrderStore   string
+	OrderDSN     string
+	// PromotionStore holds the coupon cache, sources, and redemptions.
+	PromotionStore string
+	// PromotionDSN is the database file for sqlite ("" is
+	// promotion_data.db), or the connection string for postgres.
+	PromotionDSN string
+	// CORSOrigins may contain exact origins, wildcard subdomains
+	// ("https://*.example.com"), or "*".
+	CORSOrigins          []string
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+	LogLevel             slog.Level
+	// CouponFiles are the files coupon codes are validated against. None
+	// leaves coupon validation unavailable.
+	CouponFiles []string
+	// CouponSource is a source set, registered with `coupons source add`,
+	// whose files are used instead of CouponFiles.
+	CouponSource string
+	// CouponIndexDir holds pre-built indexes of the coupon files, it is used
+	// when it exists.
+	CouponIndexDir string
+	// CouponSearchTimeout bounds each coupon search, zero is no limit. It
+	// must be less than the WriteTimeout, so that the answer can be written,
+	// and long enough for a search that is not cached (which caches nothing
+	// if it times out).
+	CouponSearchTimeout time.Duration
+	// CouponCacheTTL is how long coupon search results are cached for, zero
+	// is forever.
+	CouponCacheTTL time.Duration
+	// CouponRateLimit is the number of codes a client may look up each
+	// minute, and CouponRateBurst the number at once. A zero rate turns the
+	// limit off.
+	CouponRateLimit int
+	CouponRateBurst int
+}
+
+// Default returns the configuration used when nothing has been overridden.
+//
+//nolint:mnd // These are the defaults.
+func Default() Config {
+	return Config{
+		ListenAddr:         ":8080",
+		ReadTimeout:        5 * time.Second,
+		ReadHeaderTimeout:  3 * time.Second,
+		WriteTimeout:       30 * time.Second,
+		IdleTimeout:        120 * time.Second,
+		ShutdownTimeout:    30 * time.Second,
+		StaticDir:          "./web/build",
+		ProductStore:       BackendSeeded,
+		OrderStore:         BackendMemory,
+		PromotionStore:     datastore.BackendSQLite,
+		CORSOrigins:        []string{"http://localhost:3000"},
+		CORSAllowedHeaders: []string{"Content-Type", "Authorization"},
+		CORSExposedHeaders: []string{"X-Request-ID"},
+		LogLevel:           slog.LevelInfo,
+		// Unlike the command line tool, there is no default for the
+		// coupon files.
+		CouponFiles:         []string{},
+		CouponIndexDir:      ".coupon-index",
+		CouponSearchTimeout: 25 * time.Second,
+		CouponCacheTTL:      promotion.DefaultCacheTTL,
+		CouponRateLimit:     30,
+		CouponRateBurst:     10,
+	}
+}
+
+// setting describes a single configurable value, and how to apply a raw
+// (string) value to a Config.
+type setting struct {
+	// name is used as the flag name, and the config file key.
+	name  string
+	usage string
+	apply func(cfg *Config, value string) error
+	// boolean flags can be given without a value, eg. -cors-allow-credentials
+	// is -cors-allow-credentials=true.
+	boolean bool
+}
+
+// envName returns the environment variable that holds the setting, eg.
+// listen-addr is KART_LISTEN_ADDR.
+func (s setting) envName() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func durationSetter(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		*field(cfg) = parsed
+
+		return nil
+	}
+}
+
+func stringSetter(field func(*Config) *string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		*field(cfg) = strings.TrimSpace(value)
+
+		return nil
+	}
+}
+
+func boolSetter(field func(*Config) *bool) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+
+		*field(cfg) = parsed
+
+		return nil
+	}
+}
+
+func intSetter(field func(*Config) *int) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+
+		*field(cfg) = parsed
+
+		return nil
+	}
+}
+
+func listSetter(field func(*Config) *[]string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		list := []string{}
+
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+
+		*field(cfg) = list
+
+		return nil
+	}
+}
+
+func settings() []setting {
+	return []setting{
+		{
+			name:  "listen-addr",
+			usage: "address the HTTP server listens on",
+			apply: stringSetter(func(c *Config) *string { return &c.ListenAddr }),
+		},
+		{
+			name:  "read-timeout",
+			usage: "maximum duration for reading an entire request",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.ReadTimeout }),
+		},
+		{
+			name:  "read-header-timeout",
+			usage: "maximum duration for reading request headers",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.ReadHeaderTimeout }),
+		},
+		{
+			name:  "write-timeout",
+			usage: "maximum duration before timing out writes of a response",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.WriteTimeout }),
+		},
+		{
+			name:  "idle-timeout",
+			usage: "maximum time to wait for the next request on a keep-alive connection",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.IdleTimeout }),
+		},
+		{
+			name:  "shutdown-timeout",
+			usage: "maximum time to drain in-flight requests when shutting down",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+		},
+		{
+			name:  "static-dir",
+			usage: "directory holding the front end build (empty disables it)",
+			apply: stringSetter(func(c *Config) *string { return &c.StaticDir }),
+		},
+		{
+			name:  "product-store",
+			usage: "product store backend (seeded, memory)",
+			apply: stringSetter(func(c *Config) *string { return &c.ProductStore }),
+		},
+		{
+			name:  "product-dsn",
+			usage: "data source name for the product store",
+			apply: stringSetter(func(c *Config) *string { return &c.ProductDSN }),
+		},
+		{
+			name:  "order-store",
+			usage: "order store backend (memory)",
+			apply: stringSetter(func(c *Config) *string { return &c.OrderStore }),
+		},
+		{
+			name:  "order-dsn",
+			usage: "data source name for the order store",
+			apply: stringSetter(func(c *Config) *string { return &c.OrderDSN }),
+		},
+		{
+			name:  "promotion-store",
+			usage: "coupon cache, source, and redemption store backend (" + strings.Join(datastore.Backends(), ", ") + ")",
+			apply: stringSetter(func(c *Config) *string { return &c.PromotionStore }),
+		},
+		{
+			name:  "promotion-dsn",
+			usage: "database file (sqlite) or connection string (postgres) for the promotion store",
+			apply: stringSetter(func(c *Config) *string { return &c.PromotionDSN }),
+		},
+		{
+			name:  "cors-origins",
+			usage: "comma separated list of origins allowed to make cross origin requests",
+			apply: listSetter(func(c *Config) *[]string { return &c.CORSOrigins }),
+		},
+		{
+			name:  "cors-allowed-headers",
+			usage: "comma separated list of request headers allowed in cross origin requests",
+			apply: listSetter(func(c *Config) *[]string { return &c.CORSAllowedHeaders }),
+		},
+		{
+			name:  "cors-exposed-headers",
+			usage: "comma separated list of response headers exposed to cross origin requests",
+			apply: listSetter(func(c *Config) *[]string { return &c.CORSExposedHeaders }),
+		},
+		{
+			name:    "cors-allow-credentials",
+			usage:   "allow credentials (cookies, Authorization) in cross origin requests",
+			apply:   boolSetter(func(c *Config) *bool { return &c.CORSAllowCredentials }),
+			boolean: true,
+		},
+		{
+			name:  "cors-max-age",
+			usage: "how long browsers may cache preflight responses (0 leaves it to the browser)",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.CORSMaxAge }),
+		},
+		{
+			name:  "log-level",
+			usage: "minimum level logged (debug, info, warn, error)",
+			apply: func(cfg *Config, value string) error {
+				return cfg.LogLevel.UnmarshalText([]byte(strings.TrimSpace(value)))
+			},
+		},
+		{
+			name:  "coupon-files",
+			usage: "comma separated list of the files coupon codes are validated against",
+			apply: listSetter(func(c *Config) *[]string { return &c.CouponFiles }),
+		},
+		{
+			name:  "coupon-source",
+			usage: "source set, registered with the coupons tool, whose files coupon codes are validated against",
+			apply: stringSetter(func(c *Config) *string { return &c.CouponSource }),
+		},
+		{
+			name:  "coupon-index-dir",
+			usage: "directory of pre-built coupon file indexes, used when it exists",
+			apply: stringSetter(func(c *Config) *string { return &c.CouponIndexDir }),
+		},
+		{
+			name:  "coupon-search-timeout",
+			usage: "maximum duration of a coupon search (0 is no limit)",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.CouponSearchTimeout }),
+		},
+		{
+			name:  "coupon-cache-ttl",
+			usage: "how long coupon search results are cached for (0 is forever)",
+			apply: durationSetter(func(c *Config) *time.Duration { return &c.CouponCacheTTL }),
+		},
+		{
+			name:  "coupon-rate-limit",
+			usage: "coupon codes each client may look up a minute (0 is no limit)",
+			apply: intSetter(func(c *Config) *int { return &c.CouponRateLimit }),
+		},
+		{
+			name:  "coupon-rate-burst",
+			usage: "coupon codes each client may look up at once",
+			apply: intSetter(func(c *Config) *int { return &c.CouponRateBurst }),
+		},
+	}
+}
+
+// Load resolves the configuration from the config file, the environment, and
+// the supplied command line arguments (without the program name).
+// lookupEnv is normally os.LookupEnv.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+	all := settings()
+
+	// Flags are recorded, rather than applied, so that they can be applied
+	// last, giving them the highest precedence.
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+
+	flagValues := []flagValue{}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configPath := flags.String(configFileSetting, "", "path to a JSON config file")
+
+	for _, s := range all {
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.envName())
+		record := func(value string) error {
+			flagValues = append(flagValues, flagValue{setting: s, value: value})
+
+			return nil
+		}
+
+		if s.boolean {
+			flags.BoolFunc(s.name, usage, record)
+		} else {
+			flags.Func(s.name, usage, record)
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+		}
+
+		return Config{}, fmt.Errorf("%w %w", ErrInvalidConfig, err)
+	}
+
+	if *configPath == "" {
+		*configPath, _ = lookupEnv(envPrefix + strings.ToUpper(configFileSetting))
+	}
+
+	errs := []error{}
+
+	if *configPath != "" {
+		if err := applyFile(&cfg, all, *configPath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, s := range all {
+		if value, ok := lookupEnv(s.envName()); ok {
+			if err := s.apply(&cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("environment variable %s: %w", s.envName(), err))
+			}
+		}
+	}
+
+	for _, fv := range flagValues {
+		if err := fv.setting.apply(&cfg, fv.value); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", fv.setting.name, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		if err := cfg.Validate(); err != nil {
+			return Config{}, err
+		}
+
+		return cfg, nil
+	}
+
+	return Config{}, fmt.Errorf("%w %w", ErrInvalidConfig, errors.Join(errs...))
+}
+
+// applyFile applies the settings found in a JSON config file. Keys are the
+// flag names, values may be strings, numbers, or (for lists) arrays of
+// strings.
+func applyFile(cfg *Config, all []setting, path string) error {
+	data, err := os.ReadFile(path) // #nosec G304 -- The operator chooses the config file.
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	known := make(map[string]setting, len(all))
+	for _, s := range all {
+		known[s.name] = s
+	}
+
+	errs := []error{}
+
+	for key, rawValue := range raw {
+		s, ok := known[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: %w %q", path, ErrUnknownKey, key))
+			continue
+		}
+
+		value, err := fileValue(rawValue)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file %s key %q: %w", path, key, err))
+			continue
+		}
+
+		if err := s.apply(cfg, value); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s key %q: %w", path, key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// fileValue converts a JSON value from the config file into the string form
+// used by flags and environment variables.
+func fileValue(raw json.RawMessage) (string, error) {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str, nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return strings.Join(list, ","), nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number.String(), nil
+	}
+
+	return "", fmt.Errorf("%w unsupported value %s", ErrInvalidConfig, raw)
+}
+
+// Validate reports every problem with the configuration, rather than just the
+// first.
+func (c Config) Validate() error {
+	errs := []error{}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen-addr %q: %w", c.ListenAddr, err))
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{name: "read-timeout", value: c.ReadTimeout},
+		{name: "read-header-timeout", value: c.ReadHeaderTimeout},
+		{name: "write-timeout", value: c.WriteTimeout},
+		{name: "idle-timeout", value: c.IdleTimeout},
+		{name: "shutdown-timeout", value: c.ShutdownTimeout},
+	}
+
+	for _, timeout := range timeouts {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", timeout.name, timeout.value))
+		}
+	}
+
+	// A missing static directory is allowed (the front end may not have been
+	// built yet), but something other than a directory is a mistake.
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err == nil && !info.IsDir() {
+			errs = append(errs, fmt.Errorf("static-dir %q is not a directory", c.StaticDir))
+		}
+	}
+
+	errs = append(errs, validateStore("product", c.ProductStore, c.ProductDSN, BackendSeeded, BackendMemory))
+	errs = append(errs, validateStore("order", c.OrderStore, c.OrderDSN, BackendMemory))
+	// Unlike the other stores, the default is persistent.
+	if err := datastore.Validate(c.PromotionStore, c.PromotionDSN); err != nil {
+		errs = append(errs, fmt.Errorf("promotion-store: %w", err))
+	}
+
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			// A wildcard with credentials would let any site make
+			// credentialed requests.
+			if c.CORSAllowCredentials {
+				errs = append(errs, errors.New("cors-origins \"*\" cannot be used with cors-allow-credentials"))
+			}
+
+			continue
+		}
+
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || strings.Count(origin, "*") > 1 {
+			errs = append(errs, fmt.Errorf("cors-origins %q is not a valid origin", origin))
+		}
+	}
+
+	if c.CORSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("cors-max-age must not be negative, got %s", c.CORSMaxAge))
+	}
+
+	// Unlike the static directory, a missing coupon file would fail every
+	// search.
+	for _, file := range c.CouponFiles {
+		if info, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("coupon-files %q: %w", file, err))
+		} else if info.IsDir() {
+			errs = append(errs, fmt.Errorf("coupon-files %q is a directory", file))
+		}
+	}
+
+	if c.CouponSource != "" && len(c.CouponFiles) > 0 {
+		errs = append(errs, errors.New("coupon-source cannot be used with coupon-files"))
+	}
+
+	if c.CouponSearchTimeout < 0 {
+		errs = append(errs, fmt.Errorf("coupon-search-timeout must not be negative, got %s", c.CouponSearchTimeout))
+	}
+
+	// A search that outlasts the write timeout cannot answer.
+	if c.CouponSearchTimeout >= c.WriteTimeout && c.WriteTimeout > 0 {
+		errs = append(errs, fmt.Errorf("coupon-search-timeout %s must be less than write-timeout %s",
+			c.CouponSearchTimeout, c.WriteTimeout))
+	}
+
+	// A search in flight at shutdown would be dropped.
+	if c.ShutdownTimeout < c.CouponSearchTimeout {
+		errs = append(errs, fmt.Errorf("shutdown-timeout %s must be at least coupon-search-timeout %s",
+			c.ShutdownTimeout, c.CouponSearchTimeout))
+	}
+
+	if c.CouponCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("coupon-cache-ttl must not be negative, got %s", c.CouponCacheTTL))
+	}
+
+	if c.CouponRateLimit < 0 {
+		errs = append(errs, fmt.Errorf("coupon-rate-limit must not be negative, got %d", c.CouponRateLimit))
+	}
+
+	if c.CouponRateLimit > 0 && c.CouponRateBurst <= 0 {
+		errs = append(errs, fmt.Errorf("coupon-rate-burst must be positive, got %d", c.CouponRateBurst))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w %w", ErrInvalidConfig, err)
+	}
+
+	return nil
+}
+
+// validateStore checks that the backend is known, and that a DSN is only
+// supplied for backends that use one.
+// Note: None of the current product or order backends use a DSN, but the
+// setting exists so that persistent stores can be added without changing the
+// configuration format.
+func validateStore(kind, backend, dsn string, known ...string) error {
+	for _, k := range known {
+		if backend != k {
+			continue
+		}
+
+		if dsn != "" {
+			return fmt.Errorf("%s-dsn is not used by the %s %s store", kind, backend, kind)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%s-store %q is not one of %s", kind, backend, strings.Join(known, ", "))
+}
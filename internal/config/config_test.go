@@ -0,0 +1,208 @@
+package config_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/internal/config"
+	"github.com/shanehowearth/kart/promotion/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	testcases := map[string]struct {
+		args          []string
+		env           map[string]string
+		file          string
+		expected      func(*config.Config)
+		expectedError error
+		// expectedMessage is part of the error's message, when it matters
+		// which check failed.
+		expectedMessage string
+	}{
+		"Defaults when nothing is supplied": {
+			expected: func(*config.Config) {},
+		},
+		"Flags override defaults": {
+			args: []string{"-listen-addr", ":9090", "-read-timeout", "7s", "-log-level", "debug"},
+			expected: func(c *config.Config) {
+				c.ListenAddr = ":9090"
+				c.ReadTimeout = 7 * time.Second
+				c.LogLevel = slog.LevelDebug
+			},
+		},
+		"Environment overrides config file": {
+			file: `{"listen-addr": ":7000", "cors-origins": ["https://a.example", "https://b.example"]}`,
+			env:  map[string]string{"KART_LISTEN_ADDR": ":7001"},
+			expected: func(c *config.Config) {
+				c.ListenAddr = ":7001"
+				c.CORSOrigins = []string{"https://a.example", "https://b.example"}
+			},
+		},
+		"Flags override environment": {
+			env:  map[string]string{"KART_CORS_ORIGINS": "https://env.example"},
+			args: []string{"-cors-origins", "https://flag.example, https://other.example"},
+			expected: func(c *config.Config) {
+				c.CORSOrigins = []string{"https://flag.example", "https://other.example"}
+			},
+		},
+		"Unknown config file key is rejected": {
+			file:          `{"listen-adr": ":7000"}`,
+			expectedError: config.ErrUnknownKey,
+		},
+		"Unparseable duration is rejected": {
+			env:           map[string]string{"KART_WRITE_TIMEOUT": "soon"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Unknown store backend is rejected": {
+			args:          []string{"-order-store", "postgres"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"DSN for an in-memory store is rejected": {
+			args:          []string{"-product-dsn", "file:products.db"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Promotion store from the environment": {
+			env: map[string]string{
+				"KART_PROMOTION_STORE": "postgres",
+				"KART_PROMOTION_DSN":   "postgres://kart@localhost/kart",
+			},
+			expected: func(c *config.Config) {
+				c.PromotionStore = datastore.BackendPostgres
+				c.PromotionDSN = "postgres://kart@localhost/kart"
+			},
+		},
+		"SQLite promotion store with a path": {
+			args: []string{"-promotion-dsn", "/var/lib/kart/promotion_data.db"},
+			expected: func(c *config.Config) {
+				c.PromotionDSN = "/var/lib/kart/promotion_data.db"
+			},
+		},
+		"Postgres promotion store without a DSN is rejected": {
+			args:          []string{"-promotion-store", "postgres"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"DSN for the in-memory promotion store is rejected": {
+			args:          []string{"-promotion-store", "memory", "-promotion-dsn", "promotion_data.db"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Unknown promotion store backend is rejected": {
+			args:          []string{"-promotion-store", "mysql"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Boolean flag without a value": {
+			args: []string{"-cors-origins", "https://shop.example", "-cors-allow-credentials"},
+			expected: func(c *config.Config) {
+				c.CORSOrigins = []string{"https://shop.example"}
+				c.CORSAllowCredentials = true
+			},
+		},
+		"Boolean flag with a value": {
+			args: []string{"-cors-allow-credentials=false", "-cors-origins", "*"},
+			expected: func(c *config.Config) {
+				c.CORSOrigins = []string{"*"}
+			},
+		},
+		"Any CORS origin with credentials is rejected": {
+			args:            []string{"-cors-origins", "*", "-cors-allow-credentials"},
+			expectedError:   config.ErrInvalidConfig,
+			expectedMessage: `cors-origins "*" cannot be used with cors-allow-credentials`,
+		},
+		"Any CORS origin with credentials from the environment is rejected": {
+			env:             map[string]string{"KART_CORS_ALLOW_CREDENTIALS": "true"},
+			args:            []string{"-cors-origins", "*"},
+			expectedError:   config.ErrInvalidConfig,
+			expectedMessage: `cors-origins "*" cannot be used with cors-allow-credentials`,
+		},
+		"Invalid CORS origin is rejected": {
+			args:          []string{"-cors-origins", "localhost"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Negative timeout is rejected": {
+			args:          []string{"-idle-timeout", "-1s"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Coupon search timeout as long as the write timeout is rejected": {
+			args:          []string{"-write-timeout", "10s", "-coupon-search-timeout", "10s"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Shutdown timeout shorter than the coupon search timeout is rejected": {
+			args:            []string{"-shutdown-timeout", "10s", "-coupon-search-timeout", "20s"},
+			expectedError:   config.ErrInvalidConfig,
+			expectedMessage: "shutdown-timeout 10s must be at least coupon-search-timeout 20s",
+		},
+		"Negative coupon cache TTL is rejected": {
+			args:          []string{"-coupon-cache-ttl", "-1h"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Coupon settings from the config file": {
+			file: `{"coupon-files": ["config_test.go"], "coupon-rate-limit": 6, "coupon-search-timeout": "1s"}`,
+			expected: func(c *config.Config) {
+				c.CouponFiles = []string{"config_test.go"}
+				c.CouponRateLimit = 6
+				c.CouponSearchTimeout = time.Second
+			},
+		},
+		"Missing coupon file is rejected": {
+			args:          []string{"-coupon-files", "does-not-exist.txt"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Coupon source with coupon files is rejected": {
+			args:          []string{"-coupon-source", "oolio", "-coupon-files", "config_test.go"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Unparseable rate limit is rejected": {
+			env:           map[string]string{"KART_COUPON_RATE_LIMIT": "lots"},
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Rate limit without a burst is rejected": {
+			args:          []string{"-coupon-rate-burst", "0"},
+			expectedError: config.ErrInvalidConfig,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			env := map[string]string{}
+			for k, v := range tc.env {
+				env[k] = v
+			}
+
+			if tc.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				assert.NoError(t, os.WriteFile(path, []byte(tc.file), 0o600))
+
+				env["KART_CONFIG"] = path
+			}
+
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := env[key]
+
+				return value, ok
+			}
+
+			actual, actualError := config.Load("kart", tc.args, lookupEnv)
+
+			if tc.expectedError != nil {
+				assert.ErrorIsf(
+					t,
+					actualError,
+					tc.expectedError,
+					"expected error %v, but got %v",
+					tc.expectedError, actualError,
+				)
+				assert.ErrorContains(t, actualError, tc.expectedMessage)
+
+				return
+			}
+
+			assert.Nilf(t, actualError, "unexpectedly got error %v", actualError)
+
+			expected := config.Default()
+			tc.expected(&expected)
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
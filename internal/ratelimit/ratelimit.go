@@ -0,0 +1,137 @@
+// Package ratelimit limits how often each client may do something, eg. guess
+// coupon codes.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idleSweepInterval is how often buckets that have refilled are forgotten, so
+// that the memory used is bounded by the clients seen recently.
+const idleSweepInterval = time.Minute
+
+// Limiter is a token bucket per key. Each key may spend up to burst tokens at
+// once, and gets rate tokens back every minute.
+type Limiter struct {
+	// perSecond is the rate tokens are returned at.
+	perSecond float64
+	burst     float64
+	// now is the clock, time.Now unless replaced in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket is the tokens a key has, as of updated.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// New returns a limiter that allows each key perMinute tokens a minute, and
+// at most burst at once. A nil *Limiter allows everything.
+func New(perMinute, burst int) *Limiter {
+	return NewWithClock(perMinute, burst, time.Now)
+}
+
+// NewWithClock is New with the clock supplied, so that the refill can be
+// controlled.
+func NewWithClock(perMinute, burst int, now func() time.Time) *Limiter {
+	return &Limiter{
+		perSecond: float64(perMinute) / time.Minute.Seconds(),
+		burst:     float64(burst),
+		now:       now,
+		buckets:   map[string]*bucket{},
+		lastSweep: now(),
+	}
+}
+
+// Burst is the most tokens that can be taken at once.
+func (l *Limiter) Burst() int {
+	if l == nil {
+		return math.MaxInt
+	}
+
+	return int(l.burst)
+}
+
+// Take takes n tokens from the key's bucket. When there are not enough
+// tokens, nothing is taken, and the time until there will be is returned.
+func (l *Limiter) Take(key string, n int) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.refill(now, l.perSecond, l.burst)
+
+	wanted := float64(n)
+	if b.tokens >= wanted {
+		b.tokens -= wanted
+
+		return true, 0
+	}
+
+	if wanted > l.burst || l.perSecond <= 0 {
+		// The bucket can never hold enough.
+		return false, time.Duration(math.MaxInt64)
+	}
+
+	wait := (wanted - b.tokens) / l.perSecond
+
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// refill adds the tokens returned since the bucket was last updated.
+func (b *bucket) refill(now time.Time, perSecond, burst float64) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*perSecond, burst)
+	}
+
+	b.updated = now
+}
+
+// sweep forgets the buckets that are full, they are the same as a new bucket.
+// The limiter must be locked.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleSweepInterval {
+		return
+	}
+
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		b.refill(now, l.perSecond, l.burst)
+
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len is the number of keys being tracked.
+func (l *Limiter) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
@@ -0,0 +1,105 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is a time that only moves when told to.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestLimiterTake(t *testing.T) {
+	testcases := map[string]struct {
+		taken         []int
+		elapsed       time.Duration
+		take          int
+		expectedOK    bool
+		expectedRetry time.Duration
+	}{
+		"Burst is available at once": {
+			take:       5,
+			expectedOK: true,
+		},
+		"Beyond the burst waits for the refill": {
+			taken:         []int{5},
+			take:          2,
+			expectedRetry: 2 * time.Second,
+		},
+		"Tokens are returned over time": {
+			taken:      []int{5},
+			elapsed:    2 * time.Second,
+			take:       2,
+			expectedOK: true,
+		},
+		"Refill stops at the burst": {
+			taken:         []int{1},
+			elapsed:       time.Hour,
+			take:          6,
+			expectedRetry: time.Duration(1<<63 - 1),
+		},
+		"A failed take takes nothing": {
+			taken:         []int{4, 2},
+			take:          1,
+			expectedOK:    true,
+			expectedRetry: 0,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			clk := &clock{now: time.Now()}
+
+			// A token a second.
+			limiter := ratelimit.NewWithClock(60, 5, clk.Now)
+
+			for _, n := range tc.taken {
+				limiter.Take("client", n)
+			}
+
+			clk.now = clk.now.Add(tc.elapsed)
+
+			ok, retry := limiter.Take("client", tc.take)
+
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expectedRetry, retry)
+
+			// Other keys have their own bucket.
+			ok, _ = limiter.Take("other", 5)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestLimiterForgetsIdleKeys(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	limiter := ratelimit.NewWithClock(60, 5, clk.Now)
+
+	limiter.Take("idle", 5)
+	limiter.Take("busy", 5)
+	assert.Equal(t, 2, limiter.Len())
+
+	// The busy key is still spending its tokens when the idle key has
+	// refilled.
+	clk.now = clk.now.Add(time.Minute - time.Second)
+	limiter.Take("busy", 5)
+
+	clk.now = clk.now.Add(time.Second)
+	limiter.Take("busy", 1)
+
+	assert.Equal(t, 1, limiter.Len())
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *ratelimit.Limiter
+
+	ok, retry := limiter.Take("client", 1000)
+
+	assert.True(t, ok)
+	assert.Zero(t, retry)
+}
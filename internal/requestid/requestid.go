@@ -0,0 +1,45 @@
+// Package requestid tags every request with an ID, so that responses (and
+// error reports from clients) can be matched to the server logs.
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header that carries the request ID, in both directions.
+const Header = "X-Request-ID"
+
+// contextKey is unexported so that no other package can collide with it.
+type contextKey struct{}
+
+// validID restricts the IDs accepted from clients, so that arbitrary content
+// cannot be injected into the logs or responses.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Middleware uses the request ID supplied by the client (or a proxy), or
+// generates a new one, and makes it available to the handlers, and the
+// client, via the X-Request-ID header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !validID.MatchString(id) {
+			id = uuid.New().String()
+		}
+
+		w.Header().Set(Header, id)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+	})
+}
+
+// FromContext returns the request ID, or "" if the request did not pass
+// through the Middleware.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+
+	return id
+}
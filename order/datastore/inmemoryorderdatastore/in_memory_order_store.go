@@ -65,3 +65,21 @@ func (imos *InMemoryOrderStore) GetByID(orderID string) (order.Order, error) {
 		orderID,
 	)
 }
+
+// CancelOrder marks an order cancelled.
+func (imos *InMemoryOrderStore) CancelOrder(orderID string) (order.Order, error) {
+	imos.mu.Lock()
+	defer imos.mu.Unlock()
+
+	fetched, ok := imos.orders[orderID]
+	if !ok {
+		return order.Order{}, fmt.Errorf("%w no order with ID %s",
+			order.ErrNotFound,
+			orderID,
+		)
+	}
+
+	fetched.Cancelled = true
+
+	return *fetched, nil
+}
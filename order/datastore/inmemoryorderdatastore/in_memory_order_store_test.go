@@ -237,3 +237,29 @@ func TestGetByID(t *testing.T) {
 		})
 	}
 }
+
+func TestCancelOrder(t *testing.T) {
+	imods := inmemoryorderdatastore.NewInMemoryOrderStore()
+
+	placed := order.Order{
+		ID:         "6cb6e494-30fe-4a7a-9e82-3acb8e28e0de",
+		CouponCode: "FIFTYOFF",
+		Items:      []order.Item{{ProductID: "1", Quantity: 5}},
+	}
+	assert.Nil(t, imods.CreateOrder(&placed))
+
+	// Cancelling twice is not an error.
+	for range 2 {
+		cancelled, err := imods.CancelOrder(placed.ID)
+		assert.Nil(t, err)
+		assert.True(t, cancelled.Cancelled)
+		assert.Equal(t, "FIFTYOFF", cancelled.CouponCode)
+	}
+
+	fetched, err := imods.GetByID(placed.ID)
+	assert.Nil(t, err)
+	assert.True(t, fetched.Cancelled)
+
+	_, err = imods.CancelOrder("does-not-exist")
+	assert.ErrorIs(t, err, order.ErrNotFound)
+}
@@ -1,9 +1,11 @@
 package order
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/shanehowearth/kart/internal/validation"
@@ -15,15 +17,62 @@ type ProductGetter interface {
 	GetProductsByIDs(id []string) ([]product.Product, []string, error)
 }
 
+// CouponRedeemer records the use of coupon codes by orders, and enforces
+// their limits.
+type CouponRedeemer interface {
+	// RedeemCoupon records the use of the code by the order, failing if the
+	// code cannot be used (eg. it has been used up).
+	RedeemCoupon(code, orderID, customerID string) error
+	// ReleaseCoupon returns the order's use of its code. Releasing an order
+	// that did not use a code, or releasing it twice, is not an error.
+	ReleaseCoupon(orderID string) error
+}
+
+// CouponValidator checks that coupon codes are real, eg. that they are found
+// in the coupon files.
+type CouponValidator interface {
+	// IsValidCoupon reports whether the (normalised) code is valid. The
+	// client (eg. its address) is who is asking, so that the codes a client
+	// may try can be limited. An error is returned when that cannot be
+	// determined, or ctx is done first.
+	IsValidCoupon(ctx context.Context, code, client string) (bool, error)
+}
+
 // Service provides business logic for order operations.
 type Service struct {
 	repo          Store
 	productGetter ProductGetter
+	coupons       CouponRedeemer
+	validator     CouponValidator
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithCouponRedeemer has orders redeem their coupon codes, orders with a code
+// are rejected without one.
+func WithCouponRedeemer(coupons CouponRedeemer) Option {
+	return func(svc *Service) {
+		svc.coupons = coupons
+	}
+}
+
+// WithCouponValidator has orders check their coupon codes are valid before
+// they are redeemed, orders with a code are rejected without one.
+func WithCouponValidator(validator CouponValidator) Option {
+	return func(svc *Service) {
+		svc.validator = validator
+	}
 }
 
 // ErrCannotCreateOrderService - Error if Order cannot be created.
 var ErrCannotCreateOrderService = errors.New("cannot create order service")
 
+// ErrInvalidOrder - Error if the order, as requested, cannot be created (eg.
+// it has no items, or none of the products exist). It is always wrapped with
+// ErrCreateFailed.
+var ErrInvalidOrder = errors.New("invalid order")
+
 // Item provides the structure to hold order item details.
 type Item struct {
 	ProductID string
@@ -33,9 +82,12 @@ type Item struct {
 
 // Order is the structure to hold the Order details.
 type Order struct {
-	ID       string
-	Items    []Item
-	Products []ProductReference
+	ID         string
+	CustomerID string
+	CouponCode string
+	Items      []Item
+	Products   []ProductReference
+	Cancelled  bool
 }
 
 // ProductReference is the value object within the Order aggregate.
@@ -47,7 +99,7 @@ type ProductReference struct {
 }
 
 // NewOrderService - create a new instance of a order service.
-func NewOrderService(repo Store, productGetter ProductGetter) (*Service, error) {
+func NewOrderService(repo Store, productGetter ProductGetter, opts ...Option) (*Service, error) {
 	if validation.IsNil(repo) {
 		return nil, fmt.Errorf("%w order store is nil", ErrCannotCreateOrderService)
 	}
@@ -56,20 +108,40 @@ func NewOrderService(repo Store, productGetter ProductGetter) (*Service, error)
 		return nil, fmt.Errorf("%w product getter is nil", ErrCannotCreateOrderService)
 	}
 
-	return &Service{
+	svc := &Service{
 		repo:          repo,
 		productGetter: productGetter,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(svc)
+	}
+
+	return svc, nil
 }
 
-// NewOrder creates a new order.
+// NewOrder creates a new order, for the customer if customerID is not empty,
+// using the coupon code if couponCode is not empty. The code is normalised
+// (trimmed, and upper cased), as the ledger records it, and checked that it is
+// valid, on behalf of the client, for as long as ctx allows. It is redeemed before the order is stored, so an order that uses up
+// the last of a code is never stored without its redemption.
 func (svc *Service) NewOrder(
+	ctx context.Context,
 	items []Item,
+	customerID string,
+	couponCode string,
+	client string,
 ) (Order, error) {
 	// Order must have at least 1 item.
 	// TODO ensure that this matches expected business requirements.
 	if len(items) < 1 {
-		return Order{}, fmt.Errorf("%w no items", ErrCreateFailed)
+		return Order{}, fmt.Errorf("%w %w no items", ErrCreateFailed, ErrInvalidOrder)
+	}
+
+	couponCode = strings.ToUpper(strings.TrimSpace(couponCode))
+
+	if couponCode != "" && (validation.IsNil(svc.coupons) || validation.IsNil(svc.validator)) {
+		return Order{}, fmt.Errorf("%w %w coupons are not accepted", ErrCreateFailed, ErrInvalidOrder)
 	}
 
 	// Fetch current product information for this order.
@@ -82,6 +154,10 @@ func (svc *Service) NewOrder(
 	}
 
 	productList, missed, err := svc.productGetter.GetProductsByIDs(productIDs)
+	if errors.Is(err, product.ErrNotFound) {
+		return Order{}, fmt.Errorf("%w %w product list %v not found: %w", ErrCreateFailed, ErrInvalidOrder, productIDs, err)
+	}
+
 	if err != nil {
 		// TODO: Not sure if this is a catastrophic error, or not.  Am
 		// treating it as catastrophic because order fulfilment, and
@@ -108,16 +184,37 @@ func (svc *Service) NewOrder(
 	if len(productReferences) == 0 {
 		// TODO: Assuming that no products found means that no order can be
 		// made.
-		return Order{}, fmt.Errorf("%w product list %v not found: %v", ErrCreateFailed, productIDs, err)
+		return Order{}, fmt.Errorf("%w %w product list %v not found", ErrCreateFailed, ErrInvalidOrder, productIDs)
+	}
+
+	if couponCode != "" {
+		valid, err := svc.validator.IsValidCoupon(ctx, couponCode, client)
+		if err != nil {
+			return Order{}, fmt.Errorf("%w coupon %s not validated: %w", ErrCreateFailed, couponCode, err)
+		}
+
+		if !valid {
+			return Order{}, fmt.Errorf("%w %w coupon %s is not valid", ErrCreateFailed, ErrInvalidOrder, couponCode)
+		}
 	}
 
 	orderID := uuid.New().String()
 
+	if couponCode != "" {
+		// The redeemer's errors are passed on, so that the caller can tell
+		// why the code was refused.
+		if err := svc.coupons.RedeemCoupon(couponCode, orderID, customerID); err != nil {
+			return Order{}, fmt.Errorf("%w coupon %s not redeemed: %w", ErrCreateFailed, couponCode, err)
+		}
+	}
+
 	// Persist the order.
 	newOrder := Order{
-		ID:       orderID,
-		Items:    items,
-		Products: productReferences,
+		ID:         orderID,
+		CustomerID: customerID,
+		CouponCode: couponCode,
+		Items:      items,
+		Products:   productReferences,
 	}
 
 	err = svc.repo.CreateOrder(&newOrder)
@@ -125,12 +222,38 @@ func (svc *Service) NewOrder(
 		// TODO: Need clarification on surfacing repository errors to the caller.
 		// log full error here and return simpler error.
 		log.Printf("%v with repository error %v", ErrCreateFailed, err)
+
+		if couponCode != "" {
+			if err := svc.coupons.ReleaseCoupon(orderID); err != nil {
+				log.Printf("cannot release coupon %s of unsaved order %s with error %v", couponCode, orderID, err)
+			}
+		}
+
 		return Order{}, fmt.Errorf("%w repository error", ErrCreateFailed)
 	}
 
 	return newOrder, nil
 }
 
+// CancelOrder cancels an order, returning the use of its coupon code.
+// Cancelling an order that is already cancelled is not an error, so a
+// cancellation that failed to release the code can be retried.
+func (svc *Service) CancelOrder(id string) (Order, error) {
+	cancelled, err := svc.repo.CancelOrder(id)
+	if err != nil {
+		return Order{}, err
+	}
+
+	if cancelled.CouponCode != "" && !validation.IsNil(svc.coupons) {
+		if err := svc.coupons.ReleaseCoupon(id); err != nil {
+			return Order{}, fmt.Errorf("%w coupon %s of order %s not released: %w",
+				ErrCancelFailed, cancelled.CouponCode, id, err)
+		}
+	}
+
+	return cancelled, nil
+}
+
 // GetOrderByID gets a single order by id.
 func (svc *Service) GetOrderByID(id string) (Order, error) {
 	order, err := svc.repo.GetByID(id)
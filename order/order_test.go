@@ -2,6 +2,8 @@
 package order_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -59,6 +61,65 @@ func (m *MockOrderStore) GetByID(id string) (order.Order, error) {
 	return order.Order{}, order.ErrNotFound
 }
 
+func (m *MockOrderStore) CancelOrder(id string) (order.Order, error) {
+	if o, ok := m.orders[id]; ok {
+		o.Cancelled = true
+		return *o, nil
+	}
+
+	return order.Order{}, order.ErrNotFound
+}
+
+// MockCouponRedeemer allows each code to be redeemed a limited number of
+// times.
+type MockCouponRedeemer struct {
+	limits map[string]int
+	// redeemed k=order ID, v=code.
+	redeemed map[string]string
+}
+
+func NewMockCouponRedeemer(limits map[string]int) *MockCouponRedeemer {
+	return &MockCouponRedeemer{limits: limits, redeemed: map[string]string{}}
+}
+
+func (m *MockCouponRedeemer) RedeemCoupon(code, orderID, _ string) error {
+	uses := 0
+
+	for _, redeemed := range m.redeemed {
+		if redeemed == code {
+			uses++
+		}
+	}
+
+	if uses >= m.limits[code] {
+		return errCouponUsedUp
+	}
+
+	m.redeemed[orderID] = code
+
+	return nil
+}
+
+func (m *MockCouponRedeemer) ReleaseCoupon(orderID string) error {
+	delete(m.redeemed, orderID)
+
+	return nil
+}
+
+var errCouponUsedUp = errors.New("coupon used up")
+
+// MockCouponValidator knows the valid codes, or fails every check with err.
+type MockCouponValidator struct {
+	valid map[string]bool
+	err   error
+}
+
+func (m MockCouponValidator) IsValidCoupon(_ context.Context, code, _ string) (bool, error) {
+	return m.valid[code], m.err
+}
+
+var errSearchFailed = errors.New("search failed")
+
 func TestNewOrderService(t *testing.T) {
 	testcases := map[string]struct {
 		orderStore    order.Store
@@ -166,6 +227,25 @@ func TestNewOrder(t *testing.T) {
 			items:         []order.Item{{ProductID: "10", Quantity: 1}},
 			expectedError: order.ErrCreateFailed,
 		},
+		"Zero Item Order is an invalid order": {
+			orderStore: inmemoryorderdatastore.NewInMemoryOrderStore(),
+			productGetter: &MockProductGetter{
+				products: map[string]product.Product{
+					"1": {ID: "1", Name: "Test", PriceCents: 100},
+				},
+			},
+			expectedError: order.ErrInvalidOrder,
+		},
+		"No such product ordered is an invalid order": {
+			orderStore: inmemoryorderdatastore.NewInMemoryOrderStore(),
+			productGetter: &MockProductGetter{
+				products: map[string]product.Product{
+					"1": {ID: "1", Name: "Test", PriceCents: 100},
+				},
+			},
+			items:         []order.Item{{ProductID: "10", Quantity: 1}},
+			expectedError: order.ErrInvalidOrder,
+		},
 		"Respository error during save": {
 			orderStore: &MockOrderStore{
 				err: fmt.Errorf("Mocked error"),
@@ -188,7 +268,7 @@ func TestNewOrder(t *testing.T) {
 			)
 			assert.Nil(t, err)
 
-			_, actualError := nos.NewOrder(tc.items)
+			_, actualError := nos.NewOrder(context.Background(), tc.items, "", "", "")
 
 			if tc.expectedError != nil {
 				assert.ErrorIsf(
@@ -205,3 +285,149 @@ func TestNewOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestNewOrderWithCoupon(t *testing.T) {
+	productGetter := &MockProductGetter{
+		products: map[string]product.Product{
+			"1": {ID: "1", Name: "Test", PriceCents: 100},
+		},
+	}
+	items := []order.Item{{ProductID: "1", Quantity: 1}}
+
+	validator := MockCouponValidator{valid: map[string]bool{"FIFTYOFF": true}}
+
+	testcases := map[string]struct {
+		orderStore         order.Store
+		coupons            *MockCouponRedeemer
+		validator          order.CouponValidator
+		couponCode         string
+		expectedCouponCode string
+		expectedError      error
+		expectedRedeemed   int
+	}{
+		"Coupon redeemed": {
+			orderStore:         inmemoryorderdatastore.NewInMemoryOrderStore(),
+			coupons:            NewMockCouponRedeemer(map[string]int{"FIFTYOFF": 1}),
+			validator:          validator,
+			couponCode:         "FIFTYOFF",
+			expectedCouponCode: "FIFTYOFF",
+			expectedRedeemed:   1,
+		},
+		"Coupon code is normalised": {
+			orderStore:         inmemoryorderdatastore.NewInMemoryOrderStore(),
+			coupons:            NewMockCouponRedeemer(map[string]int{"FIFTYOFF": 1}),
+			validator:          validator,
+			couponCode:         " fiftyOff ",
+			expectedCouponCode: "FIFTYOFF",
+			expectedRedeemed:   1,
+		},
+		"Coupon refused": {
+			orderStore:    inmemoryorderdatastore.NewInMemoryOrderStore(),
+			coupons:       NewMockCouponRedeemer(map[string]int{}),
+			validator:     validator,
+			couponCode:    "FIFTYOFF",
+			expectedError: errCouponUsedUp,
+		},
+		"Invalid coupon is not redeemed": {
+			orderStore:    inmemoryorderdatastore.NewInMemoryOrderStore(),
+			coupons:       NewMockCouponRedeemer(map[string]int{"MADEUP": 1}),
+			validator:     validator,
+			couponCode:    "MADEUP",
+			expectedError: order.ErrInvalidOrder,
+		},
+		"Coupon that cannot be validated is not redeemed": {
+			orderStore:    inmemoryorderdatastore.NewInMemoryOrderStore(),
+			coupons:       NewMockCouponRedeemer(map[string]int{"FIFTYOFF": 1}),
+			validator:     MockCouponValidator{err: errSearchFailed},
+			couponCode:    "FIFTYOFF",
+			expectedError: errSearchFailed,
+		},
+		"Coupons not accepted": {
+			orderStore:    inmemoryorderdatastore.NewInMemoryOrderStore(),
+			couponCode:    "FIFTYOFF",
+			expectedError: order.ErrInvalidOrder,
+		},
+		"Coupons not accepted without a validator": {
+			orderStore:    inmemoryorderdatastore.NewInMemoryOrderStore(),
+			coupons:       NewMockCouponRedeemer(map[string]int{"FIFTYOFF": 1}),
+			couponCode:    "FIFTYOFF",
+			expectedError: order.ErrInvalidOrder,
+		},
+		"Coupon released when the order cannot be saved": {
+			orderStore:    &MockOrderStore{err: fmt.Errorf("Mocked error")},
+			coupons:       NewMockCouponRedeemer(map[string]int{"FIFTYOFF": 1}),
+			validator:     validator,
+			couponCode:    "FIFTYOFF",
+			expectedError: order.ErrCreateFailed,
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			opts := []order.Option{}
+			if tc.coupons != nil {
+				opts = append(opts, order.WithCouponRedeemer(tc.coupons))
+			}
+
+			if tc.validator != nil {
+				opts = append(opts, order.WithCouponValidator(tc.validator))
+			}
+
+			nos, err := order.NewOrderService(tc.orderStore, productGetter, opts...)
+			assert.Nil(t, err)
+
+			newOrder, actualError := nos.NewOrder(context.Background(), items, "customer-1", tc.couponCode, "")
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+
+			if tc.expectedError == nil {
+				assert.Equal(t, tc.expectedCouponCode, newOrder.CouponCode)
+				assert.Equal(t, "customer-1", newOrder.CustomerID)
+			}
+
+			if tc.coupons != nil {
+				assert.Len(t, tc.coupons.redeemed, tc.expectedRedeemed)
+			}
+		})
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	productGetter := &MockProductGetter{
+		products: map[string]product.Product{
+			"1": {ID: "1", Name: "Test", PriceCents: 100},
+		},
+	}
+	coupons := NewMockCouponRedeemer(map[string]int{"FIFTYOFF": 1})
+
+	nos, err := order.NewOrderService(
+		inmemoryorderdatastore.NewInMemoryOrderStore(),
+		productGetter,
+		order.WithCouponRedeemer(coupons),
+		order.WithCouponValidator(MockCouponValidator{valid: map[string]bool{"FIFTYOFF": true}}),
+	)
+	assert.Nil(t, err)
+
+	items := []order.Item{{ProductID: "1", Quantity: 1}}
+
+	placed, err := nos.NewOrder(context.Background(), items, "", "FIFTYOFF", "")
+	assert.Nil(t, err)
+
+	// The only use of the code is taken.
+	_, err = nos.NewOrder(context.Background(), items, "", "FIFTYOFF", "")
+	assert.ErrorIs(t, err, errCouponUsedUp)
+
+	cancelled, err := nos.CancelOrder(placed.ID)
+	assert.Nil(t, err)
+	assert.True(t, cancelled.Cancelled)
+
+	// Cancelling again is not an error.
+	_, err = nos.CancelOrder(placed.ID)
+	assert.Nil(t, err)
+
+	// The use returned by the cancellation can be taken.
+	_, err = nos.NewOrder(context.Background(), items, "", "FIFTYOFF", "")
+	assert.Nil(t, err)
+
+	_, err = nos.CancelOrder("does-not-exist")
+	assert.ErrorIs(t, err, order.ErrNotFound)
+}
@@ -8,6 +8,7 @@ import "errors"
 var (
 	ErrCreateFailed = errors.New("creating order failed")
 	ErrNotFound     = errors.New("order not found")
+	ErrCancelFailed = errors.New("cancelling order failed")
 )
 
 // Store defines the contract for persistent storage operations related
@@ -17,4 +18,7 @@ type Store interface {
 	CreateOrder(*Order) error
 	// GetByID returns an order that has the supplied ID.
 	GetByID(string) (Order, error)
+	// CancelOrder marks the order with the supplied ID cancelled, and returns
+	// it. Cancelling a cancelled order is not an error.
+	CancelOrder(string) (Order, error)
 }
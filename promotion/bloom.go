@@ -0,0 +1,77 @@
+package promotion
+
+// bloomBitsPerCode, and bloomHashes, give a false positive rate of roughly
+// 1%, which is plenty to skip the binary search for most codes that are not in
+// a file.
+const (
+	bloomBitsPerCode = 10
+	bloomHashes      = 7
+)
+
+// FNV-1a constants, see http://www.isthe.com/chongo/tech/comp/fnv/
+// hash/fnv is not used because it allocates, and the hash must be stable
+// across processes (so hash/maphash is out).
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// bloomFilter is a fixed size Bloom filter, stored as a bit set.
+type bloomFilter struct {
+	bits   []byte
+	hashes uint32
+}
+
+func newBloomFilter(numCodes int) bloomFilter {
+	numBits := max(numCodes*bloomBitsPerCode, 64)
+
+	return bloomFilter{
+		bits:   make([]byte, (numBits+7)/8),
+		hashes: bloomHashes,
+	}
+}
+
+func fnv1a(data []byte) uint64 {
+	hash := uint64(fnvOffset64)
+
+	for _, b := range data {
+		hash ^= uint64(b)
+		hash *= fnvPrime64
+	}
+
+	return hash
+}
+
+// positions calls fn with each bit position for the data. The k hashes are
+// derived from a single 64 bit hash (Kirsch-Mitzenmacher double hashing).
+func (b bloomFilter) positions(data []byte, fn func(bit uint64) bool) {
+	hash := fnv1a(data)
+	h1 := hash & 0xffffffff
+	h2 := hash>>32 | 1
+	numBits := uint64(len(b.bits)) * 8
+
+	for i := range uint64(b.hashes) {
+		if !fn((h1 + i*h2) % numBits) {
+			return
+		}
+	}
+}
+
+func (b bloomFilter) add(data []byte) {
+	b.positions(data, func(bit uint64) bool {
+		b.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// mayContain reports false if the data is definitely not in the filter.
+func (b bloomFilter) mayContain(data []byte) bool {
+	found := true
+
+	b.positions(data, func(bit uint64) bool {
+		found = b.bits[bit/8]&(1<<(bit%8)) != 0
+		return found
+	})
+
+	return found
+}
@@ -0,0 +1,267 @@
+package promotion
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultCacheTTL is how long a search's results are used for, after which the
+// files are searched again. The fingerprint of the files already stops changed
+// files using old results, the TTL stops the cache growing forever.
+const DefaultCacheTTL = 30 * 24 * time.Hour
+
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrInvalidCacheEntry = errors.New("invalid cache entry")
+	ErrCacheMismatch     = errors.New("cached results are for other files")
+)
+
+// CacheEntry is a cached result, the number of files in the file set (see
+// Store) that the code was found in.
+type CacheEntry struct {
+	FileSet    string
+	Code       string
+	MatchCount int
+	// CreatedAt is when the files were searched, the zero time for results
+	// cached before it was recorded.
+	CreatedAt time.Time
+	// TTL is how long the result is used for, zero is forever.
+	TTL time.Duration
+	// Content is the ContentFingerprint of the file set's files, recorded
+	// when the entry is exported with them (see CacheEntriesOf), so that it
+	// can be imported for copies of the files elsewhere. It is not cached.
+	Content string
+}
+
+// Expired reports whether the entry is too old to use at now.
+func (e CacheEntry) Expired(now time.Time) bool {
+	return expired(e.CreatedAt, e.TTL, now)
+}
+
+// Validate checks that the entry can be cached.
+func (e CacheEntry) Validate() error {
+	switch {
+	case e.FileSet == "":
+		return fmt.Errorf("%w, the file set is missing", ErrInvalidCacheEntry)
+	case e.Code == "":
+		return fmt.Errorf("%w, the code is missing", ErrInvalidCacheEntry)
+	case e.MatchCount < 0:
+		return fmt.Errorf("%w, %s has a negative match count", ErrInvalidCacheEntry, e.Code)
+	case e.TTL < 0:
+		return fmt.Errorf("%w, %s has a negative TTL", ErrInvalidCacheEntry, e.Code)
+	}
+
+	return nil
+}
+
+// expired reports whether a result created, and kept for ttl, is too old to
+// use at now.
+func expired(created time.Time, ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && !now.Before(created.Add(ttl))
+}
+
+// CacheTTLSeconds is the TTL as the stores keep it, in whole seconds. A TTL
+// with a fraction of a second is rounded up, so that a short TTL is never
+// kept as zero, which is forever.
+func CacheTTLSeconds(ttl time.Duration) int64 {
+	seconds := int64(ttl / time.Second)
+	if ttl%time.Second > 0 {
+		seconds++
+	}
+
+	return seconds
+}
+
+// CacheFilter selects cache entries, the zero CacheFilter selects them all.
+type CacheFilter struct {
+	// FileSet only selects the entries of the file set.
+	FileSet string
+	// Codes, when there are any, only selects the entries of the codes.
+	Codes []string
+	// ExpiredAt only selects the entries that have expired by the time.
+	ExpiredAt time.Time
+}
+
+// CacheStats summarises the cache.
+type CacheStats struct {
+	Entries  int
+	FileSets int
+	// Expired is the number of entries that are too old to use.
+	Expired int
+	// Oldest and Newest are the creation times of the entries, ignoring
+	// those without one. Both are zero when there are none.
+	Oldest time.Time
+	Newest time.Time
+}
+
+// CacheStore inspects, and maintains, the results cached by a Store. Times
+// are kept to the second, and TTLs rounded up to it (see CacheTTLSeconds).
+type CacheStore interface {
+	// CacheStats summarises the cache, counting the entries expired at now.
+	CacheStats(now time.Time) (CacheStats, error)
+	// ListCacheEntries returns the entries selected by the filter, ordered
+	// by file set and code.
+	ListCacheEntries(filter CacheFilter) ([]CacheEntry, error)
+	// DeleteCacheEntries removes the entries selected by the filter, and
+	// returns the number removed.
+	DeleteCacheEntries(filter CacheFilter) (int, error)
+	// ImportCacheEntries caches the entries, replacing those already cached
+	// for the same file set and code.
+	ImportCacheEntries(entries []CacheEntry) error
+}
+
+// CacheEntriesOf selects the entries cached for the files, with any
+// normalisation, and records the files' ContentFingerprint on them, so that
+// they can be imported for copies of the files on another machine (see
+// RekeyCacheEntries).
+func CacheEntriesOf(entries []CacheEntry, files []string) ([]CacheEntry, error) {
+	fingerprint, err := FileSetFingerprint(files)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ContentFingerprint(files)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []CacheEntry{}
+
+	for _, entry := range entries {
+		if entryFingerprint, _ := splitFileSetKey(entry.FileSet); entryFingerprint == fingerprint {
+			entry.Content = content
+			selected = append(selected, entry)
+		}
+	}
+
+	return selected, nil
+}
+
+// RekeyCacheEntries moves exported entries to the file set of the files,
+// keeping their normalisation. The files must have the content that the
+// entries were exported with (see CacheEntriesOf), but may be anywhere, and of
+// any age, so that results cached on one machine are used on another.
+func RekeyCacheEntries(entries []CacheEntry, files []string) ([]CacheEntry, error) {
+	fingerprint, err := FileSetFingerprint(files)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ContentFingerprint(files)
+	if err != nil {
+		return nil, err
+	}
+
+	rekeyed := make([]CacheEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		switch entry.Content {
+		case content:
+		case "":
+			return nil, fmt.Errorf("%w, %s of file set %s was exported without its files",
+				ErrCacheMismatch, entry.Code, entry.FileSet)
+		default:
+			return nil, fmt.Errorf("%w, %s of file set %s was cached for files with other content",
+				ErrCacheMismatch, entry.Code, entry.FileSet)
+		}
+
+		_, normalisation := splitFileSetKey(entry.FileSet)
+		entry.FileSet = fingerprint + "/" + normalisation
+
+		rekeyed = append(rekeyed, entry)
+	}
+
+	return rekeyed, nil
+}
+
+// cacheEntryJSON is a CacheEntry as a line of JSON.
+type cacheEntryJSON struct {
+	FileSet    string    `json:"fileSet"`
+	Code       string    `json:"code"`
+	MatchCount int       `json:"matchCount"`
+	CreatedAt  time.Time `json:"createdAt,omitzero"`
+	// TTL is a Go duration, eg. "720h0m0s", omitted for results kept
+	// forever.
+	TTL string `json:"ttl,omitempty"`
+	// Content is omitted for entries exported without their files.
+	Content string `json:"content,omitempty"`
+}
+
+// WriteCacheEntries writes the entries as JSON lines, one entry per line.
+func WriteCacheEntries(w io.Writer, entries []CacheEntry) error {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+
+	for _, entry := range entries {
+		line := cacheEntryJSON{
+			FileSet:    entry.FileSet,
+			Code:       entry.Code,
+			MatchCount: entry.MatchCount,
+			CreatedAt:  entry.CreatedAt,
+			Content:    entry.Content,
+		}
+
+		if entry.TTL > 0 {
+			line.TTL = entry.TTL.String()
+		}
+
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("writing cache entry %s: %w", entry.Code, err)
+		}
+	}
+
+	return writer.Flush()
+}
+
+// ReadCacheEntries reads the JSON lines written by WriteCacheEntries. Blank
+// lines are skipped, and every entry is validated.
+func ReadCacheEntries(r io.Reader) ([]CacheEntry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxPatternLine)
+
+	entries := []CacheEntry{}
+
+	for number := 1; scanner.Scan(); number++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var line cacheEntryJSON
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("%w on line %d: %w", ErrInvalidCacheEntry, number, err)
+		}
+
+		entry := CacheEntry{
+			FileSet:    line.FileSet,
+			Code:       line.Code,
+			MatchCount: line.MatchCount,
+			CreatedAt:  line.CreatedAt,
+			Content:    line.Content,
+		}
+
+		if line.TTL != "" {
+			ttl, err := time.ParseDuration(line.TTL)
+			if err != nil {
+				return nil, fmt.Errorf("%w on line %d: %w", ErrInvalidCacheEntry, number, err)
+			}
+
+			entry.TTL = ttl
+		}
+
+		if err := entry.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCacheEntry, err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,182 @@
+package promotion_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheEntryExpired(t *testing.T) {
+	march := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	entry := promotion.CacheEntry{CreatedAt: march, TTL: time.Hour}
+
+	assert.False(t, entry.Expired(march.Add(time.Hour-time.Second)))
+	assert.True(t, entry.Expired(march.Add(time.Hour)))
+
+	entry.TTL = 0
+	assert.False(t, entry.Expired(march.AddDate(10, 0, 0)), "no TTL is forever")
+}
+
+func TestCacheEntriesRoundTrip(t *testing.T) {
+	march := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	entries := []promotion.CacheEntry{
+		{FileSet: "abc/lines", Code: "FIFTYOFF", MatchCount: 2, CreatedAt: march, TTL: 720 * time.Hour},
+		{FileSet: "abc/lines", Code: "HAPPYHRS"},
+	}
+
+	var buffer bytes.Buffer
+	require.NoError(t, promotion.WriteCacheEntries(&buffer, entries))
+
+	assert.Equal(t,
+		`{"fileSet":"abc/lines","code":"FIFTYOFF","matchCount":2,"createdAt":"2026-03-01T12:00:00Z","ttl":"720h0m0s"}`+"\n"+
+			`{"fileSet":"abc/lines","code":"HAPPYHRS","matchCount":0}`+"\n",
+		buffer.String())
+
+	actual, err := promotion.ReadCacheEntries(&buffer)
+	require.NoError(t, err)
+	assert.Equal(t, entries, actual)
+}
+
+func TestReadCacheEntries(t *testing.T) {
+	testcases := map[string]struct {
+		input         string
+		expected      []promotion.CacheEntry
+		expectedError error
+	}{
+		"Empty": {
+			expected: []promotion.CacheEntry{},
+		},
+		"Blank lines are skipped": {
+			input:    "\n" + `{"fileSet":"abc","code":"FIFTYOFF","matchCount":1}` + "\n\n",
+			expected: []promotion.CacheEntry{{FileSet: "abc", Code: "FIFTYOFF", MatchCount: 1}},
+		},
+		"Not JSON": {
+			input:         "FIFTYOFF\n",
+			expectedError: promotion.ErrInvalidCacheEntry,
+		},
+		"Unparseable TTL": {
+			input:         `{"fileSet":"abc","code":"FIFTYOFF","ttl":"a month"}`,
+			expectedError: promotion.ErrInvalidCacheEntry,
+		},
+		"Missing file set": {
+			input:         `{"code":"FIFTYOFF"}`,
+			expectedError: promotion.ErrInvalidCacheEntry,
+		},
+		"Negative match count": {
+			input:         `{"fileSet":"abc","code":"FIFTYOFF","matchCount":-1}`,
+			expectedError: promotion.ErrInvalidCacheEntry,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actual, actualError := promotion.ReadCacheEntries(strings.NewReader(tc.input))
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+// TestCacheEntriesImportedElsewhere checks that results exported with their
+// files are used for copies of the files in another directory, which have
+// their own paths, and modification times.
+func TestCacheEntriesImportedElsewhere(t *testing.T) {
+	content := map[string]string{"first.txt": "FIFTYOFF\nTENOFF\n", "second.txt": "FIFTYOFF\n"}
+
+	writeFiles := func(dir string, changed string) []string {
+		files := []string{}
+
+		for name, data := range content {
+			file := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(file, []byte(data+changed), 0o600))
+
+			files = append(files, file)
+		}
+
+		return files
+	}
+
+	exported := writeFiles(t.TempDir(), "")
+
+	exporter := memory.New()
+	search, err := promotion.NewSearch(exporter)
+	require.NoError(t, err)
+
+	_, err = search.IsValidBatch(t.Context(), []string{"FIFTYOFF", "TENOFF"}, exported)
+	require.NoError(t, err)
+
+	cached, err := exporter.ListCacheEntries(promotion.CacheFilter{})
+	require.NoError(t, err)
+
+	entries, err := promotion.CacheEntriesOf(cached, exported)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	var buffer bytes.Buffer
+	require.NoError(t, promotion.WriteCacheEntries(&buffer, entries))
+
+	entries, err = promotion.ReadCacheEntries(&buffer)
+	require.NoError(t, err)
+
+	// The copies are in another directory, and a year older.
+	copies := writeFiles(t.TempDir(), "")
+	for _, file := range copies {
+		lastYear := time.Now().AddDate(-1, 0, 0)
+		require.NoError(t, os.Chtimes(file, lastYear, lastYear))
+	}
+
+	testcases := map[string]struct {
+		entries       []promotion.CacheEntry
+		files         []string
+		expectedError error
+	}{
+		"Copies of the files": {
+			entries: entries,
+			files:   copies,
+		},
+		"Files with other content": {
+			entries:       entries,
+			files:         writeFiles(t.TempDir(), "HAPPYHRS\n"),
+			expectedError: promotion.ErrCacheMismatch,
+		},
+		"Results exported without their files": {
+			entries:       cached,
+			files:         copies,
+			expectedError: promotion.ErrCacheMismatch,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			rekeyed, err := promotion.RekeyCacheEntries(tc.entries, tc.files)
+			if tc.expectedError != nil {
+				assert.ErrorIsf(t, err, tc.expectedError, "expected error %v, but got %v", tc.expectedError, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			importer := memory.New()
+			require.NoError(t, importer.ImportCacheEntries(rekeyed))
+
+			search, err := promotion.NewSearch(importer)
+			require.NoError(t, err)
+
+			actual, err := search.IsValidBatch(t.Context(), []string{"FIFTYOFF", "TENOFF"}, tc.files)
+			require.NoError(t, err)
+
+			assert.Equal(t, promotion.CacheHit, actual["FIFTYOFF"].Cache)
+			assert.True(t, actual["FIFTYOFF"].Valid)
+			assert.Equal(t, promotion.CacheHit, actual["TENOFF"].Cache)
+			assert.False(t, actual["TENOFF"].Valid)
+		})
+	}
+}
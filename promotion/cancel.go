@@ -0,0 +1,37 @@
+package promotion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCancelled is returned when a search is stopped by its context, it also
+// wraps the context's error (eg. context.DeadlineExceeded). A cancelled
+// search returns no results, partial results are never cached.
+var ErrCancelled = errors.New("coupon search cancelled")
+
+// cancelCheckBytes is how much of a chunk is searched between checks of the
+// context, small enough that a cancelled search stops promptly, large enough
+// that checking is free.
+const cancelCheckBytes = 1 << 20
+
+// cancelled returns an error wrapping ErrCancelled, and the cause, if the
+// context is done.
+func cancelled(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", ErrCancelled, context.Cause(ctx))
+}
+
+// isDone reports whether the done channel, from a context, is closed.
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package promotion_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchFileCancelled(t *testing.T) {
+	path := writeSyntheticCoupons(t, t.TempDir(), 100_000)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	for _, strategy := range readStrategies {
+		t.Run(strategy.String(), func(t *testing.T) {
+			matches, err := promotion.Scanner{Reader: strategy}.SearchFile(ctx, path, []string{syntheticCode(1)})
+
+			assert.ErrorIs(t, err, promotion.ErrCancelled)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Nil(t, matches)
+		})
+	}
+}
+
+// TestSearchFileTimeout checks that a search of a pipe, whose writer never
+// finishes, stops when the context times out.
+func TestSearchFileTimeout(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+
+	defer writer.Close()
+
+	stdin := os.Stdin
+	os.Stdin = reader
+
+	defer func() { os.Stdin = stdin }()
+
+	_, err = writer.WriteString("FIFTYOFF\n")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err = promotion.Scanner{}.SearchFile(ctx, promotion.StdinPath, []string{"FIFTYOFF"})
+
+	assert.ErrorIs(t, err, promotion.ErrCancelled)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+func TestIsValidBatchCancelled(t *testing.T) {
+	first := filepath.Join("testdata", "coupons.txt")
+	second := writeSyntheticCoupons(t, t.TempDir(), 100_000)
+
+	testcases := map[string]struct {
+		policy promotion.ErrorPolicy
+	}{
+		"Fail fast": {policy: promotion.FailFast},
+		"Partial":   {policy: promotion.Partial},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			store := newStubStore()
+
+			search, err := promotion.NewSearch(store, promotion.WithErrorPolicy(tc.policy))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			cancel()
+
+			results, actualError := search.IsValidBatch(ctx, []string{"FIFTYOFF"}, []string{first, second})
+
+			assert.ErrorIsf(t, actualError, promotion.ErrCancelled, "expected error %v, but got %v", promotion.ErrCancelled, actualError)
+			assert.Nil(t, results)
+			assert.Empty(t, store.counts(t, first, second), "cancelled results must not be cached")
+
+			// The same search, with a live context, is complete.
+			results, err = search.IsValidBatch(t.Context(), []string{"FIFTYOFF"}, []string{first, second})
+			require.NoError(t, err)
+
+			assert.Equal(t, 1, results["FIFTYOFF"].FileCount)
+			assert.Equal(t, map[string]int{"FIFTYOFF": 1}, store.counts(t, first, second))
+		})
+	}
+}
+
+func TestReportCancelled(t *testing.T) {
+	pool, err := promotion.NewPool(promotion.Limits{Workers: 1, MaxFiles: 1, MaxMappedBytes: 1 << 20})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	reports, err := promotion.Scanner{Pool: pool}.Report(ctx, []string{"FIFTYOFF"}, []string{
+		filepath.Join("testdata", "coupons.txt"),
+		filepath.Join("testdata", "coupons.gz"),
+	})
+
+	assert.ErrorIs(t, err, promotion.ErrCancelled)
+	assert.Nil(t, reports)
+}
@@ -0,0 +1,120 @@
+package promotion
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies how a coupon file is compressed.
+type Compression int
+
+// Supported compression formats.
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionBzip2
+	CompressionZstd
+	CompressionXz
+)
+
+// ErrUnsupportedCompression is returned when no decompressor exists for the
+// compression format.
+var ErrUnsupportedCompression = errors.New("unsupported compression")
+
+// magicHeaderLen is the number of bytes needed to identify every supported
+// format.
+const magicHeaderLen = 6
+
+// Magic numbers, see the format specifications:
+//   - gzip: RFC 1952
+//   - bzip2: "BZh" followed by the block size ('1'-'9')
+//   - zstd: RFC 8878
+//   - xz: https://tukaani.org/xz/xz-file-format.txt
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionBzip2:
+		return "bzip2"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionXz:
+		return "xz"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// DetectCompression identifies the compression format from the magic number
+// at the start of a file. The file suffix is deliberately ignored, coupon
+// feeds are not always named consistently.
+func DetectCompression(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(header, xzMagic):
+		return CompressionXz
+	case len(header) > len(bzip2Magic) && bytes.HasPrefix(header, bzip2Magic) &&
+		header[len(bzip2Magic)] >= '1' && header[len(bzip2Magic)] <= '9':
+		return CompressionBzip2
+	default:
+		return CompressionNone
+	}
+}
+
+// newDecompressor wraps the reader with a streaming decompressor for the
+// format.
+// zstd frames are made of independent blocks, which are decoded on up to
+// GOMAXPROCS goroutines at once, as are the members of multi-member gzip files
+// (see newGzipReader). A gzip file that is a single member, bzip2, and xz are
+// decompressed on a single goroutine, so a file's search runs no faster than
+// its decompression.
+func newDecompressor(compression Compression, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		reader, err := newGzipReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("opening gzip stream: %w", err)
+		}
+
+		return reader, nil
+	case CompressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case CompressionZstd:
+		reader, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(runtime.GOMAXPROCS(0)))
+		if err != nil {
+			return nil, fmt.Errorf("opening zstd stream: %w", err)
+		}
+
+		return reader.IOReadCloser(), nil
+	case CompressionXz:
+		reader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("opening xz stream: %w", err)
+		}
+
+		return io.NopCloser(reader), nil
+	default:
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedCompression, compression)
+	}
+}
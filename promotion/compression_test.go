@@ -0,0 +1,216 @@
+package promotion_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectCompression(t *testing.T) {
+	testcases := map[string]struct {
+		file     string
+		expected promotion.Compression
+	}{
+		"Plain text":                   {file: "coupons.txt", expected: promotion.CompressionNone},
+		"gzip":                         {file: "coupons.gz", expected: promotion.CompressionGzip},
+		"Multi member gzip":            {file: "coupons-multimember.gz", expected: promotion.CompressionGzip},
+		"bzip2":                        {file: "coupons.bz2", expected: promotion.CompressionBzip2},
+		"zstd":                         {file: "coupons.zst", expected: promotion.CompressionZstd},
+		"xz":                           {file: "coupons.xz", expected: promotion.CompressionXz},
+		"Plain text starting with BZh": {file: "", expected: promotion.CompressionNone},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			header := []byte("BZhX\n")
+
+			if tc.file != "" {
+				data, err := os.ReadFile(filepath.Join("testdata", tc.file))
+				require.NoError(t, err)
+
+				header = data[:min(len(data), 6)]
+			}
+
+			assert.Equal(t, tc.expected, promotion.DetectCompression(header))
+		})
+	}
+}
+
+func TestSearchFileParallelCompressed(t *testing.T) {
+	expected := map[string]int{"FIFTYOFF": 3, "TENOFF": 1}
+
+	testcases := map[string]struct {
+		file     string
+		expected map[string]int
+	}{
+		"Plain text": {file: "coupons.txt", expected: expected},
+		"gzip":       {file: "coupons.gz", expected: expected},
+		"Multi member gzip is read in full": {
+			file:     "coupons-multimember.gz",
+			expected: map[string]int{"FIFTYOFF": 6, "TENOFF": 2},
+		},
+		"bzip2": {file: "coupons.bz2", expected: expected},
+		"zstd":  {file: "coupons.zst", expected: expected},
+		"xz":    {file: "coupons.xz", expected: expected},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actual, err := promotion.SearchFileParallel(
+				t.Context(),
+				filepath.Join("testdata", tc.file),
+				[]string{"fiftyoff", "TENOFF", "MISSING"},
+			)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+// TestSearchFileParallelLargeCompressed checks that lines are counted
+// correctly when they straddle the blocks a stream is cut into, including a
+// line that is longer than a block.
+func TestSearchFileParallelLargeCompressed(t *testing.T) {
+	var plain bytes.Buffer
+
+	for i := range 500_000 {
+		fmt.Fprintf(&plain, "CODE%07d\n", i)
+
+		if i%1000 == 0 {
+			plain.WriteString("NEEDLE\n")
+		}
+	}
+
+	plain.WriteString(strings.Repeat("X", 5<<20) + "\n")
+	plain.WriteString("NEEDLE")
+
+	path := filepath.Join(t.TempDir(), "large.gz")
+
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write(plain.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, os.WriteFile(path, compressed.Bytes(), 0o600))
+
+	actual, err := promotion.SearchFileParallel(t.Context(), path, []string{"NEEDLE", "CODE0499999", "CODE0000000"})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"NEEDLE": 501, "CODE0499999": 1, "CODE0000000": 1}, actual)
+}
+
+func TestSearchFileParallelCorruptCompressed(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "coupons.gz"))
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "truncated.gz")
+	require.NoError(t, os.WriteFile(path, data[:len(data)-10], 0o600))
+
+	_, err = promotion.SearchFileParallel(t.Context(), path, []string{"FIFTYOFF"})
+
+	assert.Error(t, err)
+}
+
+// gzipMembers compresses each part as a separate member, at the level.
+func gzipMembers(t *testing.T, level int, parts ...[]byte) []byte {
+	t.Helper()
+
+	var compressed bytes.Buffer
+
+	for _, part := range parts {
+		writer, err := gzip.NewWriterLevel(&compressed, level)
+		require.NoError(t, err)
+
+		_, err = writer.Write(part)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+	}
+
+	return compressed.Bytes()
+}
+
+// TestSearchFileParallelMultiMemberGzip checks that members decompressed ahead
+// are used in order, and only when the member before them ends where they
+// start.
+func TestSearchFileParallelMultiMemberGzip(t *testing.T) {
+	// Members are only decompressed ahead with more than one goroutine.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// Small members, with NEEDLE split between every pair.
+	small := make([][]byte, 0, 2000)
+	for i := range cap(small) {
+		small = append(small, fmt.Appendf(nil, "DLE\nCODE%07d\nNEE", i))
+	}
+
+	small[0] = []byte("NEE")
+	small[len(small)-1] = []byte("DLE\n")
+
+	// A stored member is larger than the window.
+	var large bytes.Buffer
+	for i := range 2_000_000 {
+		fmt.Fprintf(&large, "CODE%07d\n", i)
+	}
+
+	large.WriteString("NEEDLE\n")
+
+	// A stored member holds a member, which looks like a member that
+	// follows it, but is not.
+	inner := gzipMembers(t, gzip.BestCompression, bytes.Repeat([]byte("NEEDLE\n"), 1000))
+	outer := append(append([]byte("NEEDLE\n"), inner...), '\n')
+
+	corrupt := gzipMembers(t, gzip.DefaultCompression, []byte("NEEDLE\n"), []byte("NEEDLE\n"))
+	corrupt[len(corrupt)-5]++ // The last member's size.
+
+	testcases := map[string]struct {
+		compressed    []byte
+		expected      map[string]int
+		expectedError bool
+	}{
+		"Small members, with lines split between them": {
+			compressed: gzipMembers(t, gzip.DefaultCompression, small...),
+			expected:   map[string]int{"NEEDLE": 1999, "CODE0000001": 1, "CODE0001998": 1},
+		},
+		"Member larger than the window": {
+			compressed: gzipMembers(t, gzip.NoCompression, large.Bytes(), []byte("NEEDLE\n"), []byte("NEEDLE\n")),
+			expected:   map[string]int{"NEEDLE": 3, "CODE0000001": 1, "CODE0001998": 1, "CODE1999999": 1},
+		},
+		"Member within a member is not read": {
+			compressed: gzipMembers(t, gzip.NoCompression, outer, []byte("NEEDLE\n")),
+			expected:   map[string]int{"NEEDLE": 2},
+		},
+		"Corrupt member": {
+			compressed:    corrupt,
+			expectedError: true,
+		},
+		"Trailing bytes that are not a member": {
+			compressed:    append(gzipMembers(t, gzip.DefaultCompression, []byte("NEEDLE\n")), "NEEDLE\n"...),
+			expectedError: true,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "members.gz")
+			require.NoError(t, os.WriteFile(path, tc.compressed, 0o600))
+
+			actual, err := promotion.SearchFileParallel(t.Context(), path, []string{"NEEDLE", "CODE0000001", "CODE0001998", "CODE1999999"})
+
+			if tc.expectedError {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Package datastore opens the promotion store backend chosen by configuration.
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore/memory"
+	"github.com/shanehowearth/kart/promotion/datastore/postgres"
+	"github.com/shanehowearth/kart/promotion/datastore/sqlite"
+)
+
+// Backend names.
+const (
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrUnknownBackend = errors.New("unknown promotion store backend")
+	ErrInvalidDSN     = errors.New("invalid promotion store data source name")
+)
+
+// Store is everything a promotion backend provides. Close releases its
+// connections.
+type Store interface {
+	promotion.Store
+	promotion.CacheStore
+	promotion.SourceStore
+	promotion.RedemptionStore
+	Close() error
+}
+
+// Backends are the names Open accepts.
+func Backends() []string {
+	return []string{BackendSQLite, BackendPostgres, BackendMemory}
+}
+
+// Validate checks that the backend is known, and has the DSN it needs,
+// without opening it.
+//   - sqlite: the DSN is the database file, "" is sqlite.DefaultPath.
+//   - postgres: the DSN is required, see postgres.Open.
+//   - memory: there is no DSN, the store is empty until the process exits.
+func Validate(backend, dsn string) error {
+	switch backend {
+	case BackendSQLite:
+		return nil
+	case BackendPostgres:
+		if dsn == "" {
+			return fmt.Errorf("%w, the %s store needs one", ErrInvalidDSN, backend)
+		}
+
+		return nil
+	case BackendMemory:
+		if dsn != "" {
+			return fmt.Errorf("%w, the %s store does not use one", ErrInvalidDSN, backend)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w %q is not one of %s", ErrUnknownBackend, backend, strings.Join(Backends(), ", "))
+	}
+}
+
+// Open opens, and initialises, the backend's store, see Validate for the
+// DSN each backend takes.
+func Open(backend, dsn string) (Store, error) {
+	if err := Validate(backend, dsn); err != nil {
+		return nil, err
+	}
+
+	var (
+		store Store
+		err   error
+	)
+
+	switch backend {
+	case BackendSQLite:
+		store = sqlite.New(dsn)
+	case BackendPostgres:
+		store, err = postgres.Open(dsn)
+		if err != nil {
+			return nil, err
+		}
+	case BackendMemory:
+		store = memory.New()
+	}
+
+	if err := store.InitialiseDataStore(); err != nil {
+		store.Close()
+
+		return nil, fmt.Errorf("initialising the %s store: %w", backend, err)
+	}
+
+	return store, nil
+}
@@ -0,0 +1,51 @@
+package datastore_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	testcases := map[string]struct {
+		backend       string
+		dsn           string
+		expectedError error
+	}{
+		"SQLite": {
+			backend: datastore.BackendSQLite,
+			dsn:     filepath.Join(t.TempDir(), "promotion_data.db"),
+		},
+		"Memory": {
+			backend: datastore.BackendMemory,
+		},
+		"Memory with a DSN": {
+			backend:       datastore.BackendMemory,
+			dsn:           "promotion_data.db",
+			expectedError: datastore.ErrInvalidDSN,
+		},
+		"Postgres without a DSN": {
+			backend:       datastore.BackendPostgres,
+			expectedError: datastore.ErrInvalidDSN,
+		},
+		"Unknown backend": {
+			backend:       "mysql",
+			expectedError: datastore.ErrUnknownBackend,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			store, actualError := datastore.Open(tc.backend, tc.dsn)
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+
+			if tc.expectedError == nil {
+				require.NotNil(t, store)
+				assert.NoError(t, store.Close())
+			}
+		})
+	}
+}
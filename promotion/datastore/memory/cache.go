@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// CacheStats summarises the cache, counting the entries expired at now.
+func (s *Store) CacheStats(now time.Time) (promotion.CacheStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := promotion.CacheStats{}
+
+	for _, results := range s.counts {
+		if len(results) > 0 {
+			stats.FileSets++
+		}
+
+		for _, result := range results {
+			stats.Entries++
+
+			if result.Expired(now) {
+				stats.Expired++
+			}
+
+			if result.CreatedAt.IsZero() {
+				continue
+			}
+
+			if stats.Oldest.IsZero() || result.CreatedAt.Before(stats.Oldest) {
+				stats.Oldest = result.CreatedAt
+			}
+
+			if result.CreatedAt.After(stats.Newest) {
+				stats.Newest = result.CreatedAt
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// ListCacheEntries returns the entries selected by the filter, ordered by file
+// set and code.
+func (s *Store) ListCacheEntries(filter promotion.CacheFilter) ([]promotion.CacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.selected(filter)
+
+	slices.SortFunc(entries, func(a, b promotion.CacheEntry) int {
+		return cmp.Or(cmp.Compare(a.FileSet, b.FileSet), cmp.Compare(a.Code, b.Code))
+	})
+
+	return entries, nil
+}
+
+// DeleteCacheEntries removes the entries selected by the filter, and returns
+// the number removed.
+func (s *Store) DeleteCacheEntries(filter promotion.CacheFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.selected(filter)
+
+	for _, entry := range entries {
+		delete(s.counts[entry.FileSet], entry.Code)
+
+		if len(s.counts[entry.FileSet]) == 0 {
+			delete(s.counts, entry.FileSet)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// ImportCacheEntries caches the entries, replacing those already cached for
+// the same file set and code.
+func (s *Store) ImportCacheEntries(entries []promotion.CacheEntry) error {
+	for _, entry := range entries {
+		if err := entry.Validate(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range entries {
+		s.put(entry)
+	}
+
+	return nil
+}
+
+// selected returns the entries selected by the filter. The caller holds the
+// lock.
+func (s *Store) selected(filter promotion.CacheFilter) []promotion.CacheEntry {
+	entries := []promotion.CacheEntry{}
+
+	for fileSet, results := range s.counts {
+		if filter.FileSet != "" && fileSet != filter.FileSet {
+			continue
+		}
+
+		for code, result := range results {
+			if len(filter.Codes) > 0 && !slices.Contains(filter.Codes, code) {
+				continue
+			}
+
+			if !filter.ExpiredAt.IsZero() && !result.Expired(filter.ExpiredAt) {
+				continue
+			}
+
+			entries = append(entries, promotion.CacheEntry{
+				FileSet:    fileSet,
+				Code:       code,
+				MatchCount: result.MatchCount,
+				CreatedAt:  result.CreatedAt,
+				TTL:        result.TTL,
+			})
+		}
+	}
+
+	return entries
+}
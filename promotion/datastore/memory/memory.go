@@ -0,0 +1,115 @@
+// Package memory is an in memory promotion store, for tests, and for servers
+// that do not need their cache, sources, or redemptions to outlive them.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// Store is the in memory implementation of the promotion.Store,
+// promotion.SourceStore, and promotion.RedemptionStore interfaces.
+type Store struct {
+	// mu guards every map, a single lock keeps redemptions atomic.
+	mu sync.Mutex
+	// counts k=file set, v=(k=code, v=cached result).
+	counts map[string]map[string]promotion.CacheResult
+	// sources k=source set, v=(k=path, v=source).
+	sources map[string]map[string]promotion.Source
+	// fileSets k=source set, v=file sets linked to it.
+	fileSets map[string]map[string]bool
+	// limits k=code.
+	limits map[string]promotion.CouponLimits
+	// redemptions are in the order they were made.
+	redemptions []promotion.Redemption
+}
+
+var (
+	_ promotion.Store           = (*Store)(nil)
+	_ promotion.CacheStore      = (*Store)(nil)
+	_ promotion.SourceStore     = (*Store)(nil)
+	_ promotion.RedemptionStore = (*Store)(nil)
+)
+
+// New creates an empty store.
+func New() *Store {
+	return &Store{
+		counts:   map[string]map[string]promotion.CacheResult{},
+		sources:  map[string]map[string]promotion.Source{},
+		fileSets: map[string]map[string]bool{},
+		limits:   map[string]promotion.CouponLimits{},
+	}
+}
+
+// InitialiseDataStore does nothing, the store is ready once created.
+func (s *Store) InitialiseDataStore() error {
+	return nil
+}
+
+// Close does nothing, the contents are dropped with the store.
+func (s *Store) Close() error {
+	return nil
+}
+
+// GetCodeFileMatchCounts returns the number of files, in the file set, that
+// the code was found in.
+func (s *Store) GetCodeFileMatchCounts(fileSet string, codes []string) (map[string]promotion.CacheResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make(map[string]promotion.CacheResult, len(codes))
+
+	for _, code := range codes {
+		results[code] = s.counts[fileSet][code]
+	}
+
+	return results, nil
+}
+
+// AddCodeFileMatchCounts caches the file match counts for the given codes,
+// replacing those already cached.
+func (s *Store) AddCodeFileMatchCounts(fileSet string, counts map[string]int, created time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for code, count := range counts {
+		s.put(promotion.CacheEntry{FileSet: fileSet, Code: code, MatchCount: count, CreatedAt: created, TTL: ttl})
+	}
+
+	return nil
+}
+
+// put caches the entry, to the second, as the database backends do. The
+// caller holds the lock.
+func (s *Store) put(entry promotion.CacheEntry) {
+	if s.counts[entry.FileSet] == nil {
+		s.counts[entry.FileSet] = map[string]promotion.CacheResult{}
+	}
+
+	result := promotion.CacheResult{
+		MatchCount: entry.MatchCount,
+		Found:      true,
+		TTL:        time.Duration(promotion.CacheTTLSeconds(entry.TTL)) * time.Second,
+	}
+
+	if !entry.CreatedAt.IsZero() {
+		result.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Second)
+	}
+
+	s.counts[entry.FileSet][entry.Code] = result
+}
+
+// DeleteCodeFileMatchCounts removes the cached counts of the codes for the
+// file set.
+func (s *Store) DeleteCodeFileMatchCounts(fileSet string, codes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range codes {
+		delete(s.counts[fileSet], code)
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion/datastore"
+	"github.com/shanehowearth/kart/promotion/datastore/memory"
+	"github.com/shanehowearth/kart/promotion/datastore/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) datastore.Store {
+		t.Helper()
+
+		return memory.New()
+	})
+}
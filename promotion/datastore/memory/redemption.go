@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// SetLimits replaces the limits of the code.
+func (s *Store) SetLimits(code string, limits promotion.CouponLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits[code] = limits
+
+	return nil
+}
+
+// GetLimits returns the limits of the code, the zero CouponLimits if it has
+// none.
+func (s *Store) GetLimits(code string) (promotion.CouponLimits, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limits[code], nil
+}
+
+// Redeem checks the redemption against the code's limits and records it,
+// holding the store's lock throughout.
+func (s *Store) Redeem(redemption promotion.Redemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uses, customerUses := 0, 0
+
+	for _, redeemed := range s.redemptions {
+		if redeemed.OrderID == redemption.OrderID {
+			return fmt.Errorf("%w order %s has already redeemed a coupon",
+				promotion.ErrInvalidRedemption, redemption.OrderID)
+		}
+
+		if redeemed.Code != redemption.Code || !redeemed.CancelledAt.IsZero() {
+			continue
+		}
+
+		uses++
+
+		if redemption.CustomerID != "" && redeemed.CustomerID == redemption.CustomerID {
+			customerUses++
+		}
+	}
+
+	if err := s.limits[redemption.Code].Check(redemption.RedeemedAt, redemption.CustomerID, uses, customerUses); err != nil {
+		return err
+	}
+
+	redemption.CancelledAt = time.Time{}
+	s.redemptions = append(s.redemptions, redemption)
+
+	return nil
+}
+
+// CancelRedemption marks the order's redemption cancelled.
+func (s *Store) CancelRedemption(orderID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.redemptions {
+		if s.redemptions[i].OrderID == orderID && s.redemptions[i].CancelledAt.IsZero() {
+			s.redemptions[i].CancelledAt = at
+		}
+	}
+
+	return nil
+}
+
+// ListRedemptions returns the redemptions of the code, in the order they were
+// made.
+func (s *Store) ListRedemptions(code string) ([]promotion.Redemption, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redemptions := []promotion.Redemption{}
+
+	for _, redemption := range s.redemptions {
+		if redemption.Code == code {
+			redemptions = append(redemptions, redemption)
+		}
+	}
+
+	return redemptions, nil
+}
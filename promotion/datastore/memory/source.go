@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// AddSources registers the sources, replacing any already registered with the
+// same set and path.
+func (s *Store) AddSources(sources []promotion.Source) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, source := range sources {
+		if s.sources[source.Set] == nil {
+			s.sources[source.Set] = map[string]promotion.Source{}
+		}
+
+		s.sources[source.Set][source.Path] = source
+	}
+
+	return nil
+}
+
+// ListSources returns the sources of the set, or of every set when set is "",
+// ordered by set and path.
+func (s *Store) ListSources(set string) ([]promotion.Source, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sources := []promotion.Source{}
+
+	for sourceSet, paths := range s.sources {
+		if set != "" && sourceSet != set {
+			continue
+		}
+
+		for _, source := range paths {
+			sources = append(sources, source)
+		}
+	}
+
+	slices.SortFunc(sources, func(a, b promotion.Source) int {
+		return cmp.Or(cmp.Compare(a.Set, b.Set), cmp.Compare(a.Path, b.Path))
+	})
+
+	return sources, nil
+}
+
+// RemoveSources removes the paths from the set, or the whole set when there
+// are no paths. The set's cached results are removed too, unless another set
+// has the same files.
+func (s *Store) RemoveSources(set string, paths []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(paths) == 0 {
+		delete(s.sources, set)
+	}
+
+	for _, path := range paths {
+		delete(s.sources[set], path)
+	}
+
+	if len(s.sources[set]) == 0 {
+		delete(s.sources, set)
+	}
+
+	for fileSet := range s.fileSets[set] {
+		shared := false
+
+		for otherSet, otherFileSets := range s.fileSets {
+			if otherSet != set && otherFileSets[fileSet] {
+				shared = true
+
+				break
+			}
+		}
+
+		if !shared {
+			delete(s.counts, fileSet)
+		}
+	}
+
+	delete(s.fileSets, set)
+
+	return nil
+}
+
+// LinkFileSet records that the results cached for the file set belong to the
+// source set.
+func (s *Store) LinkFileSet(set, fileSet string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fileSets[set] == nil {
+		s.fileSets[set] = map[string]bool{}
+	}
+
+	s.fileSets[set][fileSet] = true
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// expiredCondition selects the cached results expired by the time in the
+// numbered parameter.
+const expiredCondition = "ttl > 0 AND COALESCE(created, '-infinity') + make_interval(secs => ttl) <= $"
+
+// CacheStats summarises the cache, counting the entries expired at now.
+func (d *Driver) CacheStats(now time.Time) (promotion.CacheStats, error) {
+	var (
+		stats          promotion.CacheStats
+		oldest, newest sql.NullTime
+	)
+
+	err := d.db.QueryRow(`
+	SELECT COUNT(*), COUNT(DISTINCT fileset), COUNT(*) FILTER (WHERE `+expiredCondition+`1),
+		MIN(created), MAX(created)
+	FROM promocode`, now.UTC(),
+	).Scan(&stats.Entries, &stats.FileSets, &stats.Expired, &oldest, &newest)
+	if err != nil {
+		return promotion.CacheStats{}, fmt.Errorf("summarising the cache error: %w", err)
+	}
+
+	stats.Oldest = fromNullTime(oldest)
+	stats.Newest = fromNullTime(newest)
+
+	return stats, nil
+}
+
+// ListCacheEntries returns the entries selected by the filter, ordered by file
+// set and code.
+func (d *Driver) ListCacheEntries(filter promotion.CacheFilter) ([]promotion.CacheEntry, error) {
+	where, args := cacheFilterWhere(filter)
+
+	// COLLATE "C" orders by byte, as Go, and SQLite, do.
+	rows, err := d.db.Query(
+		`SELECT fileset, code, matchcount, created, ttl FROM promocode WHERE `+where+
+			` ORDER BY fileset COLLATE "C", code COLLATE "C"`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing the cache error: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []promotion.CacheEntry{}
+
+	for rows.Next() {
+		var (
+			entry   promotion.CacheEntry
+			created sql.NullTime
+			ttl     int64
+		)
+
+		if err := rows.Scan(&entry.FileSet, &entry.Code, &entry.MatchCount, &created, &ttl); err != nil {
+			return nil, fmt.Errorf("scanning row error: %w", err)
+		}
+
+		entry.CreatedAt = fromNullTime(created)
+		entry.TTL = time.Duration(ttl) * time.Second
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating rows error: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteCacheEntries removes the entries selected by the filter, and returns
+// the number removed.
+func (d *Driver) DeleteCacheEntries(filter promotion.CacheFilter) (int, error) {
+	where, args := cacheFilterWhere(filter)
+
+	result, err := d.db.Exec("DELETE FROM promocode WHERE "+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete from the cache: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the deleted entries: %w", err)
+	}
+
+	return int(deleted), nil
+}
+
+// ImportCacheEntries caches the entries, replacing those already cached for the
+// same file set and code.
+func (d *Driver) ImportCacheEntries(entries []promotion.CacheEntry) error {
+	for _, entry := range entries {
+		if err := entry.Validate(); err != nil {
+			return err
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO promocode(fileset, code, matchcount, created, ttl) VALUES($1, $2, $3, $4, $5)
+	ON CONFLICT (fileset, code) DO UPDATE SET
+		matchcount = excluded.matchcount, created = excluded.created, ttl = excluded.ttl`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		_, err := stmt.Exec(
+			entry.FileSet, entry.Code, entry.MatchCount,
+			nullTime(entry.CreatedAt.Truncate(time.Second)), promotion.CacheTTLSeconds(entry.TTL),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to import code %s: %w", entry.Code, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// cacheFilterWhere is the WHERE clause, and its arguments, of the filter.
+func cacheFilterWhere(filter promotion.CacheFilter) (string, []any) {
+	conditions := []string{"TRUE"}
+	args := []any{}
+
+	if filter.FileSet != "" {
+		args = append(args, filter.FileSet)
+		conditions = append(conditions, "fileset = $"+strconv.Itoa(len(args)))
+	}
+
+	if len(filter.Codes) > 0 {
+		args = append(args, pq.Array(filter.Codes))
+		conditions = append(conditions, "code = ANY($"+strconv.Itoa(len(args))+")")
+	}
+
+	if !filter.ExpiredAt.IsZero() {
+		args = append(args, filter.ExpiredAt.UTC())
+		conditions = append(conditions, expiredCondition+strconv.Itoa(len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
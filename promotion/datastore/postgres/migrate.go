@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// promocodeMigrations upgrade the promocode table, the migration at index i
+// takes it from version i to version i+1. The version is recorded in the
+// schemaversion table, so each migration runs once.
+var promocodeMigrations = []string{
+	// 1: Results are keyed by file set.
+	`CREATE TABLE promocode (
+		fileset TEXT NOT NULL,
+		code TEXT NOT NULL,
+		matchcount INTEGER NOT NULL,
+		PRIMARY KEY (fileset, code)
+	)`,
+	// 2: Results have a creation time, and a TTL. Results cached before are
+	// kept, with neither, so they never expire.
+	`ALTER TABLE promocode
+		ADD COLUMN created TIMESTAMPTZ,
+		ADD COLUMN ttl BIGINT NOT NULL DEFAULT 0`,
+}
+
+// migratePromoCode brings the promocode table up to the latest version. The
+// migrations are run in a single transaction, holding an advisory lock, so
+// that servers starting at the same time do not both run them.
+func migratePromoCode(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('kart promocode migration'))"); err != nil {
+		return fmt.Errorf("locking promocode table migration: %w", err)
+	}
+
+	_, err = tx.Exec(`
+	CREATE TABLE IF NOT EXISTS schemaversion (
+		name TEXT PRIMARY KEY,
+		version INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating schemaversion table: %w", err)
+	}
+
+	version, err := promocodeVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	if version > len(promocodeMigrations) {
+		return fmt.Errorf("promocode table is version %d, this version of kart only knows up to version %d",
+			version, len(promocodeMigrations))
+	}
+
+	for i, migration := range promocodeMigrations[version:] {
+		if _, err := tx.Exec(migration); err != nil {
+			return fmt.Errorf("migrating promocode table to version %d: %w", version+i+1, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO schemaversion(name, version) VALUES('promocode', $1)
+	ON CONFLICT (name) DO UPDATE SET version = excluded.version`,
+		len(promocodeMigrations),
+	)
+	if err != nil {
+		return fmt.Errorf("recording promocode table version: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// promocodeVersion returns the recorded version of the promocode table. Tables
+// made before versions were recorded are identified by their columns.
+func promocodeVersion(tx *sql.Tx) (int, error) {
+	var version int
+
+	err := tx.QueryRow("SELECT version FROM schemaversion WHERE name = 'promocode'").Scan(&version)
+	if err == nil {
+		return version, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("reading promocode table version: %w", err)
+	}
+
+	var hasTable, hasCreated bool
+
+	err = tx.QueryRow(`
+	SELECT COUNT(*) > 0, COUNT(*) FILTER (WHERE column_name = 'created') > 0
+	FROM information_schema.columns
+	WHERE table_schema = current_schema() AND table_name = 'promocode'`).Scan(&hasTable, &hasCreated)
+	if err != nil {
+		return 0, fmt.Errorf("inspecting promocode table: %w", err)
+	}
+
+	switch {
+	case hasCreated:
+		return 2, nil
+	case hasTable:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,197 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// uniqueViolation is PostgreSQL's error code for a duplicate key.
+const uniqueViolation = "23505"
+
+// SetLimits replaces the limits of the code.
+func (d *Driver) SetLimits(code string, limits promotion.CouponLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	_, err := d.db.Exec(`
+	INSERT INTO couponlimit(code, maxuses, maxusespercustomer, validfrom, validuntil) VALUES($1, $2, $3, $4, $5)
+	ON CONFLICT (code) DO UPDATE SET
+		maxuses = excluded.maxuses, maxusespercustomer = excluded.maxusespercustomer,
+		validfrom = excluded.validfrom, validuntil = excluded.validuntil`,
+		code, limits.MaxUses, limits.MaxUsesPerCustomer, nullTime(limits.ValidFrom), nullTime(limits.ValidUntil),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set limits of %s: %w", code, err)
+	}
+
+	return nil
+}
+
+// GetLimits returns the limits of the code, the zero CouponLimits if it has none.
+func (d *Driver) GetLimits(code string) (promotion.CouponLimits, error) {
+	return getLimits(d.db, code)
+}
+
+// querier is what getLimits needs, so that it can read inside, or outside, a
+// transaction.
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getLimits(db querier, code string) (promotion.CouponLimits, error) {
+	var (
+		limits                promotion.CouponLimits
+		validFrom, validUntil sql.NullTime
+	)
+
+	err := db.QueryRow(
+		"SELECT maxuses, maxusespercustomer, validfrom, validuntil FROM couponlimit WHERE code = $1", code,
+	).Scan(&limits.MaxUses, &limits.MaxUsesPerCustomer, &validFrom, &validUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return promotion.CouponLimits{}, nil
+	}
+
+	if err != nil {
+		return promotion.CouponLimits{}, fmt.Errorf("getting limits of %s error: %w", code, err)
+	}
+
+	limits.ValidFrom = fromNullTime(validFrom)
+	limits.ValidUntil = fromNullTime(validUntil)
+
+	return limits, nil
+}
+
+// Redeem checks the redemption against the code's limits and records it. A
+// transaction level advisory lock on the code makes other redemptions of the
+// code, from any server, wait rather than counting the same uses.
+func (d *Driver) Redeem(redemption promotion.Redemption) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", redemption.Code); err != nil {
+		return fmt.Errorf("locking %s error: %w", redemption.Code, err)
+	}
+
+	var existing int
+
+	err = tx.QueryRow("SELECT COUNT(*) FROM redemption WHERE orderid = $1", redemption.OrderID).Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("checking order %s error: %w", redemption.OrderID, err)
+	}
+
+	if existing > 0 {
+		return fmt.Errorf("%w order %s has already redeemed a coupon", promotion.ErrInvalidRedemption, redemption.OrderID)
+	}
+
+	limits, err := getLimits(tx, redemption.Code)
+	if err != nil {
+		return err
+	}
+
+	var uses, customerUses int
+
+	err = tx.QueryRow(`
+	SELECT COUNT(*), COUNT(*) FILTER (WHERE customerid = $1 AND customerid != '')
+	FROM redemption WHERE code = $2 AND cancelled IS NULL`,
+		redemption.CustomerID, redemption.Code,
+	).Scan(&uses, &customerUses)
+	if err != nil {
+		return fmt.Errorf("counting uses of %s error: %w", redemption.Code, err)
+	}
+
+	if err := limits.Check(redemption.RedeemedAt, redemption.CustomerID, uses, customerUses); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO redemption(orderid, code, customerid, redeemed) VALUES($1, $2, $3, $4)",
+		redemption.OrderID, redemption.Code, redemption.CustomerID, redemption.RedeemedAt.UTC(),
+	)
+
+	// The lock is on the code, so the same order can be redeeming another
+	// code at the same time.
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return fmt.Errorf("%w order %s has already redeemed a coupon", promotion.ErrInvalidRedemption, redemption.OrderID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to record redemption of %s: %w", redemption.Code, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// CancelRedemption marks the order's redemption cancelled.
+func (d *Driver) CancelRedemption(orderID string, at time.Time) error {
+	_, err := d.db.Exec(
+		"UPDATE redemption SET cancelled = $1 WHERE orderid = $2 AND cancelled IS NULL", at.UTC(), orderID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cancel redemption of order %s: %w", orderID, err)
+	}
+
+	return nil
+}
+
+// ListRedemptions returns the redemptions of the code, in the order they were
+// made.
+func (d *Driver) ListRedemptions(code string) ([]promotion.Redemption, error) {
+	rows, err := d.db.Query(
+		"SELECT orderid, customerid, redeemed, cancelled FROM redemption WHERE code = $1 ORDER BY id", code,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing redemptions error: %w", err)
+	}
+	defer rows.Close()
+
+	redemptions := []promotion.Redemption{}
+
+	for rows.Next() {
+		var cancelled sql.NullTime
+
+		redemption := promotion.Redemption{Code: code}
+
+		err := rows.Scan(&redemption.OrderID, &redemption.CustomerID, &redemption.RedeemedAt, &cancelled)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row error: %w", err)
+		}
+
+		redemption.RedeemedAt = redemption.RedeemedAt.UTC()
+		redemption.CancelledAt = fromNullTime(cancelled)
+		redemptions = append(redemptions, redemption)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating rows error: %w", err)
+	}
+
+	return redemptions, nil
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// fromNullTime reads a time stored by nullTime.
+func fromNullTime(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+
+	return t.Time.UTC()
+}
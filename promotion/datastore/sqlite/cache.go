@@ -0,0 +1,241 @@
+package sqlite
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// Cached results record their creation time, and TTL, as seconds, rather than
+// as text like the other times, so that their expiry can be worked out in a
+// query.
+
+// CacheStats summarises the cache, counting the entries expired at now.
+func (d *Driver) CacheStats(now time.Time) (promotion.CacheStats, error) {
+	db, err := d.connect()
+	if err != nil {
+		return promotion.CacheStats{}, fmt.Errorf("unable to connect when summarising the cache with error: %w", err)
+	}
+
+	var (
+		stats          promotion.CacheStats
+		oldest, newest sql.NullInt64
+	)
+
+	err = db.QueryRow(`
+	SELECT COUNT(*), COUNT(DISTINCT fileset), COUNT(CASE WHEN ttl > 0 AND created + ttl <= ? THEN 1 END),
+		MIN(NULLIF(created, 0)), MAX(NULLIF(created, 0))
+	FROM promocode`, now.Unix(),
+	).Scan(&stats.Entries, &stats.FileSets, &stats.Expired, &oldest, &newest)
+	if err != nil {
+		return promotion.CacheStats{}, fmt.Errorf("summarising the cache error: %w", err)
+	}
+
+	stats.Oldest = fromUnix(oldest.Int64)
+	stats.Newest = fromUnix(newest.Int64)
+
+	return stats, nil
+}
+
+// ListCacheEntries returns the entries selected by the filter, ordered by file
+// set and code.
+func (d *Driver) ListCacheEntries(filter promotion.CacheFilter) ([]promotion.CacheEntry, error) {
+	db, err := d.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect when listing the cache with error: %w", err)
+	}
+
+	entries := []promotion.CacheEntry{}
+
+	for where, args := range cacheFilterBatches(filter) {
+		rows, err := db.Query(
+			"SELECT fileset, code, matchcount, created, ttl FROM promocode WHERE "+where+" ORDER BY fileset, code",
+			args...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("listing the cache error: %w", err)
+		}
+
+		entries, err = appendCacheEntries(entries, rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Batches of codes are each in order, but not with each other.
+	if len(filter.Codes) > maxQueryCodes {
+		slices.SortFunc(entries, func(a, b promotion.CacheEntry) int {
+			return cmp.Or(cmp.Compare(a.FileSet, b.FileSet), cmp.Compare(a.Code, b.Code))
+		})
+	}
+
+	return entries, nil
+}
+
+func appendCacheEntries(entries []promotion.CacheEntry, rows *sql.Rows) ([]promotion.CacheEntry, error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			entry        promotion.CacheEntry
+			created, ttl int64
+		)
+
+		if err := rows.Scan(&entry.FileSet, &entry.Code, &entry.MatchCount, &created, &ttl); err != nil {
+			return nil, fmt.Errorf("scanning row error: %w", err)
+		}
+
+		entry.CreatedAt = fromUnix(created)
+		entry.TTL = time.Duration(ttl) * time.Second
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating rows error: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteCacheEntries removes the entries selected by the filter, and returns
+// the number removed.
+func (d *Driver) DeleteCacheEntries(filter promotion.CacheFilter) (int, error) {
+	db, err := d.connect()
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect when deleting from the cache with error: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	deleted := 0
+
+	for where, args := range cacheFilterBatches(filter) {
+		result, err := tx.Exec("DELETE FROM promocode WHERE "+where, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete from the cache: %w", err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to count the deleted entries: %w", err)
+		}
+
+		deleted += int(rows)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// ImportCacheEntries caches the entries, replacing those already cached for the
+// same file set and code.
+func (d *Driver) ImportCacheEntries(entries []promotion.CacheEntry) error {
+	for _, entry := range entries {
+		if err := entry.Validate(); err != nil {
+			return err
+		}
+	}
+
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect when importing the cache with error: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	stmt, err := tx.Prepare(upsertPromoCode)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		_, err := stmt.Exec(
+			entry.FileSet, entry.Code, entry.MatchCount, toUnix(entry.CreatedAt), promotion.CacheTTLSeconds(entry.TTL),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to import code %s: %w", entry.Code, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// cacheFilterBatches yields the WHERE clause, and its arguments, of each batch
+// of the filter's codes, or of the whole filter when it has no codes.
+func cacheFilterBatches(filter promotion.CacheFilter) iter.Seq2[string, []any] {
+	return func(yield func(string, []any) bool) {
+		conditions := []string{"1 = 1"}
+		args := []any{}
+
+		if filter.FileSet != "" {
+			conditions = append(conditions, "fileset = ?")
+			args = append(args, filter.FileSet)
+		}
+
+		if !filter.ExpiredAt.IsZero() {
+			conditions = append(conditions, "ttl > 0 AND created + ttl <= ?")
+			args = append(args, filter.ExpiredAt.Unix())
+		}
+
+		if len(filter.Codes) == 0 {
+			yield(strings.Join(conditions, " AND "), args)
+
+			return
+		}
+
+		for batch := range slices.Chunk(filter.Codes, maxQueryCodes) {
+			placeholders := make([]string, len(batch))
+			batchArgs := slices.Clone(args)
+
+			for i, code := range batch {
+				placeholders[i] = "?"
+				batchArgs = append(batchArgs, code)
+			}
+
+			where := strings.Join(conditions, " AND ") + " AND code IN (" + strings.Join(placeholders, ",") + ")"
+			if !yield(where, batchArgs) {
+				return
+			}
+		}
+	}
+}
+
+// toUnix stores a time as seconds, and the zero time as 0.
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+// fromUnix reads a time stored by toUnix.
+func fromUnix(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0).UTC()
+}
@@ -0,0 +1,221 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// SetLimits replaces the limits of the code.
+func (d *Driver) SetLimits(code string, limits promotion.CouponLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect when setting limits with error: %w", err)
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO couponlimit(code, maxuses, maxusespercustomer, validfrom, validuntil) VALUES(?, ?, ?, ?, ?)
+	ON CONFLICT(code) DO UPDATE SET
+		maxuses = excluded.maxuses, maxusespercustomer = excluded.maxusespercustomer,
+		validfrom = excluded.validfrom, validuntil = excluded.validuntil`,
+		code, limits.MaxUses, limits.MaxUsesPerCustomer, formatTime(limits.ValidFrom), formatTime(limits.ValidUntil),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set limits of %s: %w", code, err)
+	}
+
+	return nil
+}
+
+// GetLimits returns the limits of the code, the zero CouponLimits if it has none.
+func (d *Driver) GetLimits(code string) (promotion.CouponLimits, error) {
+	db, err := d.connect()
+	if err != nil {
+		return promotion.CouponLimits{}, fmt.Errorf("unable to connect when getting limits with error: %w", err)
+	}
+
+	return getLimits(db, code)
+}
+
+// querier is what getLimits needs, so that it can read inside, or outside, a
+// transaction.
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getLimits(db querier, code string) (promotion.CouponLimits, error) {
+	var (
+		limits                promotion.CouponLimits
+		validFrom, validUntil string
+	)
+
+	err := db.QueryRow(
+		"SELECT maxuses, maxusespercustomer, validfrom, validuntil FROM couponlimit WHERE code = ?", code,
+	).Scan(&limits.MaxUses, &limits.MaxUsesPerCustomer, &validFrom, &validUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return promotion.CouponLimits{}, nil
+	}
+
+	if err != nil {
+		return promotion.CouponLimits{}, fmt.Errorf("getting limits of %s error: %w", code, err)
+	}
+
+	if limits.ValidFrom, err = parseTime(validFrom); err != nil {
+		return promotion.CouponLimits{}, fmt.Errorf("parsing valid from of %s error: %w", code, err)
+	}
+
+	if limits.ValidUntil, err = parseTime(validUntil); err != nil {
+		return promotion.CouponLimits{}, fmt.Errorf("parsing valid until of %s error: %w", code, err)
+	}
+
+	return limits, nil
+}
+
+// Redeem checks the redemption against the code's limits and records it. The
+// connection is opened with immediate transactions, so the write lock is
+// held from the first read, and other connections (eg. another server) wait
+// rather than counting the same uses.
+func (d *Driver) Redeem(redemption promotion.Redemption) error {
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect when redeeming with error: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	var existing int
+
+	err = tx.QueryRow("SELECT COUNT(*) FROM redemption WHERE orderid = ?", redemption.OrderID).Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("checking order %s error: %w", redemption.OrderID, err)
+	}
+
+	if existing > 0 {
+		return fmt.Errorf("%w order %s has already redeemed a coupon", promotion.ErrInvalidRedemption, redemption.OrderID)
+	}
+
+	limits, err := getLimits(tx, redemption.Code)
+	if err != nil {
+		return err
+	}
+
+	var uses, customerUses int
+
+	err = tx.QueryRow(`
+	SELECT COUNT(*), COUNT(CASE WHEN customerid = ? AND customerid != '' THEN 1 END)
+	FROM redemption WHERE code = ? AND cancelled = ''`,
+		redemption.CustomerID, redemption.Code,
+	).Scan(&uses, &customerUses)
+	if err != nil {
+		return fmt.Errorf("counting uses of %s error: %w", redemption.Code, err)
+	}
+
+	if err := limits.Check(redemption.RedeemedAt, redemption.CustomerID, uses, customerUses); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO redemption(orderid, code, customerid, redeemed) VALUES(?, ?, ?, ?)",
+		redemption.OrderID, redemption.Code, redemption.CustomerID, formatTime(redemption.RedeemedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record redemption of %s: %w", redemption.Code, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// CancelRedemption marks the order's redemption cancelled.
+func (d *Driver) CancelRedemption(orderID string, at time.Time) error {
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect when cancelling redemption with error: %w", err)
+	}
+
+	_, err = db.Exec(
+		"UPDATE redemption SET cancelled = ? WHERE orderid = ? AND cancelled = ''", formatTime(at), orderID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cancel redemption of order %s: %w", orderID, err)
+	}
+
+	return nil
+}
+
+// ListRedemptions returns the redemptions of the code, in the order they were
+// made.
+func (d *Driver) ListRedemptions(code string) ([]promotion.Redemption, error) {
+	db, err := d.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect when listing redemptions with error: %w", err)
+	}
+
+	rows, err := db.Query(
+		"SELECT orderid, customerid, redeemed, cancelled FROM redemption WHERE code = ? ORDER BY rowid", code,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing redemptions error: %w", err)
+	}
+	defer rows.Close()
+
+	redemptions := []promotion.Redemption{}
+
+	for rows.Next() {
+		var redeemed, cancelled string
+
+		redemption := promotion.Redemption{Code: code}
+
+		if err := rows.Scan(&redemption.OrderID, &redemption.CustomerID, &redeemed, &cancelled); err != nil {
+			return nil, fmt.Errorf("scanning row error: %w", err)
+		}
+
+		if redemption.RedeemedAt, err = parseTime(redeemed); err != nil {
+			return nil, fmt.Errorf("parsing redeemed time of order %s error: %w", redemption.OrderID, err)
+		}
+
+		if redemption.CancelledAt, err = parseTime(cancelled); err != nil {
+			return nil, fmt.Errorf("parsing cancelled time of order %s error: %w", redemption.OrderID, err)
+		}
+
+		redemptions = append(redemptions, redemption)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating rows error: %w", err)
+	}
+
+	return redemptions, nil
+}
+
+// formatTime stores a time as RFC 3339 text, and the zero time as "".
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime reads a time stored by formatTime.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+)
+
+// AddSources registers the sources, replacing any already registered with the
+// same set and path.
+func (d *Driver) AddSources(sources []promotion.Source) error {
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect when adding sources with error: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO source(sourceset, path, description, added, checksum) VALUES(?, ?, ?, ?, ?)
+	ON CONFLICT(sourceset, path) DO UPDATE SET
+		description = excluded.description, added = excluded.added, checksum = excluded.checksum`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, source := range sources {
+		_, err := stmt.Exec(
+			source.Set, source.Path, source.Description, source.Added.UTC().Format(time.RFC3339), source.Checksum,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add source %s: %w", source.Path, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ListSources returns the sources of the set, or of every set when set is "",
+// ordered by set and path.
+func (d *Driver) ListSources(set string) ([]promotion.Source, error) {
+	db, err := d.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect when listing sources with error: %w", err)
+	}
+
+	rows, err := db.Query(`
+	SELECT sourceset, path, description, added, checksum FROM source
+	WHERE ? = '' OR sourceset = ?
+	ORDER BY sourceset, path`, set, set)
+	if err != nil {
+		return nil, fmt.Errorf("listing sources error: %w", err)
+	}
+	defer rows.Close()
+
+	sources := []promotion.Source{}
+
+	for rows.Next() {
+		var (
+			source promotion.Source
+			added  string
+		)
+
+		if err := rows.Scan(&source.Set, &source.Path, &source.Description, &added, &source.Checksum); err != nil {
+			return nil, fmt.Errorf("scanning row error: %w", err)
+		}
+
+		source.Added, err = time.Parse(time.RFC3339, added)
+		if err != nil {
+			return nil, fmt.Errorf("source %s added date %q: %w", source.Path, added, err)
+		}
+
+		sources = append(sources, source)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating rows error: %w", err)
+	}
+
+	return sources, nil
+}
+
+// RemoveSources removes the paths from the set, or the whole set when there
+// are no paths. The set's cached results are removed too, unless another set
+// has the same files. When only some of the files are removed, the cached
+// results are for files that are no longer the files of the set.
+func (d *Driver) RemoveSources(set string, paths []string) error {
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect when removing sources with error: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	if len(paths) == 0 {
+		if _, err := tx.Exec("DELETE FROM source WHERE sourceset = ?", set); err != nil {
+			return fmt.Errorf("failed to remove source set %s: %w", set, err)
+		}
+	} else {
+		placeholders := make([]string, len(paths))
+		args := make([]any, 0, len(paths)+1)
+		args = append(args, set)
+
+		for i, path := range paths {
+			placeholders[i] = "?"
+			args = append(args, path)
+		}
+
+		query := fmt.Sprintf(
+			"DELETE FROM source WHERE sourceset = ? AND path IN (%s)", strings.Join(placeholders, ","),
+		)
+
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to remove sources from %s: %w", set, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+	DELETE FROM promocode WHERE fileset IN (
+		SELECT fileset FROM sourcefileset WHERE sourceset = ?
+		EXCEPT
+		SELECT fileset FROM sourcefileset WHERE sourceset != ?
+	)`, set, set)
+	if err != nil {
+		return fmt.Errorf("failed to remove cached results of %s: %w", set, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM sourcefileset WHERE sourceset = ?", set); err != nil {
+		return fmt.Errorf("failed to unlink cached results of %s: %w", set, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// LinkFileSet records that the results cached for the file set belong to the
+// source set.
+func (d *Driver) LinkFileSet(set, fileSet string) error {
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect when linking file set with error: %w", err)
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO sourcefileset(sourceset, fileset) VALUES(?, ?) ON CONFLICT(sourceset, fileset) DO NOTHING",
+		set, fileSet,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to link file set to %s: %w", set, err)
+	}
+
+	return nil
+}
@@ -3,19 +3,67 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/shanehowearth/kart/promotion"
 )
 
-type Driver struct{}
+// DefaultPath is the database file used when no path is given, in the working
+// directory.
+const DefaultPath = "promotion_data.db"
 
-var _ promotion.Store = (*Driver)(nil)
+// Driver is the SQLite implementation of the promotion.Store interface.
+// The connection is opened on first use, and held until Close is called.
+// The zero Driver uses the DefaultPath.
+type Driver struct {
+	// path is the database file, "" is the DefaultPath, optionally followed
+	// by the driver's options, eg. "promotion_data.db?_busy_timeout=5000".
+	path string
+	// mu guards db, which is lazily opened.
+	mu sync.Mutex
+	db *sql.DB
+}
+
+// New creates a Driver for the database file at path, "" is the DefaultPath.
+// The path may end with the driver's options, after a "?". The file, and its
+// tables, are created by InitialiseDataStore.
+func New(path string) *Driver {
+	return &Driver{path: path}
+}
+
+var (
+	_ promotion.Store           = (*Driver)(nil)
+	_ promotion.CacheStore      = (*Driver)(nil)
+	_ promotion.SourceStore     = (*Driver)(nil)
+	_ promotion.RedemptionStore = (*Driver)(nil)
+)
 
-func (*Driver) connect() (*sql.DB, error) {
-	dbName := "promotion_data.db"
+// connect returns the shared connection, opening it if this is the first use.
+func (d *Driver) connect() (*sql.DB, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.db != nil {
+		return d.db, nil
+	}
+
+	// Transactions take the write lock when they begin, so that a redemption
+	// checks, and records, its use of a code without another connection
+	// taking the same use in between.
+	path := d.path
+	if path == "" {
+		path = DefaultPath
+	}
+
+	dbName, err := dataSourceName(path)
+	if err != nil {
+		return nil, err
+	}
 
 	db, err := sql.Open("sqlite3", dbName)
 	if err != nil {
@@ -33,55 +81,143 @@ func (*Driver) connect() (*sql.DB, error) {
 		return nil, fmt.Errorf("error connecting/pinging database: %w", err)
 	}
 
+	d.db = db
+
 	return db, nil
 }
 
-// InitialiseDataStore creates the table needed for the queries to work.
+// dataSourceName adds the options the Driver needs to those already on the
+// path, replacing any that conflict.
+func dataSourceName(path string) (string, error) {
+	file, query, _ := strings.Cut(path, "?")
+
+	options, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("error parsing database options: %w", err)
+	}
+
+	options.Set("_txlock", "immediate")
+
+	return file + "?" + options.Encode(), nil
+}
+
+// Close flushes and closes the connection to the database. The Driver can be
+// used again afterwards, in which case a new connection is opened.
+func (d *Driver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.db == nil {
+		return nil
+	}
+
+	err := d.db.Close()
+	d.db = nil
+
+	if err != nil {
+		return fmt.Errorf("error closing database: %w", err)
+	}
+
+	return nil
+}
+
+// InitialiseDataStore creates the tables needed for the queries to work, and
+// migrates the promocode table of an older cache.
 func (d *Driver) InitialiseDataStore() error {
 	db, err := d.connect()
 	if err != nil {
 		return fmt.Errorf("unable to connect when initialising datastore with error: %w", err)
 	}
-	defer db.Close()
+
+	if err := migratePromoCode(db); err != nil {
+		return err
+	}
 
 	query := `
-	CREATE TABLE IF NOT EXISTS promocode (
-		code TEXT PRIMARY KEY NOT NULL,
-		matchcount INTEGER NOT NULL
-	);`
-	_, err = db.Exec(query)
+	CREATE TABLE IF NOT EXISTS source (
+		sourceset TEXT NOT NULL,
+		path TEXT NOT NULL,
+		description TEXT NOT NULL,
+		added TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		PRIMARY KEY (sourceset, path)
+	);
+	CREATE TABLE IF NOT EXISTS sourcefileset (
+		sourceset TEXT NOT NULL,
+		fileset TEXT NOT NULL,
+		PRIMARY KEY (sourceset, fileset)
+	);
+	CREATE TABLE IF NOT EXISTS couponlimit (
+		code TEXT PRIMARY KEY,
+		maxuses INTEGER NOT NULL,
+		maxusespercustomer INTEGER NOT NULL,
+		validfrom TEXT NOT NULL,
+		validuntil TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS redemption (
+		orderid TEXT PRIMARY KEY,
+		code TEXT NOT NULL,
+		customerid TEXT NOT NULL,
+		redeemed TEXT NOT NULL,
+		cancelled TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS redemptioncode ON redemption (code, cancelled);`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
 
-	return err
+	return nil
 }
 
-// GetCodeFileMatchCounts returns the number of files that the code was found in.
-func (d *Driver) GetCodeFileMatchCounts(codes []string) (map[string]promotion.CacheResult, error) {
+// maxQueryCodes is the most codes looked up by a single query. SQLite limits
+// the number of parameters in a statement (to 999 before version 3.32), so
+// larger lookups are made in batches.
+const maxQueryCodes = 500
+
+// GetCodeFileMatchCounts returns the number of files, in the file set, that
+// the code was found in.
+func (d *Driver) GetCodeFileMatchCounts(fileSet string, codes []string) (map[string]promotion.CacheResult, error) {
 	db, err := d.connect()
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect when getting code validity with error: %w", err)
 	}
-	defer db.Close()
 
-	results := make(map[string]promotion.CacheResult)
+	results := make(map[string]promotion.CacheResult, len(codes))
 
+	for _, code := range codes {
+		results[code] = promotion.CacheResult{Found: false}
+	}
+
+	for batch := range slices.Chunk(codes, maxQueryCodes) {
+		if err := getCodeFileMatchCounts(db, fileSet, batch, results); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// getCodeFileMatchCounts adds the cached counts of a batch of codes to the
+// results.
+func getCodeFileMatchCounts(db *sql.DB, fileSet string, codes []string, results map[string]promotion.CacheResult) error {
 	// Ensure the query has the right number of placeholders.
 	placeholders := make([]string, len(codes))
-	args := make([]any, len(codes))
+	args := make([]any, 0, len(codes)+1)
+	args = append(args, fileSet)
 
 	for i, code := range codes {
 		placeholders[i] = "?"
-		args[i] = code
-		results[code] = promotion.CacheResult{Found: false}
+		args = append(args, code)
 	}
 
 	query := fmt.Sprintf(
-		"SELECT code, matchcount FROM promocode WHERE code IN (%s)",
+		"SELECT code, matchcount, created, ttl FROM promocode WHERE fileset = ? AND code IN (%s)",
 		strings.Join(placeholders, ","),
 	)
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("batch %v query error: %w", codes, err)
+		return fmt.Errorf("batch of %d codes query error: %w", len(codes), err)
 	}
 	defer rows.Close()
 
@@ -89,29 +225,39 @@ func (d *Driver) GetCodeFileMatchCounts(codes []string) (map[string]promotion.Ca
 	for rows.Next() {
 		var code string
 		var matchCount int
-		if err := rows.Scan(&code, &matchCount); err != nil {
-			return nil, fmt.Errorf("scanning row error: %w", err)
+		var created, ttl int64
+		if err := rows.Scan(&code, &matchCount, &created, &ttl); err != nil {
+			return fmt.Errorf("scanning row error: %w", err)
 		}
 		results[code] = promotion.CacheResult{
 			MatchCount: matchCount,
 			Found:      true,
+			CreatedAt:  fromUnix(created),
+			TTL:        time.Duration(ttl) * time.Second,
 		}
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterating rows error: %w", err)
+		return fmt.Errorf("iterating rows error: %w", err)
 	}
 
-	return results, nil
+	return nil
 }
 
-// AddCodeFileMatchCounts caches the file match counts for the given codes.
-func (d *Driver) AddCodeFileMatchCounts(codes map[string]int) error {
+// upsertPromoCode caches a result, replacing any already cached for the file
+// set and code.
+const upsertPromoCode = `
+	INSERT INTO promocode(fileset, code, matchcount, created, ttl) VALUES(?, ?, ?, ?, ?)
+	ON CONFLICT(fileset, code) DO UPDATE SET
+		matchcount = excluded.matchcount, created = excluded.created, ttl = excluded.ttl`
+
+// AddCodeFileMatchCounts caches the file match counts for the given codes,
+// replacing those already cached.
+func (d *Driver) AddCodeFileMatchCounts(fileSet string, codes map[string]int, created time.Time, ttl time.Duration) error {
 	db, err := d.connect()
 	if err != nil {
 		return fmt.Errorf("unable to add code validity with error: %w", err)
 	}
-	defer db.Close()
 
 	// Start transaction for batch insert.
 	tx, err := db.Begin()
@@ -121,7 +267,7 @@ func (d *Driver) AddCodeFileMatchCounts(codes map[string]int) error {
 	defer tx.Rollback() // Rollback if not committed.
 
 	// Prepare statement once, reuse for all inserts.
-	stmt, err := tx.Prepare("INSERT INTO promocode(code, matchcount) VALUES(?, ?) ON CONFLICT(code) DO NOTHING")
+	stmt, err := tx.Prepare(upsertPromoCode)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
@@ -129,7 +275,7 @@ func (d *Driver) AddCodeFileMatchCounts(codes map[string]int) error {
 
 	// Insert all codes.
 	for code, matchCount := range codes {
-		_, err := stmt.Exec(code, matchCount)
+		_, err := stmt.Exec(fileSet, code, matchCount, toUnix(created), promotion.CacheTTLSeconds(ttl))
 		if err != nil {
 			return fmt.Errorf("failed to insert code %s: %w", code, err)
 		}
@@ -142,3 +288,36 @@ func (d *Driver) AddCodeFileMatchCounts(codes map[string]int) error {
 
 	return nil
 }
+
+// DeleteCodeFileMatchCounts removes the cached counts of the codes for the
+// file set.
+func (d *Driver) DeleteCodeFileMatchCounts(fileSet string, codes []string) error {
+	db, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("unable to delete code validity with error: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed.
+
+	stmt, err := tx.Prepare("DELETE FROM promocode WHERE fileset = ? AND code = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, code := range codes {
+		if _, err := stmt.Exec(fileSet, code); err != nil {
+			return fmt.Errorf("failed to delete code %s: %w", code, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore"
+	"github.com/shanehowearth/kart/promotion/datastore/sqlite"
+	"github.com/shanehowearth/kart/promotion/datastore/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) datastore.Store {
+		t.Helper()
+
+		driver := sqlite.New(filepath.Join(t.TempDir(), "promotion_data.db"))
+		require.NoError(t, driver.InitialiseDataStore())
+
+		return driver
+	})
+}
+
+func TestDriverPathWithOptions(t *testing.T) {
+	testcases := map[string]struct {
+		options string
+	}{
+		"No options": {},
+		"An option": {
+			options: "?_busy_timeout=5000",
+		},
+		"Several options": {
+			options: "?_busy_timeout=5000&_foreign_keys=on",
+		},
+		"A conflicting transaction lock": {
+			options: "?_txlock=deferred",
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "promotion_data.db")
+
+			driver := sqlite.New(path + tc.options)
+			require.NoError(t, driver.InitialiseDataStore())
+
+			t.Cleanup(func() { assert.NoError(t, driver.Close()) })
+
+			require.NoError(t, driver.AddCodeFileMatchCounts("files", map[string]int{"FIFTYOFF": 2}, time.Now(), 0))
+
+			// The options are not part of the file's name.
+			assert.FileExists(t, path)
+		})
+	}
+}
+
+func TestInitialiseDataStoreMigrations(t *testing.T) {
+	testcases := map[string]struct {
+		schema          string
+		expectedEntries []promotion.CacheEntry
+		expectedError   bool
+	}{
+		"New cache": {
+			expectedEntries: []promotion.CacheEntry{},
+		},
+		"Results from before file sets are discarded": {
+			schema: `
+			CREATE TABLE promocode (code TEXT PRIMARY KEY, matchcount INTEGER NOT NULL);
+			INSERT INTO promocode VALUES ('FIFTYOFF', 2);`,
+			expectedEntries: []promotion.CacheEntry{},
+		},
+		"Results from before creation times are kept": {
+			schema: `
+			CREATE TABLE promocode (fileset TEXT NOT NULL, code TEXT NOT NULL, matchcount INTEGER NOT NULL, PRIMARY KEY (fileset, code));
+			INSERT INTO promocode VALUES ('files', 'FIFTYOFF', 2);`,
+			expectedEntries: []promotion.CacheEntry{{FileSet: "files", Code: "FIFTYOFF", MatchCount: 2}},
+		},
+		"Current results from before versions are kept": {
+			schema: `
+			CREATE TABLE promocode (
+				fileset TEXT NOT NULL, code TEXT NOT NULL, matchcount INTEGER NOT NULL,
+				created INTEGER NOT NULL DEFAULT 0, ttl INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (fileset, code)
+			);
+			INSERT INTO promocode VALUES ('files', 'FIFTYOFF', 2, 1772366400, 3600);`,
+			expectedEntries: []promotion.CacheEntry{{
+				FileSet: "files", Code: "FIFTYOFF", MatchCount: 2,
+				CreatedAt: time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC), TTL: time.Hour,
+			}},
+		},
+		"A newer version is rejected": {
+			schema: `
+			CREATE TABLE schemaversion (name TEXT PRIMARY KEY, version INTEGER NOT NULL);
+			INSERT INTO schemaversion VALUES ('promocode', 99);`,
+			expectedError: true,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "promotion_data.db")
+
+			db, err := sql.Open("sqlite3", path)
+			require.NoError(t, err)
+
+			t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+			_, err = db.Exec(tc.schema)
+			require.NoError(t, err)
+
+			driver := sqlite.New(path)
+			t.Cleanup(func() { assert.NoError(t, driver.Close()) })
+
+			err = driver.InitialiseDataStore()
+			if tc.expectedError {
+				require.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			// Initialising again leaves the migrated table as it is.
+			require.NoError(t, driver.InitialiseDataStore())
+
+			var version int
+			require.NoError(t, db.QueryRow("SELECT version FROM schemaversion WHERE name = 'promocode'").Scan(&version))
+			assert.Equal(t, 2, version)
+
+			entries, err := driver.ListCacheEntries(promotion.CacheFilter{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedEntries, entries)
+		})
+	}
+}
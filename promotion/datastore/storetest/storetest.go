@@ -0,0 +1,549 @@
+// Package storetest is the conformance suite for promotion stores. Each
+// backend's tests call Run, so every backend behaves the same way.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/shanehowearth/kart/promotion/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs the conformance suite. newStore must return an empty, initialised,
+// store, which Run closes when each test is done.
+func Run(t *testing.T, newStore func(t *testing.T) datastore.Store) {
+	t.Helper()
+
+	open := func(t *testing.T) datastore.Store {
+		t.Helper()
+
+		store := newStore(t)
+		t.Cleanup(func() { assert.NoError(t, store.Close()) })
+
+		return store
+	}
+
+	t.Run("Cache", func(t *testing.T) { testCache(t, open) })
+	t.Run("Cache maintenance", func(t *testing.T) { testCacheMaintenance(t, open) })
+	t.Run("Sources", func(t *testing.T) { testSources(t, open) })
+	t.Run("Redemptions", func(t *testing.T) { testRedemptions(t, open) })
+}
+
+// march is when the tests happen. Times are whole seconds in UTC, the
+// precision every backend keeps.
+var march = time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func testCache(t *testing.T, open func(t *testing.T) datastore.Store) {
+	// cached is the result of a count cached at march, with no TTL.
+	cached := func(count int) promotion.CacheResult {
+		return promotion.CacheResult{MatchCount: count, Found: true, CreatedAt: march}
+	}
+
+	t.Run("Initialise twice", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.InitialiseDataStore())
+	})
+
+	t.Run("Uncached codes", func(t *testing.T) {
+		store := open(t)
+
+		results, err := store.GetCodeFileMatchCounts("files", []string{"HAPPYHRS"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]promotion.CacheResult{"HAPPYHRS": {}}, results)
+	})
+
+	t.Run("Cached codes", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"HAPPYHRS": 2, "FIFTYOFF": 0}, march, 0))
+
+		results, err := store.GetCodeFileMatchCounts("files", []string{"HAPPYHRS", "FIFTYOFF", "SUPER100"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]promotion.CacheResult{
+			"HAPPYHRS": cached(2),
+			"FIFTYOFF": cached(0),
+			"SUPER100": {},
+		}, results)
+	})
+
+	t.Run("Cached codes are replaced", func(t *testing.T) {
+		store := open(t)
+
+		april := march.AddDate(0, 1, 0)
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"HAPPYHRS": 2, "FIFTYOFF": 1}, march, 0))
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"HAPPYHRS": 0, "FIFTYOFF": 3}, april, time.Hour))
+
+		results, err := store.GetCodeFileMatchCounts("files", []string{"HAPPYHRS", "FIFTYOFF"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]promotion.CacheResult{
+			"HAPPYHRS": {MatchCount: 0, Found: true, CreatedAt: april, TTL: time.Hour},
+			"FIFTYOFF": {MatchCount: 3, Found: true, CreatedAt: april, TTL: time.Hour},
+		}, results)
+	})
+
+	t.Run("File sets are separate", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"HAPPYHRS": 2}, march, 0))
+
+		results, err := store.GetCodeFileMatchCounts("other files", []string{"HAPPYHRS"})
+		require.NoError(t, err)
+		assert.Equal(t, promotion.CacheResult{}, results["HAPPYHRS"])
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"HAPPYHRS": 2, "FIFTYOFF": 3}, march, 0))
+		require.NoError(t, store.AddCodeFileMatchCounts("other files", map[string]int{"HAPPYHRS": 1}, march, 0))
+		require.NoError(t, store.DeleteCodeFileMatchCounts("files", []string{"HAPPYHRS", "SUPER100"}))
+
+		results, err := store.GetCodeFileMatchCounts("files", []string{"HAPPYHRS", "FIFTYOFF"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]promotion.CacheResult{
+			"HAPPYHRS": {},
+			"FIFTYOFF": cached(3),
+		}, results)
+
+		results, err = store.GetCodeFileMatchCounts("other files", []string{"HAPPYHRS"})
+		require.NoError(t, err)
+		assert.Equal(t, cached(1), results["HAPPYHRS"])
+	})
+
+	t.Run("Many codes", func(t *testing.T) {
+		store := open(t)
+
+		// More codes than a single query can look up in some backends.
+		counts := map[string]int{}
+		codes := []string{}
+
+		for i := range 1200 {
+			code := fmt.Sprintf("CODE%04d", i)
+			counts[code] = i % 3
+			codes = append(codes, code)
+		}
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", counts, march, 0))
+
+		results, err := store.GetCodeFileMatchCounts("files", codes)
+		require.NoError(t, err)
+		require.Len(t, results, len(codes))
+
+		for code, count := range counts {
+			assert.Equal(t, cached(count), results[code], code)
+		}
+	})
+}
+
+func testCacheMaintenance(t *testing.T, open func(t *testing.T) datastore.Store) {
+	day := 24 * time.Hour
+
+	// The entries are cached an hour apart, the first two for a day.
+	entries := []promotion.CacheEntry{
+		{FileSet: "files", Code: "FIFTYOFF", MatchCount: 2, CreatedAt: march, TTL: day},
+		{FileSet: "files", Code: "HAPPYHRS", MatchCount: 0, CreatedAt: march.Add(time.Hour), TTL: day},
+		{FileSet: "other files", Code: "FIFTYOFF", MatchCount: 1, CreatedAt: march.Add(2 * time.Hour)},
+	}
+
+	openWithEntries := func(t *testing.T) datastore.Store {
+		t.Helper()
+
+		store := open(t)
+		require.NoError(t, store.ImportCacheEntries(entries))
+
+		return store
+	}
+
+	t.Run("Cached with a TTL", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"FIFTYOFF": 2}, march, day))
+
+		results, err := store.GetCodeFileMatchCounts("files", []string{"FIFTYOFF"})
+		require.NoError(t, err)
+		assert.Equal(t, promotion.CacheResult{MatchCount: 2, Found: true, CreatedAt: march, TTL: day}, results["FIFTYOFF"])
+		assert.False(t, results["FIFTYOFF"].Expired(march.Add(day-time.Second)))
+		assert.True(t, results["FIFTYOFF"].Expired(march.Add(day)))
+	})
+
+	t.Run("TTLs under a second are rounded up", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"FIFTYOFF": 2}, march, time.Millisecond))
+		require.NoError(t, store.ImportCacheEntries([]promotion.CacheEntry{
+			{FileSet: "files", Code: "HAPPYHRS", MatchCount: 1, CreatedAt: march, TTL: 1500 * time.Millisecond},
+		}))
+
+		results, err := store.GetCodeFileMatchCounts("files", []string{"FIFTYOFF", "HAPPYHRS"})
+		require.NoError(t, err)
+		assert.Equal(t, time.Second, results["FIFTYOFF"].TTL, "a short TTL is not kept forever")
+		assert.Equal(t, 2*time.Second, results["HAPPYHRS"].TTL)
+		assert.True(t, results["FIFTYOFF"].Expired(march.Add(time.Second)))
+	})
+
+	t.Run("Stats of an empty cache", func(t *testing.T) {
+		store := open(t)
+
+		stats, err := store.CacheStats(march)
+		require.NoError(t, err)
+		assert.Equal(t, promotion.CacheStats{}, stats)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		store := openWithEntries(t)
+
+		stats, err := store.CacheStats(march.Add(day + 30*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, promotion.CacheStats{
+			Entries: 3, FileSets: 2, Expired: 1, Oldest: march, Newest: march.Add(2 * time.Hour),
+		}, stats)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := openWithEntries(t)
+
+		testcases := map[string]struct {
+			filter   promotion.CacheFilter
+			expected []promotion.CacheEntry
+		}{
+			"Everything": {
+				expected: entries,
+			},
+			"File set": {
+				filter:   promotion.CacheFilter{FileSet: "files"},
+				expected: entries[:2],
+			},
+			"Codes": {
+				filter:   promotion.CacheFilter{Codes: []string{"FIFTYOFF", "SUPER100"}},
+				expected: []promotion.CacheEntry{entries[0], entries[2]},
+			},
+			"Expired": {
+				filter:   promotion.CacheFilter{ExpiredAt: march.Add(day + time.Hour)},
+				expected: entries[:2],
+			},
+			"Everything at once": {
+				filter: promotion.CacheFilter{
+					FileSet: "files", Codes: []string{"HAPPYHRS"}, ExpiredAt: march.Add(day + time.Hour),
+				},
+				expected: entries[1:2],
+			},
+			"Nothing": {
+				filter:   promotion.CacheFilter{FileSet: "unknown files"},
+				expected: []promotion.CacheEntry{},
+			},
+		}
+		for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+			t.Run(name, func(t *testing.T) {
+				actual, err := store.ListCacheEntries(tc.filter)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			})
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := openWithEntries(t)
+
+		deleted, err := store.DeleteCacheEntries(promotion.CacheFilter{FileSet: "files", Codes: []string{"FIFTYOFF"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		remaining, err := store.ListCacheEntries(promotion.CacheFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, entries[1:], remaining)
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		store := openWithEntries(t)
+
+		deleted, err := store.DeleteCacheEntries(promotion.CacheFilter{ExpiredAt: march.Add(day)})
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		results, err := store.GetCodeFileMatchCounts("files", []string{"FIFTYOFF", "HAPPYHRS"})
+		require.NoError(t, err)
+		assert.False(t, results["FIFTYOFF"].Found)
+		assert.True(t, results["HAPPYHRS"].Found)
+	})
+
+	t.Run("Import replaces", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddCodeFileMatchCounts("files", map[string]int{"FIFTYOFF": 5}, march, 0))
+		require.NoError(t, store.ImportCacheEntries(entries))
+
+		actual, err := store.ListCacheEntries(promotion.CacheFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, entries, actual)
+	})
+
+	t.Run("Import without a creation time", func(t *testing.T) {
+		store := open(t)
+
+		entry := promotion.CacheEntry{FileSet: "files", Code: "FIFTYOFF", MatchCount: 2}
+		require.NoError(t, store.ImportCacheEntries([]promotion.CacheEntry{entry}))
+
+		actual, err := store.ListCacheEntries(promotion.CacheFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, []promotion.CacheEntry{entry}, actual)
+
+		stats, err := store.CacheStats(march)
+		require.NoError(t, err)
+		assert.Equal(t, promotion.CacheStats{Entries: 1, FileSets: 1}, stats)
+	})
+
+	t.Run("Import rejects invalid entries", func(t *testing.T) {
+		store := open(t)
+
+		err := store.ImportCacheEntries([]promotion.CacheEntry{
+			entries[0], {FileSet: "files", Code: "NEGATIVE", MatchCount: -1},
+		})
+		assert.ErrorIs(t, err, promotion.ErrInvalidCacheEntry)
+
+		// Nothing is imported.
+		actual, err := store.ListCacheEntries(promotion.CacheFilter{})
+		require.NoError(t, err)
+		assert.Empty(t, actual)
+	})
+}
+
+func testSources(t *testing.T, open func(t *testing.T) datastore.Store) {
+	source := func(set, path string) promotion.Source {
+		return promotion.Source{Set: set, Path: path, Description: "coupons", Added: march, Checksum: "checksum " + path}
+	}
+
+	t.Run("List", func(t *testing.T) {
+		store := open(t)
+
+		sources, err := store.ListSources("")
+		require.NoError(t, err)
+		assert.Empty(t, sources)
+
+		require.NoError(t, store.AddSources([]promotion.Source{
+			source("summer", "/coupons/b.txt"),
+			source("autumn", "/coupons/c.txt"),
+			source("summer", "/coupons/a.txt"),
+		}))
+
+		sources, err = store.ListSources("")
+		require.NoError(t, err)
+		assert.Equal(t, []promotion.Source{
+			source("autumn", "/coupons/c.txt"),
+			source("summer", "/coupons/a.txt"),
+			source("summer", "/coupons/b.txt"),
+		}, sources)
+
+		sources, err = store.ListSources("summer")
+		require.NoError(t, err)
+		assert.Equal(t, []promotion.Source{
+			source("summer", "/coupons/a.txt"),
+			source("summer", "/coupons/b.txt"),
+		}, sources)
+	})
+
+	t.Run("Add again replaces", func(t *testing.T) {
+		store := open(t)
+
+		replacement := source("summer", "/coupons/a.txt")
+		replacement.Description = "new coupons"
+		replacement.Added = march.Add(time.Hour)
+		replacement.Checksum = "new checksum"
+
+		require.NoError(t, store.AddSources([]promotion.Source{source("summer", "/coupons/a.txt")}))
+		require.NoError(t, store.AddSources([]promotion.Source{replacement}))
+
+		sources, err := store.ListSources("summer")
+		require.NoError(t, err)
+		assert.Equal(t, []promotion.Source{replacement}, sources)
+	})
+
+	t.Run("Remove some paths", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddSources([]promotion.Source{
+			source("summer", "/coupons/a.txt"),
+			source("summer", "/coupons/b.txt"),
+		}))
+		require.NoError(t, store.AddCodeFileMatchCounts("summer files", map[string]int{"HAPPYHRS": 2}, march, 0))
+		require.NoError(t, store.LinkFileSet("summer", "summer files"))
+
+		require.NoError(t, store.RemoveSources("summer", []string{"/coupons/a.txt"}))
+
+		sources, err := store.ListSources("summer")
+		require.NoError(t, err)
+		assert.Equal(t, []promotion.Source{source("summer", "/coupons/b.txt")}, sources)
+
+		// The cached results were for both files.
+		results, err := store.GetCodeFileMatchCounts("summer files", []string{"HAPPYHRS"})
+		require.NoError(t, err)
+		assert.False(t, results["HAPPYHRS"].Found)
+	})
+
+	t.Run("Remove a set", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.AddSources([]promotion.Source{
+			source("summer", "/coupons/a.txt"),
+			source("autumn", "/coupons/a.txt"),
+			source("winter", "/coupons/w.txt"),
+		}))
+		require.NoError(t, store.AddCodeFileMatchCounts("shared files", map[string]int{"HAPPYHRS": 1}, march, 0))
+		require.NoError(t, store.AddCodeFileMatchCounts("winter files", map[string]int{"HAPPYHRS": 2}, march, 0))
+		require.NoError(t, store.LinkFileSet("summer", "shared files"))
+		require.NoError(t, store.LinkFileSet("summer", "shared files"))
+		require.NoError(t, store.LinkFileSet("autumn", "shared files"))
+		require.NoError(t, store.LinkFileSet("winter", "winter files"))
+
+		require.NoError(t, store.RemoveSources("summer", nil))
+
+		sources, err := store.ListSources("")
+		require.NoError(t, err)
+		assert.Equal(t, []promotion.Source{
+			source("autumn", "/coupons/a.txt"),
+			source("winter", "/coupons/w.txt"),
+		}, sources)
+
+		// Autumn still has the shared files.
+		results, err := store.GetCodeFileMatchCounts("shared files", []string{"HAPPYHRS"})
+		require.NoError(t, err)
+		assert.True(t, results["HAPPYHRS"].Found)
+
+		require.NoError(t, store.RemoveSources("autumn", nil))
+
+		results, err = store.GetCodeFileMatchCounts("shared files", []string{"HAPPYHRS"})
+		require.NoError(t, err)
+		assert.False(t, results["HAPPYHRS"].Found)
+
+		results, err = store.GetCodeFileMatchCounts("winter files", []string{"HAPPYHRS"})
+		require.NoError(t, err)
+		assert.True(t, results["HAPPYHRS"].Found)
+	})
+}
+
+func testRedemptions(t *testing.T, open func(t *testing.T) datastore.Store) {
+	t.Run("Limits", func(t *testing.T) {
+		store := open(t)
+
+		limits, err := store.GetLimits("FIFTYOFF")
+		require.NoError(t, err)
+		assert.Equal(t, promotion.CouponLimits{}, limits)
+
+		limits = promotion.CouponLimits{
+			MaxUses: 100, MaxUsesPerCustomer: 1, ValidFrom: march, ValidUntil: march.AddDate(0, 1, 0),
+		}
+		require.NoError(t, store.SetLimits("FIFTYOFF", limits))
+
+		actual, err := store.GetLimits("FIFTYOFF")
+		require.NoError(t, err)
+		assert.True(t, actual.ValidFrom.Equal(limits.ValidFrom), "valid from %v", actual.ValidFrom)
+		assert.True(t, actual.ValidUntil.Equal(limits.ValidUntil), "valid until %v", actual.ValidUntil)
+		assert.Equal(t, limits.MaxUses, actual.MaxUses)
+		assert.Equal(t, limits.MaxUsesPerCustomer, actual.MaxUsesPerCustomer)
+
+		// Setting the limits replaces them all.
+		require.NoError(t, store.SetLimits("FIFTYOFF", promotion.CouponLimits{MaxUses: 5}))
+
+		actual, err = store.GetLimits("FIFTYOFF")
+		require.NoError(t, err)
+		assert.Equal(t, promotion.CouponLimits{MaxUses: 5}, actual)
+
+		err = store.SetLimits("FIFTYOFF", promotion.CouponLimits{MaxUses: -1})
+		assert.ErrorIs(t, err, promotion.ErrInvalidCouponLimits)
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		store := open(t)
+
+		require.NoError(t, store.SetLimits("FIFTYOFF", promotion.CouponLimits{MaxUses: 2, MaxUsesPerCustomer: 1}))
+
+		redeem := func(orderID, customerID string) error {
+			return store.Redeem(promotion.Redemption{
+				Code: "FIFTYOFF", OrderID: orderID, CustomerID: customerID, RedeemedAt: march,
+			})
+		}
+
+		require.NoError(t, redeem("order-1", "customer-1"))
+		assert.ErrorIs(t, redeem("order-2", "customer-1"), promotion.ErrCustomerLimit)
+		assert.ErrorIs(t, redeem("order-2", ""), promotion.ErrCustomerRequired)
+		assert.ErrorIs(t, redeem("order-1", "customer-2"), promotion.ErrInvalidRedemption)
+		require.NoError(t, redeem("order-2", "customer-2"))
+		assert.ErrorIs(t, redeem("order-3", "customer-3"), promotion.ErrCouponUsedUp)
+
+		// Cancelling returns the use, and cancelling again changes nothing.
+		require.NoError(t, store.CancelRedemption("order-1", march.Add(time.Hour)))
+		require.NoError(t, store.CancelRedemption("order-1", march.Add(2*time.Hour)))
+		require.NoError(t, store.CancelRedemption("order-unknown", march))
+		require.NoError(t, redeem("order-3", "customer-1"))
+
+		// Codes without limits can be used by anyone.
+		require.NoError(t, store.Redeem(promotion.Redemption{Code: "SUPER100", OrderID: "order-4", RedeemedAt: march}))
+
+		redemptions, err := store.ListRedemptions("FIFTYOFF")
+		require.NoError(t, err)
+		require.Len(t, redemptions, 3)
+
+		expected := []promotion.Redemption{
+			{Code: "FIFTYOFF", OrderID: "order-1", CustomerID: "customer-1", RedeemedAt: march, CancelledAt: march.Add(time.Hour)},
+			{Code: "FIFTYOFF", OrderID: "order-2", CustomerID: "customer-2", RedeemedAt: march},
+			{Code: "FIFTYOFF", OrderID: "order-3", CustomerID: "customer-1", RedeemedAt: march},
+		}
+
+		for i, redemption := range redemptions {
+			assert.Equal(t, expected[i].OrderID, redemption.OrderID)
+			assert.Equal(t, expected[i].Code, redemption.Code)
+			assert.Equal(t, expected[i].CustomerID, redemption.CustomerID)
+			assert.True(t, expected[i].RedeemedAt.Equal(redemption.RedeemedAt), "redeemed %v", redemption.RedeemedAt)
+			assert.True(t, expected[i].CancelledAt.Equal(redemption.CancelledAt), "cancelled %v", redemption.CancelledAt)
+		}
+
+		redemptions, err = store.ListRedemptions("UNKNOWN")
+		require.NoError(t, err)
+		assert.Empty(t, redemptions)
+	})
+
+	t.Run("Concurrent redemptions", func(t *testing.T) {
+		store := open(t)
+
+		const maxUses = 5
+
+		require.NoError(t, store.SetLimits("FIFTYOFF", promotion.CouponLimits{MaxUses: maxUses}))
+
+		var (
+			wg         sync.WaitGroup
+			mu         sync.Mutex
+			redeemed   int
+			unexpected []error
+		)
+
+		for i := range 4 * maxUses {
+			wg.Go(func() {
+				err := store.Redeem(promotion.Redemption{
+					Code: "FIFTYOFF", OrderID: fmt.Sprintf("order-%d", i), RedeemedAt: march,
+				})
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				switch {
+				case err == nil:
+					redeemed++
+				case !errors.Is(err, promotion.ErrCouponUsedUp):
+					unexpected = append(unexpected, err)
+				}
+			})
+		}
+
+		wg.Wait()
+
+		assert.Empty(t, unexpected)
+		assert.Equal(t, maxUses, redeemed)
+	})
+}
@@ -0,0 +1,108 @@
+package promotion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ErrUncacheable is returned when fingerprinting a file set that includes
+// standard input, or a named pipe, whose content is different every time.
+var ErrUncacheable = errors.New("file set cannot be cached")
+
+// FileSetFingerprint identifies a set of coupon files, and their versions, so
+// that cached results are only reused for the same files, unchanged.
+//
+// The fingerprint covers the absolute path, size, and modification time of
+// each file. The order the files are given in, and duplicates, do not change
+// the fingerprint. The content is deliberately not hashed, that would mean
+// reading every file (which is what the cache exists to avoid), and any
+// change to a file updates its modification time. As the fingerprint is only
+// good on the machine it was made on, results are moved between machines by
+// the ContentFingerprint of the files.
+func FileSetFingerprint(files []string) (string, error) {
+	paths, err := fileSetPaths(files)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("stat of %s: %w", path, err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("%w %s is not a regular file", ErrUncacheable, path)
+		}
+
+		// The NUL separator cannot appear in a path.
+		fmt.Fprintf(hasher, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// ContentFingerprint identifies a set of coupon files by their content alone,
+// so that copies of the files are recognised on another machine, whatever
+// their paths and modification times. Unlike FileSetFingerprint every file is
+// read. The order the files are given in, and duplicate paths, do not change
+// the fingerprint.
+func ContentFingerprint(files []string) (string, error) {
+	paths, err := fileSetPaths(files)
+	if err != nil {
+		return "", err
+	}
+
+	// Two files with the same content are still two files, that a code can
+	// be found in.
+	checksums := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		checksum, err := FileChecksum(path)
+		if err != nil {
+			return "", err
+		}
+
+		checksums = append(checksums, checksum)
+	}
+
+	slices.Sort(checksums)
+
+	hasher := sha256.New()
+
+	for _, checksum := range checksums {
+		fmt.Fprintln(hasher, checksum)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// fileSetPaths are the absolute paths of the files, sorted, and without
+// duplicates. Standard input cannot be part of a file set.
+func fileSetPaths(files []string) ([]string, error) {
+	paths := make([]string, 0, len(files))
+
+	for _, file := range files {
+		if file == StdinPath {
+			return nil, fmt.Errorf("%w standard input", ErrUncacheable)
+		}
+
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("resolving path of %s: %w", file, err)
+		}
+
+		paths = append(paths, abs)
+	}
+
+	slices.Sort(paths)
+
+	return slices.Compact(paths), nil
+}
@@ -0,0 +1,28 @@
+//go:build darwin
+
+package promotion
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Names of network file systems, from statfs(2).
+var networkFileSystems = map[string]bool{
+	"nfs":     true,
+	"smbfs":   true,
+	"afpfs":   true,
+	"webdav":  true,
+	"macfuse": true,
+}
+
+// isNetworkFileSystem reports whether the file is on a network file system.
+func isNetworkFileSystem(f *os.File) bool {
+	var stat unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &stat); err != nil {
+		return false
+	}
+
+	return networkFileSystems[unix.ByteSliceToString(stat.Fstypename[:])]
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package promotion
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Magic numbers of network file systems, from statfs(2).
+var networkFileSystems = map[int64]bool{
+	unix.NFS_SUPER_MAGIC:  true,
+	unix.SMB_SUPER_MAGIC:  true,
+	unix.SMB2_SUPER_MAGIC: true,
+	unix.CIFS_SUPER_MAGIC: true,
+	unix.CEPH_SUPER_MAGIC: true,
+	unix.AFS_SUPER_MAGIC:  true,
+	unix.V9FS_MAGIC:       true,
+	// FUSE is most often sshfs, or a cloud storage mount.
+	unix.FUSE_SUPER_MAGIC: true,
+}
+
+// isNetworkFileSystem reports whether the file is on a network file system.
+func isNetworkFileSystem(f *os.File) bool {
+	var stat unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &stat); err != nil {
+		return false
+	}
+
+	return networkFileSystems[int64(stat.Type)] //nolint:unconvert // The type of Type differs by architecture.
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package promotion
+
+import "os"
+
+// isNetworkFileSystem reports whether the file is on a network file system.
+// It is not known on this platform, so mmap is always chosen.
+func isNetworkFileSystem(_ *os.File) bool {
+	return false
+}
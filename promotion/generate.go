@@ -0,0 +1,310 @@
+package promotion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// DefaultAlphabet is the characters of generated codes, the upper case letters
+// and digits without the AmbiguousCharacters.
+const DefaultAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// AmbiguousCharacters are easily mistaken for each other when a code is read
+// aloud, or typed from print (0 and O, 1, I and L).
+const AmbiguousCharacters = "0O1IL"
+
+// maxGenerateRounds is the number of times codes that collide with the files
+// are replaced, before giving up. Unless the files hold most of the possible
+// codes a second round is rare.
+const maxGenerateRounds = 10
+
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrInvalidCodeFormat = errors.New("invalid code format")
+	ErrTooManyCollisions = errors.New("too many generated codes are already in the files")
+)
+
+// CodeFormat is the shape of generated codes: the prefix, followed by Length
+// random characters from the alphabet, followed by an optional check
+// character.
+type CodeFormat struct {
+	// Alphabet is the characters the random part is made of, "" is the
+	// DefaultAlphabet. Letters are upper cased, and only letters and digits
+	// are allowed.
+	Alphabet string
+	// Length is the number of random characters.
+	Length int
+	// Prefix starts every code, eg. "SUMMER". Only letters, digits, '-', and
+	// '_' are allowed.
+	Prefix string
+	// CheckCharacter appends a Luhn mod N check character, computed over the
+	// random characters, so that most typing mistakes can be caught without
+	// a lookup.
+	CheckCharacter bool
+	// AllowAmbiguous allows the AmbiguousCharacters in the alphabet.
+	AllowAmbiguous bool
+}
+
+// CodeGenerator generates unique codes in a format.
+type CodeGenerator struct {
+	format   CodeFormat
+	alphabet string
+	random   io.Reader
+	// seen holds every code generated, so that none is repeated.
+	seen map[string]bool
+	// buffer holds random bytes not used yet.
+	buffer []byte
+}
+
+// NewCodeGenerator creates a generator of codes in the format, reading
+// randomness from random, which should be crypto/rand.Reader for codes that
+// must not be guessable.
+func NewCodeGenerator(format CodeFormat, random io.Reader) (*CodeGenerator, error) {
+	alphabet, err := normaliseAlphabet(format.Alphabet, format.AllowAmbiguous)
+	if err != nil {
+		return nil, err
+	}
+
+	format.Alphabet = alphabet
+	format.Prefix = strings.ToUpper(format.Prefix)
+
+	if format.Length < 1 {
+		return nil, fmt.Errorf("%w length must be at least 1", ErrInvalidCodeFormat)
+	}
+
+	for _, r := range format.Prefix {
+		if !isCodeCharacter(r) && r != '-' && r != '_' {
+			return nil, fmt.Errorf("%w prefix %q may only have letters, digits, '-', and '_'", ErrInvalidCodeFormat, format.Prefix)
+		}
+	}
+
+	return &CodeGenerator{
+		format:   format,
+		alphabet: alphabet,
+		random:   random,
+		seen:     map[string]bool{},
+	}, nil
+}
+
+// normaliseAlphabet upper cases the alphabet and removes repeated characters,
+// so that each character is equally likely.
+func normaliseAlphabet(alphabet string, allowAmbiguous bool) (string, error) {
+	if alphabet == "" {
+		return DefaultAlphabet, nil
+	}
+
+	var normalised strings.Builder
+
+	for _, r := range strings.ToUpper(alphabet) {
+		if !isCodeCharacter(r) {
+			return "", fmt.Errorf("%w alphabet may only have letters and digits, not %q", ErrInvalidCodeFormat, r)
+		}
+
+		if !allowAmbiguous && strings.ContainsRune(AmbiguousCharacters, r) {
+			return "", fmt.Errorf("%w alphabet has the ambiguous character %q", ErrInvalidCodeFormat, r)
+		}
+
+		if !strings.ContainsRune(normalised.String(), r) {
+			normalised.WriteRune(r)
+		}
+	}
+
+	if normalised.Len() < 2 {
+		return "", fmt.Errorf("%w alphabet needs at least 2 characters", ErrInvalidCodeFormat)
+	}
+
+	return normalised.String(), nil
+}
+
+func isCodeCharacter(r rune) bool {
+	return (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// Capacity is the number of different codes in the format, capped at
+// math.MaxInt.
+func (g *CodeGenerator) Capacity() int {
+	capacity := 1
+
+	for range g.format.Length {
+		if capacity > math.MaxInt/len(g.alphabet) {
+			return math.MaxInt
+		}
+
+		capacity *= len(g.alphabet)
+	}
+
+	return capacity
+}
+
+// Generate returns count codes, none of which this generator has returned
+// before. At most half of the possible codes can be generated, beyond that
+// too many random codes would be repeats.
+func (g *CodeGenerator) Generate(count int) ([]string, error) {
+	if count < 0 || len(g.seen)+count > g.Capacity()/2 {
+		return nil, fmt.Errorf("%w %d codes of %d characters from %d has too few possible codes, use a longer length",
+			ErrInvalidCodeFormat, len(g.seen)+count, g.format.Length, len(g.alphabet))
+	}
+
+	codes := make([]string, 0, count)
+
+	for len(codes) < count {
+		code, err := g.code()
+		if err != nil {
+			return nil, err
+		}
+
+		if g.seen[code] {
+			continue
+		}
+
+		g.seen[code] = true
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// GenerateAvoiding returns count codes, none of which are in the files. The
+// files are searched, with the scanner, for each batch of codes, and codes
+// found in them are replaced.
+func (g *CodeGenerator) GenerateAvoiding(ctx context.Context, count int, sc Scanner, files []string) ([]string, error) {
+	codes := make([]string, 0, count)
+
+	for round := range maxGenerateRounds {
+		candidates, err := g.Generate(count - len(codes))
+		if err != nil && round > 0 {
+			// The codes that collided have used up the possible codes.
+			return nil, fmt.Errorf("%w: %w", ErrTooManyCollisions, err)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(files) == 0 {
+			return append(codes, candidates...), nil
+		}
+
+		reports, err := sc.Report(ctx, candidates, files)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, report := range reports {
+			if len(report.Files) == 0 {
+				codes = append(codes, report.Code)
+			}
+		}
+
+		if len(codes) == count {
+			return codes, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w, %d of %d found after %d rounds", ErrTooManyCollisions, len(codes), count, maxGenerateRounds)
+}
+
+// Valid reports whether the code is in the generator's format, including its
+// check character.
+func (g *CodeGenerator) Valid(code string) bool {
+	random, ok := strings.CutPrefix(strings.ToUpper(code), g.format.Prefix)
+	if !ok {
+		return false
+	}
+
+	length := g.format.Length
+	if g.format.CheckCharacter {
+		length++
+	}
+
+	if len(random) != length {
+		return false
+	}
+
+	for _, r := range random {
+		if !strings.ContainsRune(g.alphabet, r) {
+			return false
+		}
+	}
+
+	if !g.format.CheckCharacter {
+		return true
+	}
+
+	return random[length-1] == g.checkCharacter(random[:length-1])
+}
+
+// code returns a random code.
+func (g *CodeGenerator) code() (string, error) {
+	random := make([]byte, g.format.Length)
+
+	for i := range random {
+		index, err := g.randomIndex()
+		if err != nil {
+			return "", err
+		}
+
+		random[i] = g.alphabet[index]
+	}
+
+	code := g.format.Prefix + string(random)
+	if g.format.CheckCharacter {
+		code += string(g.checkCharacter(string(random)))
+	}
+
+	return code, nil
+}
+
+// randomIndex returns a random index into the alphabet. Bytes that would make
+// some characters more likely than others are skipped.
+func (g *CodeGenerator) randomIndex() (int, error) {
+	size := len(g.alphabet)
+	limit := 256 - 256%size
+
+	for {
+		if len(g.buffer) == 0 {
+			g.buffer = make([]byte, 256)
+
+			if _, err := io.ReadFull(g.random, g.buffer); err != nil {
+				return 0, fmt.Errorf("reading randomness: %w", err)
+			}
+		}
+
+		b := int(g.buffer[0])
+		g.buffer = g.buffer[1:]
+
+		if b < limit {
+			return b % size, nil
+		}
+	}
+}
+
+// checkCharacter is the Luhn mod N check character of the random characters.
+// Luhn's sum of the digits of doubled values only catches every mistyped
+// character when the alphabet has an even number of characters, for odd
+// alphabets doubled values are taken mod N instead, which catches the same
+// mistakes (any single character, and most swapped neighbours).
+func (g *CodeGenerator) checkCharacter(random string) byte {
+	size := len(g.alphabet)
+	factor := 2
+	sum := 0
+
+	// Every second character, starting from the right, is doubled.
+	for i := len(random) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(g.alphabet, random[i])
+
+		if size%2 == 0 {
+			sum += addend/size + addend%size
+		} else {
+			sum += addend % size
+		}
+
+		factor = 3 - factor
+	}
+
+	return g.alphabet[(size-sum%size)%size]
+}
@@ -0,0 +1,182 @@
+package promotion_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seededRandom is a repeatable source of randomness, for tests only.
+func seededRandom() *rand.ChaCha8 {
+	return rand.NewChaCha8([32]byte{1})
+}
+
+func TestNewCodeGenerator(t *testing.T) {
+	testcases := map[string]struct {
+		format        promotion.CodeFormat
+		expectedError error
+	}{
+		"Default alphabet": {
+			format: promotion.CodeFormat{Length: 8},
+		},
+		"Custom alphabet and prefix": {
+			format: promotion.CodeFormat{Alphabet: "abcdef", Length: 8, Prefix: "summer-"},
+		},
+		"No length": {
+			format:        promotion.CodeFormat{},
+			expectedError: promotion.ErrInvalidCodeFormat,
+		},
+		"Ambiguous alphabet": {
+			format:        promotion.CodeFormat{Alphabet: "ABC0", Length: 8},
+			expectedError: promotion.ErrInvalidCodeFormat,
+		},
+		"Ambiguous alphabet allowed": {
+			format: promotion.CodeFormat{Alphabet: "ABC0", Length: 8, AllowAmbiguous: true},
+		},
+		"Punctuation in the alphabet": {
+			format:        promotion.CodeFormat{Alphabet: "ABC-", Length: 8},
+			expectedError: promotion.ErrInvalidCodeFormat,
+		},
+		"Single character alphabet": {
+			format:        promotion.CodeFormat{Alphabet: "aA", Length: 8},
+			expectedError: promotion.ErrInvalidCodeFormat,
+		},
+		"Space in the prefix": {
+			format:        promotion.CodeFormat{Length: 8, Prefix: "SUMMER "},
+			expectedError: promotion.ErrInvalidCodeFormat,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			_, actualError := promotion.NewCodeGenerator(tc.format, seededRandom())
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	testcases := map[string]struct {
+		alphabet string
+		pattern  string
+	}{
+		"Default alphabet, odd length": {
+			pattern: "^SUMMER-[" + promotion.DefaultAlphabet + "]{7}$",
+		},
+		"Even length alphabet": {
+			alphabet: "abcdefgh",
+			pattern:  "^SUMMER-[A-H]{7}$",
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			format := promotion.CodeFormat{Alphabet: tc.alphabet, Length: 6, Prefix: "summer-", CheckCharacter: true}
+
+			generator, err := promotion.NewCodeGenerator(format, seededRandom())
+			require.NoError(t, err)
+
+			codes, err := generator.Generate(1000)
+			require.NoError(t, err)
+			require.Len(t, codes, 1000)
+
+			// Codes generated later do not repeat earlier ones.
+			more, err := generator.Generate(1000)
+			require.NoError(t, err)
+
+			unique := map[string]bool{}
+
+			for _, code := range append(codes, more...) {
+				unique[code] = true
+
+				assert.Regexp(t, tc.pattern, code)
+				assert.True(t, generator.Valid(code), "%s is not valid", code)
+			}
+
+			assert.Len(t, unique, 2000)
+
+			alphabet := strings.ToUpper(tc.alphabet)
+			if alphabet == "" {
+				alphabet = promotion.DefaultAlphabet
+			}
+
+			// The check character catches every change to a single
+			// character.
+			for _, code := range codes[:100] {
+				for i := len("SUMMER-"); i < len(code); i++ {
+					for _, r := range alphabet {
+						if byte(r) == code[i] {
+							continue
+						}
+
+						mistyped := code[:i] + string(r) + code[i+1:]
+						assert.False(t, generator.Valid(mistyped), "%s, mistyped from %s, is valid", mistyped, code)
+					}
+				}
+			}
+
+			assert.False(t, generator.Valid("WINTER-"+codes[0][len("SUMMER-"):]))
+			assert.False(t, generator.Valid(codes[0]+"A"))
+		})
+	}
+}
+
+func TestGenerateTooFewPossibleCodes(t *testing.T) {
+	// 2 characters from 4 is 16 possible codes, half of which can be
+	// generated.
+	generator, err := promotion.NewCodeGenerator(promotion.CodeFormat{Alphabet: "ABCD", Length: 2}, seededRandom())
+	require.NoError(t, err)
+
+	assert.Equal(t, 16, generator.Capacity())
+
+	_, err = generator.Generate(9)
+	assert.ErrorIs(t, err, promotion.ErrInvalidCodeFormat)
+
+	codes, err := generator.Generate(8)
+	require.NoError(t, err)
+	assert.Len(t, codes, 8)
+}
+
+func TestGenerateAvoiding(t *testing.T) {
+	// Every code starting with A is already issued, in lower case.
+	issued := []string{}
+
+	for _, second := range "ABCD" {
+		for _, third := range "ABCD" {
+			issued = append(issued, "a"+string(second)+string(third))
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "issued.txt")
+	require.NoError(t, os.WriteFile(file, []byte(strings.Join(issued, "\n")+"\n"), 0o600))
+
+	scanner := promotion.Scanner{Normalisation: promotion.DefaultNormalisation}
+
+	generator, err := promotion.NewCodeGenerator(promotion.CodeFormat{Alphabet: "ABCD", Length: 3}, seededRandom())
+	require.NoError(t, err)
+
+	codes, err := generator.GenerateAvoiding(context.Background(), 10, scanner, []string{file})
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+
+	for _, code := range codes {
+		assert.NotEqual(t, byte('A'), code[0], "%s is already issued", code)
+	}
+
+	// When the files hold all of the possible codes, none can be generated.
+	generator, err = promotion.NewCodeGenerator(promotion.CodeFormat{Alphabet: "ABCD", Length: 2}, seededRandom())
+	require.NoError(t, err)
+
+	// The issued codes, without their A, are every 2 character code.
+	all := filepath.Join(t.TempDir(), "all.txt")
+	require.NoError(t, os.WriteFile(all, []byte(strings.ReplaceAll(strings.Join(issued, "\n"), "a", "")+"\n"), 0o600))
+
+	_, err = generator.GenerateAvoiding(context.Background(), 1, scanner, []string{all})
+	assert.ErrorIs(t, err, promotion.ErrTooManyCollisions)
+}
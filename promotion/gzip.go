@@ -0,0 +1,319 @@
+package promotion
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// gzipWindow is how far ahead, in compressed bytes, of the member being
+// decompressed the members that follow it are looked for.
+const gzipWindow = 16 << 20
+
+// gzipAheadBudget bounds the decompressed bytes held for the members of a
+// window that are decompressed ahead. A member that would go over it is
+// decompressed when it is reached instead.
+const gzipAheadBudget = 128 << 20
+
+// gzipChunkSize is how much of a member that is decompressed ahead is read
+// between checks that it is still wanted.
+const gzipChunkSize = 256 << 10
+
+// gzipHeaderLen is the length of the fixed part of a member's header.
+const gzipHeaderLen = 10
+
+// newGzipReader decompresses a gzip stream, which may hold several members (eg.
+// concatenated files, or files written in blocks). The member being read is
+// decompressed as a stream, while the members that start within gzipWindow
+// of it are decompressed ahead, on the other GOMAXPROCS-1 goroutines.
+//
+// Where a member starts is not known until the member before it ends, so every
+// offset that looks like the start of a member is tried. Only the members that
+// the previous member ends at are used, the rest are discarded. A member that
+// is larger than the window, or than the budget, is decompressed when it is
+// reached, so a file that is a single member is decompressed on one goroutine.
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	source := &countingReader{reader: r}
+	buffered := bufio.NewReaderSize(source, gzipWindow)
+
+	header, err := buffered.Peek(gzipHeaderLen)
+
+	switch {
+	case len(header) == 0 && errors.Is(err, io.EOF):
+		return nil, io.EOF
+	case errors.Is(err, io.EOF):
+		return nil, io.ErrUnexpectedEOF
+	case err != nil:
+		return nil, err
+	case !isGzipHeader(header):
+		return nil, gzip.ErrHeader
+	}
+
+	reader, writer := io.Pipe()
+
+	members := gzipMembers{source: source, buffered: buffered, output: writer, workers: runtime.GOMAXPROCS(0) - 1}
+
+	// Closing the reader fails the next write, which stops the decompression.
+	go func() {
+		writer.CloseWithError(members.decompress())
+	}()
+
+	return reader, nil
+}
+
+// isGzipHeader reports whether the bytes could be the start of a member, the
+// magic number, the deflate method, and no reserved flags.
+func isGzipHeader(header []byte) bool {
+	return len(header) >= gzipHeaderLen && bytes.HasPrefix(header, gzipMagic) &&
+		header[2] == 8 && header[3]&0xe0 == 0
+}
+
+// gzipMemberOffsets are the offsets, after the first, that look like the start
+// of a member, and have room for its header.
+func gzipMemberOffsets(window []byte) []int {
+	offsets := []int{}
+
+	for offset := 1; offset+gzipHeaderLen <= len(window); offset++ {
+		found := bytes.Index(window[offset:], gzipMagic)
+		if found < 0 {
+			break
+		}
+
+		offset += found
+
+		if isGzipHeader(window[offset:]) {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	return offsets
+}
+
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+
+	return n, err
+}
+
+// gzipMembers decompresses the members of a gzip stream, in order, to the
+// output.
+type gzipMembers struct {
+	source   *countingReader
+	buffered *bufio.Reader
+	output   io.Writer
+	workers  int
+}
+
+// decompress decompresses the stream a window at a time. The first member of
+// the window is streamed, while the members after it are decompressed ahead,
+// then those that follow on from it are written.
+func (g gzipMembers) decompress() error {
+	for {
+		// A read error leaves the window short, and is returned by the
+		// member that reads past it.
+		window, err := g.buffered.Peek(gzipWindow)
+		if len(window) == 0 {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		// The window is copied, reading the first member moves the
+		// buffered bytes.
+		var ahead *gzipAhead
+
+		if g.workers > 0 {
+			if offsets := gzipMemberOffsets(window); len(offsets) > 0 {
+				ahead = decompressAhead(bytes.Clone(window), offsets, g.workers)
+			}
+		}
+
+		end, inWindow, err := g.copyMember()
+		if err != nil || !inWindow || ahead == nil {
+			ahead.stop()
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		next, err := ahead.copyFrom(end, g.output)
+		ahead.stop()
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := g.buffered.Discard(next - end); err != nil {
+			return err
+		}
+	}
+}
+
+// copyMember decompresses the member at the start of the buffered stream to
+// the output. It returns the member's compressed length, when it ends within
+// the bytes that were buffered.
+func (g gzipMembers) copyMember() (int, bool, error) {
+	buffered, read := g.buffered.Buffered(), g.source.read
+
+	// The buffered reader is read one byte at a time, so the member reads
+	// no further than its end.
+	member, err := gzip.NewReader(g.buffered)
+	if err != nil {
+		return 0, false, err
+	}
+	defer member.Close()
+
+	member.Multistream(false)
+
+	if _, err := io.Copy(g.output, member); err != nil {
+		return 0, false, err
+	}
+
+	// Nothing more has been read from the source, so the member ended
+	// within the buffered bytes.
+	if g.source.read != read {
+		return 0, false, nil
+	}
+
+	return buffered - g.buffered.Buffered(), true, nil
+}
+
+// gzipAhead is the members of a window, by their offset in it, decompressed
+// ahead of being reached.
+type gzipAhead struct {
+	members map[int]*gzipMember
+	// budget is the decompressed bytes that may still be held.
+	budget  atomic.Int64
+	stopped atomic.Bool
+	wg      sync.WaitGroup
+}
+
+// gzipMember is a member decompressed ahead. It is only usable when ok, which
+// an offset that was not the start of a member never is.
+type gzipMember struct {
+	done chan struct{}
+	ok   bool
+	data []byte
+	// end is the offset in the window after the member.
+	end int
+}
+
+// decompressAhead decompresses the members at the offsets of the window, in
+// order, on up to workers goroutines.
+func decompressAhead(window []byte, offsets []int, workers int) *gzipAhead {
+	ahead := &gzipAhead{members: make(map[int]*gzipMember, len(offsets))}
+	ahead.budget.Store(gzipAheadBudget)
+
+	queue := make(chan int, len(offsets))
+
+	for _, offset := range offsets {
+		ahead.members[offset] = &gzipMember{done: make(chan struct{})}
+		queue <- offset
+	}
+
+	close(queue)
+
+	for range min(workers, len(offsets)) {
+		ahead.wg.Go(func() {
+			for offset := range queue {
+				ahead.decompressMember(window, offset)
+			}
+		})
+	}
+
+	return ahead
+}
+
+// decompressMember decompresses the member at the offset of the window, if
+// there is one, and it is still wanted.
+func (ahead *gzipAhead) decompressMember(window []byte, offset int) {
+	member := ahead.members[offset]
+	defer close(member.done)
+
+	if ahead.stopped.Load() {
+		return
+	}
+
+	compressed := bytes.NewReader(window[offset:])
+
+	reader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	reader.Multistream(false)
+
+	var data bytes.Buffer
+
+	for !ahead.stopped.Load() {
+		n, err := io.CopyN(&data, reader, gzipChunkSize)
+		if ahead.budget.Add(-n) < 0 {
+			return
+		}
+
+		if errors.Is(err, io.EOF) {
+			member.ok = true
+			member.data = data.Bytes()
+			member.end = len(window) - compressed.Len()
+
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// copyFrom writes the members that follow on from the offset, in order, and
+// returns the offset of the first member that was not decompressed ahead.
+func (ahead *gzipAhead) copyFrom(offset int, output io.Writer) (int, error) {
+	for {
+		member, found := ahead.members[offset]
+		if !found {
+			return offset, nil
+		}
+
+		<-member.done
+
+		if !member.ok {
+			return offset, nil
+		}
+
+		if _, err := output.Write(member.data); err != nil {
+			return offset, err
+		}
+
+		offset = member.end
+	}
+}
+
+// stop discards the members that have not been decompressed, and waits for
+// the goroutines decompressing them. A nil ahead has nothing to stop.
+func (ahead *gzipAhead) stop() {
+	if ahead == nil {
+		return
+	}
+
+	ahead.stopped.Store(true)
+	ahead.wg.Wait()
+}
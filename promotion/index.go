@@ -0,0 +1,612 @@
+package promotion
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Index errors.
+//
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrIndexMissing = errors.New("no index for file")
+	ErrIndexStale   = errors.New("index is out of date")
+	ErrIndexCorrupt = errors.New("index is corrupt")
+	ErrNotIndexable = errors.New("only regular files can be indexed")
+)
+
+// Index file layout (all integers little endian):
+//
+//	magic        [8]byte "KARTIDX2"
+//	size         int64   size of the source file
+//	modTime      int64   modification time of the source file (unix nanos)
+//	hash         [32]byte sha256 of the source file content
+//	numCodes     uint64
+//	bloomHashes  uint32
+//	bloomLen     uint64  length of the Bloom filter, in bytes
+//	pathLen      uint32
+//	normalisation uint32 the Normalisation applied to the lines
+//	path         [pathLen]byte
+//	bloom        [bloomLen]byte
+//	offsets      [numCodes+1]uint64 into the codes blob
+//	counts       [numCodes]uint32
+//	codes        the sorted, unique, codes concatenated
+//
+// size and modTime are at fixed offsets, so that they can be updated in place
+// when a file is touched without its content changing.
+const (
+	indexMagic         = "KARTIDX2"
+	indexSizeOffset    = 8
+	indexModTimeOffset = 16
+	indexHashOffset    = 24
+	indexFixedLen      = indexHashOffset + sha256.Size + 8 + 4 + 8 + 4 + 4
+)
+
+// maxIndexedLineLen is the longest line that is indexed, longer lines cannot
+// be codes, and are skipped.
+const maxIndexedLineLen = 1<<lineLenBits - 1
+
+// Lines are recorded, while building, as a start offset and length packed
+// into a uint64, to keep the memory needed for files with hundreds of
+// millions of lines down.
+const lineLenBits = 24
+
+// FileIndex is a pre-built index of the codes in a single coupon file. It is
+// read through an mmap, so opening an index is cheap regardless of its size.
+type FileIndex struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// Hash is the hex encoded sha256 of the source file content.
+	Hash string
+	// Normalisation was applied to the lines before they were indexed, codes
+	// need the same normalisation to be found.
+	Normalisation Normalisation
+
+	data    []byte
+	bloom   bloomFilter
+	offsets []byte
+	counts  []byte
+	codes   []byte
+	num     int
+}
+
+// Count returns the number of lines in the indexed file that match the code
+// exactly.
+func (fi *FileIndex) Count(code []byte) int {
+	if !fi.bloom.mayContain(code) {
+		return 0
+	}
+
+	low, high := 0, fi.num
+	for low < high {
+		mid := int(uint(low+high) >> 1)
+
+		switch cmp := bytes.Compare(fi.code(mid), code); {
+		case cmp == 0:
+			return int(binary.LittleEndian.Uint32(fi.counts[mid*4:]))
+		case cmp < 0:
+			low = mid + 1
+		default:
+			high = mid
+		}
+	}
+
+	return 0
+}
+
+func (fi *FileIndex) code(i int) []byte {
+	start := binary.LittleEndian.Uint64(fi.offsets[i*8:])
+	end := binary.LittleEndian.Uint64(fi.offsets[(i+1)*8:])
+
+	return fi.codes[start:end]
+}
+
+// Close releases the index.
+func (fi *FileIndex) Close() error {
+	if fi.data == nil {
+		return nil
+	}
+
+	err := unix.Munmap(fi.data)
+	fi.data = nil
+
+	return err
+}
+
+// IndexDir holds the indexes for coupon files. Each source file has a single
+// index, named after the hash of its absolute path.
+type IndexDir struct {
+	dir           string
+	normalisation Normalisation
+}
+
+// NewIndexDir uses (creating if needed) the directory to store indexes, of
+// lines with the normalisation applied.
+func NewIndexDir(dir string, normalisation Normalisation) (*IndexDir, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating index directory: %w", err)
+	}
+
+	return &IndexDir{dir: dir, normalisation: normalisation}, nil
+}
+
+// indexPath returns the path of the index for the source file.
+func (d *IndexDir) indexPath(source string) (string, error) {
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return "", fmt.Errorf("resolving path of %s: %w", source, err)
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:16])+".idx"), nil
+}
+
+// Open returns the index for the source file. ErrIndexMissing is returned if
+// the file has never been indexed, and ErrIndexStale if the file has changed
+// (size or modification time) since it was indexed, or it was indexed with a
+// different normalisation.
+func (d *IndexDir) Open(source string) (*FileIndex, error) {
+	// Pipes cannot be indexed.
+	if source == StdinPath {
+		return nil, fmt.Errorf("%w standard input", ErrIndexMissing)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("stat of %s: %w", source, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w %s is not a regular file", ErrIndexMissing, source)
+	}
+
+	idx, err := d.open(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if idx.Size != info.Size() || !idx.ModTime.Equal(info.ModTime()) || idx.Normalisation != d.normalisation {
+		_ = idx.Close()
+		return nil, fmt.Errorf("%w %s", ErrIndexStale, source)
+	}
+
+	return idx, nil
+}
+
+func (d *IndexDir) open(source string) (*FileIndex, error) {
+	path, err := d.indexPath(source)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path) // #nosec G304 -- The path is derived from a hash.
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w %s", ErrIndexMissing, source)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("opening index of %s: %w", source, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat of index of %s: %w", source, err)
+	}
+
+	if info.Size() < indexFixedLen {
+		return nil, fmt.Errorf("%w %s", ErrIndexCorrupt, path)
+	}
+
+	data, err := unix.Mmap(int(f.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mmap index: %w", err)
+	}
+
+	idx, err := parseIndex(data)
+	if err != nil {
+		_ = unix.Munmap(data)
+		return nil, fmt.Errorf("index %s: %w", path, err)
+	}
+
+	return idx, nil
+}
+
+// parseIndex slices the mmapped index file into its sections.
+func parseIndex(data []byte) (*FileIndex, error) {
+	if string(data[:len(indexMagic)]) != indexMagic {
+		return nil, fmt.Errorf("%w bad magic number", ErrIndexCorrupt)
+	}
+
+	le := binary.LittleEndian
+	pos := indexHashOffset + sha256.Size
+
+	idx := &FileIndex{
+		data:    data,
+		Size:    int64(le.Uint64(data[indexSizeOffset:])),
+		ModTime: time.Unix(0, int64(le.Uint64(data[indexModTimeOffset:]))),
+		Hash:    hex.EncodeToString(data[indexHashOffset:pos]),
+	}
+
+	numCodes := le.Uint64(data[pos:])
+	idx.bloom.hashes = le.Uint32(data[pos+8:])
+	bloomLen := le.Uint64(data[pos+12:])
+	pathLen := uint64(le.Uint32(data[pos+20:]))
+	idx.Normalisation = Normalisation(le.Uint32(data[pos+24:]))
+	pos += 28
+
+	// Check the declared lengths fit before slicing, so that a truncated
+	// index is reported rather than causing a panic.
+	remaining := uint64(len(data) - pos)
+	if bloomLen == 0 || pathLen+bloomLen > remaining || (numCodes+1)*8+numCodes*4 > remaining-pathLen-bloomLen {
+		return nil, fmt.Errorf("%w truncated", ErrIndexCorrupt)
+	}
+
+	idx.num = int(numCodes)
+	idx.Path = string(data[pos : pos+int(pathLen)])
+	pos += int(pathLen)
+	idx.bloom.bits = data[pos : pos+int(bloomLen)]
+	pos += int(bloomLen)
+	idx.offsets = data[pos : pos+(idx.num+1)*8]
+	pos += (idx.num + 1) * 8
+	idx.counts = data[pos : pos+idx.num*4]
+	pos += idx.num * 4
+	idx.codes = data[pos:]
+
+	if le.Uint64(idx.offsets[idx.num*8:]) != uint64(len(idx.codes)) {
+		return nil, fmt.Errorf("%w codes length mismatch", ErrIndexCorrupt)
+	}
+
+	return idx, nil
+}
+
+// Build indexes the source file, unless the existing index is up to date.
+// If the file has been touched, but its content is unchanged, the existing
+// index is updated in place rather than rebuilt. It reports whether the index
+// was (re)built.
+func (d *IndexDir) Build(source string) (bool, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return false, fmt.Errorf("stat of %s: %w", source, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return false, fmt.Errorf("%w, %s is not", ErrNotIndexable, source)
+	}
+
+	existing, err := d.open(source)
+	if err == nil && existing.Normalisation != d.normalisation {
+		_ = existing.Close()
+	} else if err == nil {
+		upToDate := existing.Size == info.Size() && existing.ModTime.Equal(info.ModTime())
+		existingHash := existing.Hash
+		_ = existing.Close()
+
+		if upToDate {
+			return false, nil
+		}
+
+		hash, err := FileChecksum(source)
+		if err != nil {
+			return false, err
+		}
+
+		if hash == existingHash {
+			return false, d.touch(source, info)
+		}
+	}
+
+	return true, d.build(source)
+}
+
+// touch updates the size and modification time recorded in the index.
+func (d *IndexDir) touch(source string, info os.FileInfo) error {
+	path, err := d.indexPath(source)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0) // #nosec G304 -- The path is derived from a hash.
+	if err != nil {
+		return fmt.Errorf("opening index of %s: %w", source, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, indexHashOffset-indexSizeOffset)
+	binary.LittleEndian.PutUint64(header, uint64(info.Size()))
+	binary.LittleEndian.PutUint64(header[8:], uint64(info.ModTime().UnixNano()))
+
+	if _, err := f.WriteAt(header, indexSizeOffset); err != nil {
+		return fmt.Errorf("updating index of %s: %w", source, err)
+	}
+
+	return f.Close()
+}
+
+// build reads the whole source file, and writes a new index for it.
+func (d *IndexDir) build(source string) error {
+	content, err := readSource(source)
+	if err != nil {
+		return err
+	}
+	defer content.release()
+
+	data := content.data
+
+	// Normalised lines are usually a sub slice of the line (or the line
+	// itself), those that are not are copied to changed. Packed start
+	// offsets beyond the end of data are offsets into changed.
+	normaliser := lineNormaliser{normalisation: d.normalisation}
+	changed := []byte{}
+
+	// Record every line, then sort them so that duplicates are adjacent.
+	lines := make([]uint64, 0, len(data)/16)
+
+	for start := 0; start < len(data); {
+		end := bytes.IndexByte(data[start:], '\n')
+		if end == -1 {
+			end = len(data)
+		} else {
+			end += start
+		}
+
+		raw := data[start:end]
+		normalised, copied := normaliser.normalise(raw)
+
+		if length := len(normalised); length <= maxIndexedLineLen {
+			lineStart := start
+			switch {
+			case length == 0:
+			case copied:
+				lineStart = len(data) + len(changed)
+				changed = append(changed, normalised...)
+			default:
+				// The sub slice shares the end of raw's capacity.
+				lineStart = start + cap(raw) - cap(normalised)
+			}
+
+			lines = append(lines, uint64(lineStart)<<lineLenBits|uint64(length))
+		}
+
+		start = end + 1
+	}
+
+	line := func(packed uint64) []byte {
+		start := int(packed >> lineLenBits)
+		end := start + int(packed&maxIndexedLineLen)
+
+		if start >= len(data) {
+			return changed[start-len(data) : end-len(data)]
+		}
+
+		return data[start:end]
+	}
+
+	slices.SortFunc(lines, func(a, b uint64) int {
+		return bytes.Compare(line(a), line(b))
+	})
+
+	// Collapse the duplicates into counts.
+	unique := lines[:0]
+	counts := []uint32{}
+
+	for _, packed := range lines {
+		if len(unique) > 0 && bytes.Equal(line(unique[len(unique)-1]), line(packed)) {
+			counts[len(counts)-1]++
+			continue
+		}
+
+		unique = append(unique, packed)
+		counts = append(counts, 1)
+	}
+
+	return d.write(source, content, unique, counts, line)
+}
+
+func (d *IndexDir) write(
+	source string,
+	content sourceContent,
+	unique []uint64,
+	counts []uint32,
+	line func(uint64) []byte,
+) error {
+	path, err := d.indexPath(source)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file, and rename, so that a concurrent search
+	// never sees a partially written index.
+	tmp, err := os.CreateTemp(d.dir, ".building-*")
+	if err != nil {
+		return fmt.Errorf("creating index of %s: %w", source, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	bloom := newBloomFilter(len(unique))
+	for _, packed := range unique {
+		bloom.add(line(packed))
+	}
+
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return fmt.Errorf("resolving path of %s: %w", source, err)
+	}
+
+	le := binary.LittleEndian
+	header := make([]byte, 0, indexFixedLen+len(abs)+len(bloom.bits))
+	header = append(header, indexMagic...)
+	header = le.AppendUint64(header, uint64(content.info.Size()))
+	header = le.AppendUint64(header, uint64(content.info.ModTime().UnixNano()))
+	header = append(header, content.hash[:]...)
+	header = le.AppendUint64(header, uint64(len(unique)))
+	header = le.AppendUint32(header, bloom.hashes)
+	header = le.AppendUint64(header, uint64(len(bloom.bits)))
+	header = le.AppendUint32(header, uint32(len(abs)))
+	header = le.AppendUint32(header, uint32(d.normalisation))
+	header = append(header, abs...)
+	header = append(header, bloom.bits...)
+
+	writer := newBufferedWriter(tmp)
+	writer.write(header)
+
+	offset := uint64(0)
+	for _, packed := range unique {
+		writer.writeUint64(offset)
+		offset += packed & maxIndexedLineLen
+	}
+
+	writer.writeUint64(offset)
+
+	for _, count := range counts {
+		writer.writeUint32(count)
+	}
+
+	for _, packed := range unique {
+		writer.write(line(packed))
+	}
+
+	if err := writer.flush(); err != nil {
+		return fmt.Errorf("writing index of %s: %w", source, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing index of %s: %w", source, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing index of %s: %w", source, err)
+	}
+
+	return nil
+}
+
+// bufferedWriter batches small writes, and remembers the first error, so that
+// the write loops stay readable.
+type bufferedWriter struct {
+	w       io.Writer
+	buf     []byte
+	scratch [8]byte
+	err     error
+}
+
+const bufferedWriterSize = 1 << 20
+
+func newBufferedWriter(w io.Writer) *bufferedWriter {
+	return &bufferedWriter{w: w, buf: make([]byte, 0, bufferedWriterSize)}
+}
+
+func (bw *bufferedWriter) write(data []byte) {
+	if len(bw.buf)+len(data) > cap(bw.buf) {
+		_ = bw.flush()
+	}
+
+	bw.buf = append(bw.buf, data...)
+}
+
+func (bw *bufferedWriter) writeUint64(value uint64) {
+	bw.write(binary.LittleEndian.AppendUint64(bw.scratch[:0], value))
+}
+
+func (bw *bufferedWriter) writeUint32(value uint32) {
+	bw.write(binary.LittleEndian.AppendUint32(bw.scratch[:0], value))
+}
+
+func (bw *bufferedWriter) flush() error {
+	if bw.err == nil && len(bw.buf) > 0 {
+		_, bw.err = bw.w.Write(bw.buf)
+	}
+
+	bw.buf = bw.buf[:0]
+
+	return bw.err
+}
+
+// sourceContent is the (decompressed) content of a coupon file.
+type sourceContent struct {
+	data    []byte
+	info    os.FileInfo
+	hash    [sha256.Size]byte
+	release func()
+}
+
+// readSource returns the decompressed content of the file, and the hash of
+// its raw content. Plain files are mmapped, compressed files are necessarily
+// decompressed into memory.
+func readSource(source string) (sourceContent, error) {
+	f, err := os.Open(source) // #nosec G304 -- Coupon files are chosen by the operator.
+	if err != nil {
+		return sourceContent{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return sourceContent{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	content := sourceContent{info: info, release: func() {}}
+
+	if info.Size() == 0 {
+		content.hash = sha256.Sum256(nil)
+		return content, nil
+	}
+
+	header := make([]byte, magicHeaderLen)
+
+	n, err := f.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return sourceContent{}, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	compression := DetectCompression(header[:n])
+	if compression == CompressionNone {
+		data, err := unix.Mmap(int(f.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+		if err != nil {
+			return sourceContent{}, fmt.Errorf("failed to mmap file: %w", err)
+		}
+
+		content.data = data
+		content.hash = sha256.Sum256(data)
+		content.release = func() { _ = unix.Munmap(data) }
+
+		return content, nil
+	}
+
+	// Hash the raw (compressed) bytes as they are fed to the decompressor.
+	hasher := sha256.New()
+	raw := io.TeeReader(f, hasher)
+
+	decompressor, err := newDecompressor(compression, raw)
+	if err != nil {
+		return sourceContent{}, fmt.Errorf("failed to decompress %s file: %w", compression, err)
+	}
+	defer decompressor.Close()
+
+	if content.data, err = io.ReadAll(decompressor); err != nil {
+		return sourceContent{}, fmt.Errorf("failed to decompress %s file: %w", compression, err)
+	}
+
+	// The decompressor may stop before the end of the file (eg. padding).
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return sourceContent{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	copy(content.hash[:], hasher.Sum(nil))
+
+	return content, nil
+}
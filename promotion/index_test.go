@@ -0,0 +1,189 @@
+package promotion_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexCount(t *testing.T) {
+	testcases := map[string]struct {
+		file string
+	}{
+		"Plain text": {file: "coupons.txt"},
+		"gzip":       {file: "coupons.gz"},
+		"bzip2":      {file: "coupons.bz2"},
+		"zstd":       {file: "coupons.zst"},
+		"xz":         {file: "coupons.xz"},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			indexes, err := promotion.NewIndexDir(t.TempDir(), promotion.NormaliseNone)
+			require.NoError(t, err)
+
+			source := filepath.Join("testdata", tc.file)
+
+			rebuilt, err := indexes.Build(source)
+			require.NoError(t, err)
+			assert.True(t, rebuilt)
+
+			idx, err := indexes.Open(source)
+			require.NoError(t, err)
+
+			defer idx.Close()
+
+			assert.Equal(t, 3, idx.Count([]byte("FIFTYOFF")))
+			assert.Equal(t, 1, idx.Count([]byte("TENOFF")))
+			assert.Equal(t, 0, idx.Count([]byte("MISSING")))
+			assert.Equal(t, 0, idx.Count([]byte("FIFTY")))
+		})
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "coupons.txt")
+	require.NoError(t, os.WriteFile(source, []byte("FIFTYOFF\nTENOFF\n"), 0o600))
+
+	indexes, err := promotion.NewIndexDir(filepath.Join(t.TempDir(), "index"), promotion.NormaliseNone)
+	require.NoError(t, err)
+
+	rebuilt, err := indexes.Build(source)
+	require.NoError(t, err)
+	assert.True(t, rebuilt, "a new file is indexed")
+
+	rebuilt, err = indexes.Build(source)
+	require.NoError(t, err)
+	assert.False(t, rebuilt, "an unchanged file is not indexed again")
+
+	// Touching the file, without changing the content, updates the index in
+	// place.
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(source, later, later))
+
+	_, err = indexes.Open(source)
+	require.ErrorIs(t, err, promotion.ErrIndexStale)
+
+	rebuilt, err = indexes.Build(source)
+	require.NoError(t, err)
+	assert.False(t, rebuilt, "a touched file is not indexed again")
+
+	idx, err := indexes.Open(source)
+	require.NoError(t, err)
+	assert.Equal(t, 1, idx.Count([]byte("TENOFF")))
+	require.NoError(t, idx.Close())
+
+	// Changing the content rebuilds the index.
+	require.NoError(t, os.WriteFile(source, []byte("FIFTYOFF\nTENOFF\nTENOFF\n"), 0o600))
+
+	_, err = indexes.Open(source)
+	require.ErrorIs(t, err, promotion.ErrIndexStale)
+
+	rebuilt, err = indexes.Build(source)
+	require.NoError(t, err)
+	assert.True(t, rebuilt, "a changed file is indexed again")
+
+	idx, err = indexes.Open(source)
+	require.NoError(t, err)
+	assert.Equal(t, 2, idx.Count([]byte("TENOFF")))
+	require.NoError(t, idx.Close())
+}
+
+func TestIndexOpenErrors(t *testing.T) {
+	testcases := map[string]struct {
+		corrupt       func(t *testing.T, dir string)
+		expectedError error
+	}{
+		"Never indexed": {
+			corrupt: func(t *testing.T, dir string) {
+				t.Helper()
+				require.NoError(t, os.RemoveAll(dir))
+				require.NoError(t, os.Mkdir(dir, 0o750))
+			},
+			expectedError: promotion.ErrIndexMissing,
+		},
+		"Truncated": {
+			corrupt: func(t *testing.T, dir string) {
+				t.Helper()
+				rewriteIndex(t, dir, func(data []byte) []byte { return data[:len(data)-3] })
+			},
+			expectedError: promotion.ErrIndexCorrupt,
+		},
+		"Too short for a header": {
+			corrupt: func(t *testing.T, dir string) {
+				t.Helper()
+				rewriteIndex(t, dir, func(data []byte) []byte { return data[:10] })
+			},
+			expectedError: promotion.ErrIndexCorrupt,
+		},
+		"Not an index": {
+			corrupt: func(t *testing.T, dir string) {
+				t.Helper()
+				rewriteIndex(t, dir, func(data []byte) []byte {
+					copy(data, "NOTINDEX")
+					return data
+				})
+			},
+			expectedError: promotion.ErrIndexCorrupt,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join("testdata", "coupons.txt")
+
+			indexes, err := promotion.NewIndexDir(dir, promotion.NormaliseNone)
+			require.NoError(t, err)
+
+			_, err = indexes.Build(source)
+			require.NoError(t, err)
+
+			tc.corrupt(t, dir)
+
+			_, actualError := indexes.Open(source)
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+		})
+	}
+}
+
+// rewriteIndex replaces the single index in dir with the output of change.
+func rewriteIndex(t *testing.T, dir string, change func([]byte) []byte) {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.idx"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	data, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(matches[0], change(data), 0o600))
+}
+
+func TestIsValidBatchUsesIndex(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\nTENOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("FIFTYOFF\n"), 0o600))
+
+	indexes, err := promotion.NewIndexDir(filepath.Join(dir, "index"), promotion.DefaultNormalisation)
+	require.NoError(t, err)
+
+	_, err = indexes.Build(first)
+	require.NoError(t, err)
+
+	// The second file has no index, so it is scanned.
+	store := newStubStore()
+	search, err := promotion.NewSearch(store, promotion.WithIndexDir(indexes))
+	require.NoError(t, err)
+
+	results := valid(t, search, []string{"FIFTYOFF", "TENOFF"}, []string{first, second})
+
+	assert.Equal(t, map[string]bool{"FIFTYOFF": true, "TENOFF": false}, results)
+	assert.Equal(t, map[string]int{"FIFTYOFF": 2, "TENOFF": 1}, store.counts(t, first, second))
+}
@@ -0,0 +1,223 @@
+package promotion
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrUnknownNormalisation is returned when parsing a normalisation that does
+// not exist.
+var ErrUnknownNormalisation = errors.New("unknown normalisation")
+
+// Normalisation is the set of changes made to each line of a coupon file
+// before it is compared with the codes. Codes are always upper cased, and
+// have the same changes made.
+type Normalisation uint8
+
+// The changes that can be made to lines.
+const (
+	// NormaliseCase upper cases lines, so codes match whatever their case in
+	// the file.
+	NormaliseCase Normalisation = 1 << iota
+	// NormaliseCRLF removes the carriage return from lines ending in CRLF.
+	NormaliseCRLF
+	// NormaliseSpace trims leading and trailing white space.
+	NormaliseSpace
+	// NormaliseNFKC applies Unicode NFKC normalisation, so that eg. full
+	// width characters match their ASCII equivalents.
+	NormaliseNFKC
+)
+
+// NormaliseNone matches lines exactly.
+const NormaliseNone Normalisation = 0
+
+// DefaultNormalisation is every change that does not need Unicode tables.
+const DefaultNormalisation = NormaliseCase | NormaliseCRLF | NormaliseSpace
+
+var normalisationNames = []struct {
+	normalisation Normalisation
+	name          string
+}{
+	{NormaliseCase, "case"},
+	{NormaliseCRLF, "crlf"},
+	{NormaliseSpace, "space"},
+	{NormaliseNFKC, "nfkc"},
+}
+
+// String returns the names of the changes, comma separated, or "none".
+func (n Normalisation) String() string {
+	names := []string{}
+
+	for _, entry := range normalisationNames {
+		if n&entry.normalisation != 0 {
+			names = append(names, entry.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// ParseNormalisation parses the comma separated names of the changes, as
+// returned by String.
+func ParseNormalisation(value string) (Normalisation, error) {
+	normalisation := NormaliseNone
+
+	for name := range strings.SplitSeq(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "none" || name == "" {
+			continue
+		}
+
+		found := false
+
+		for _, entry := range normalisationNames {
+			if entry.name == name {
+				normalisation |= entry.normalisation
+				found = true
+			}
+		}
+
+		if !found {
+			return NormaliseNone, fmt.Errorf("%w %q", ErrUnknownNormalisation, name)
+		}
+	}
+
+	return normalisation, nil
+}
+
+// normalisePattern makes the same changes to a code as are made to lines, and
+// upper cases it.
+func (n Normalisation) normalisePattern(pattern string) []byte {
+	var normaliser lineNormaliser
+
+	normaliser.normalisation = n | NormaliseCase
+	line, _ := normaliser.normalise([]byte(strings.ToUpper(pattern)))
+
+	return bytes.Clone(line)
+}
+
+// lineNormaliser applies a Normalisation to lines. It is not safe for
+// concurrent use, each goroutine needs its own.
+//
+// Lines that are already normal (eg. upper case codes with LF line endings)
+// are returned as they are, so the common case does not copy. Changed lines
+// are written to a buffer that is reused, so they do not allocate either.
+type lineNormaliser struct {
+	normalisation Normalisation
+	buf           []byte
+}
+
+// normalise returns the normalised line, which is valid until the next call.
+// copied is false when the result is a sub slice of the line.
+func (ln *lineNormaliser) normalise(line []byte) (normalised []byte, copied bool) {
+	if ln.normalisation == NormaliseNone {
+		return line, false
+	}
+
+	if ln.normalisation&NormaliseNFKC != 0 && !isASCII(line) && !norm.NFKC.IsNormal(line) {
+		ln.buf = norm.NFKC.Append(ln.buf[:0], line...)
+		line = ln.buf
+		copied = true
+	}
+
+	if ln.normalisation&NormaliseCRLF != 0 && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	if ln.normalisation&NormaliseSpace != 0 {
+		line = bytes.TrimSpace(line)
+	}
+
+	if ln.normalisation&NormaliseCase != 0 {
+		switch needsUpper(line) {
+		case upperASCII:
+			if !copied {
+				ln.buf = append(ln.buf[:0], line...)
+				line = ln.buf
+				copied = true
+			}
+
+			for i, b := range line {
+				if 'a' <= b && b <= 'z' {
+					line[i] = b - 'a' + 'A'
+				}
+			}
+		case upperUnicode:
+			// Rare enough that the allocation does not matter.
+			line = bytes.ToUpper(line)
+			copied = true
+		case upperNone:
+		}
+	}
+
+	return line, copied
+}
+
+type upperNeed int
+
+const (
+	upperNone upperNeed = iota
+	upperASCII
+	upperUnicode
+)
+
+// needsUpper reports whether upper casing would change the line, and whether
+// it can be done in place.
+// Lines are checked 8 bytes at a time, this is on the hot path of every
+// search.
+func needsUpper(line []byte) upperNeed {
+	const (
+		ones  = 0x0101010101010101
+		highs = 0x8080808080808080
+	)
+
+	need := upperNone
+	i := 0
+
+	for ; i+8 <= len(line); i += 8 {
+		word := binary.LittleEndian.Uint64(line[i:])
+		if word&highs != 0 {
+			return upperUnicode
+		}
+
+		// With every byte below 0x80, the high bit of each byte of
+		// aboveA is set for bytes >= 'a', and of aboveZ for bytes > 'z'.
+		aboveA := word + (0x80-'a')*ones
+		aboveZ := word + (0x80-'z'-1)*ones
+
+		if aboveA&^aboveZ&highs != 0 {
+			need = upperASCII
+		}
+	}
+
+	for _, b := range line[i:] {
+		switch {
+		case b >= utf8.RuneSelf:
+			return upperUnicode
+		case 'a' <= b && b <= 'z':
+			need = upperASCII
+		}
+	}
+
+	return need
+}
+
+func isASCII(line []byte) bool {
+	for _, b := range line {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
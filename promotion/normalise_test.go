@@ -0,0 +1,158 @@
+package promotion_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNormalisation(t *testing.T) {
+	testcases := map[string]struct {
+		value         string
+		expected      promotion.Normalisation
+		expectedError error
+	}{
+		"None":          {value: "none", expected: promotion.NormaliseNone},
+		"Empty":         {value: "", expected: promotion.NormaliseNone},
+		"Default":       {value: "case,crlf,space", expected: promotion.DefaultNormalisation},
+		"Any order":     {value: "space, case", expected: promotion.NormaliseSpace | promotion.NormaliseCase},
+		"NFKC":          {value: "nfkc", expected: promotion.NormaliseNFKC},
+		"Unknown":       {value: "case,lower", expectedError: promotion.ErrUnknownNormalisation},
+		"Round trip ok": {value: promotion.DefaultNormalisation.String(), expected: promotion.DefaultNormalisation},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actual, actualError := promotion.ParseNormalisation(tc.value)
+			if tc.expectedError != nil {
+				assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+				return
+			}
+
+			require.NoError(t, actualError)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	assert.Equal(t, "none", promotion.NormaliseNone.String())
+}
+
+func TestScannerNormalisation(t *testing.T) {
+	content := "FIFTYOFF\n" +
+		"fiftyoff\n" +
+		"FIFTYOFF\r\n" +
+		"  FIFTYOFF\t\n" +
+		"ＦＩＦＴＹＯＦＦ\n" + // Full width.
+		"ﬁFTYOFF\n" + // "fi" ligature.
+		"TENOFF"
+
+	path := filepath.Join(t.TempDir(), "coupons.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	testcases := map[string]struct {
+		normalisation promotion.Normalisation
+		expected      int
+	}{
+		"Exact":            {normalisation: promotion.NormaliseNone, expected: 1},
+		"Case":             {normalisation: promotion.NormaliseCase, expected: 2},
+		"CRLF":             {normalisation: promotion.NormaliseCRLF, expected: 2},
+		"Space":            {normalisation: promotion.NormaliseSpace, expected: 3},
+		"Default":          {normalisation: promotion.DefaultNormalisation, expected: 4},
+		"Default and NFKC": {normalisation: promotion.DefaultNormalisation | promotion.NormaliseNFKC, expected: 6},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			scanner := promotion.Scanner{Normalisation: tc.normalisation}
+
+			actual, err := scanner.SearchFile(t.Context(), path, []string{"fiftyoff", " TENOFF "})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expected, actual["FIFTYOFF"].Count)
+			assert.Equal(t, 1, actual["FIFTYOFF"].FirstLine)
+
+			// Patterns get the same normalisation as the lines.
+			tenOff := 0
+			if tc.normalisation&promotion.NormaliseSpace != 0 {
+				tenOff = 1
+			}
+
+			assert.Equal(t, tenOff, actual[" TENOFF "].Count)
+
+			// The index gives the same counts.
+			indexes, err := promotion.NewIndexDir(t.TempDir(), tc.normalisation)
+			require.NoError(t, err)
+
+			_, err = indexes.Build(path)
+			require.NoError(t, err)
+
+			search, err := promotion.NewSearch(newStubStore(),
+				promotion.WithIndexDir(indexes),
+				promotion.WithNormalisation(tc.normalisation),
+				promotion.WithRules(promotion.Rules{MinFileCount: 1}),
+			)
+			require.NoError(t, err)
+
+			results, err := search.IsValidBatch(t.Context(), []string{"fiftyoff"}, []string{path})
+			require.NoError(t, err)
+			assert.Equal(t, 1, results["fiftyoff"].FileCount)
+		})
+	}
+}
+
+func TestIndexNormalisationMismatch(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join("testdata", "coupons.txt")
+
+	exact, err := promotion.NewIndexDir(dir, promotion.NormaliseNone)
+	require.NoError(t, err)
+
+	_, err = exact.Build(source)
+	require.NoError(t, err)
+
+	normalised, err := promotion.NewIndexDir(dir, promotion.DefaultNormalisation)
+	require.NoError(t, err)
+
+	_, err = normalised.Open(source)
+	require.ErrorIs(t, err, promotion.ErrIndexStale)
+
+	rebuilt, err := normalised.Build(source)
+	require.NoError(t, err)
+	assert.True(t, rebuilt, "an index with a different normalisation is rebuilt")
+
+	idx, err := normalised.Open(source)
+	require.NoError(t, err)
+
+	defer idx.Close()
+
+	assert.Equal(t, promotion.DefaultNormalisation, idx.Normalisation)
+}
+
+// TestScannerCaseFolding checks lower case letters are found wherever they are
+// in the line, and the characters either side of a-z are left alone.
+func TestScannerCaseFolding(t *testing.T) {
+	testcases := map[string]struct {
+		line    string
+		pattern string
+	}{
+		"Short line":                {line: "abc", pattern: "ABC"},
+		"Lower case in first word":  {line: "ABCdEFGHIJ", pattern: "ABCDEFGHIJ"},
+		"Lower case after the word": {line: "ABCDEFGHIj", pattern: "ABCDEFGHIJ"},
+		"Second word":               {line: "ABCDEFGHIJKLMNOp", pattern: "ABCDEFGHIJKLMNOP"},
+		"Either side of a-z":        {line: "`{@[`{@[`{", pattern: "`{@[`{@[`{"},
+		"Unicode":                   {line: "straßeé", pattern: "STRAßEÉ"},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "coupons.txt")
+			require.NoError(t, os.WriteFile(path, []byte(tc.line+"\n"), 0o600))
+
+			actual, err := promotion.Scanner{Normalisation: promotion.NormaliseCase}.SearchFile(t.Context(), path, []string{tc.pattern})
+			require.NoError(t, err)
+
+			assert.Equal(t, 1, actual[tc.pattern].Count)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package promotion
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxPatternLine is the longest line read from a patterns file, far longer
+// than any code.
+const maxPatternLine = 64 << 10
+
+// ErrInvalidPatterns is returned when a patterns file cannot be read.
+var ErrInvalidPatterns = errors.New("invalid patterns")
+
+// ReadPatterns reads one code per line, with surrounding whitespace trimmed.
+// Blank lines are skipped.
+func ReadPatterns(reader io.Reader) ([]string, error) {
+	patterns := []string{}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxPatternLine)
+
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		if pattern := strings.TrimSpace(scanner.Text()); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("%w, line %d is longer than %d bytes", ErrInvalidPatterns, line+1, maxPatternLine)
+		}
+
+		return nil, fmt.Errorf("reading patterns: %w", err)
+	}
+
+	return patterns, nil
+}
+
+// UniquePatterns returns the patterns without duplicates, keeping the first
+// of each in order. Patterns are case insensitive, so "fiftyoff" is a
+// duplicate of "FIFTYOFF".
+func UniquePatterns(patterns []string) []string {
+	seen := make(map[string]bool, len(patterns))
+	unique := make([]string, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		code := strings.ToUpper(pattern)
+		if seen[code] {
+			continue
+		}
+
+		seen[code] = true
+		unique = append(unique, pattern)
+	}
+
+	return unique
+}
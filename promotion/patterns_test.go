@@ -0,0 +1,47 @@
+package promotion_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPatterns(t *testing.T) {
+	testcases := map[string]struct {
+		input         string
+		expected      []string
+		expectedError error
+	}{
+		"One code per line": {
+			input:    "FIFTYOFF\nHAPPYHRS\n",
+			expected: []string{"FIFTYOFF", "HAPPYHRS"},
+		},
+		"Whitespace and blank lines": {
+			input:    "  FIFTYOFF\r\n\n\t\nHAPPYHRS",
+			expected: []string{"FIFTYOFF", "HAPPYHRS"},
+		},
+		"Empty": {
+			expected: []string{},
+		},
+		"Line too long": {
+			input:         "FIFTYOFF\n" + strings.Repeat("A", 65<<10) + "\n",
+			expectedError: promotion.ErrInvalidPatterns,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actual, actualError := promotion.ReadPatterns(strings.NewReader(tc.input))
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestUniquePatterns(t *testing.T) {
+	actual := promotion.UniquePatterns([]string{"fiftyoff", "HAPPYHRS", "FIFTYOFF", "happyhrs", "TENOFF"})
+
+	assert.Equal(t, []string{"fiftyoff", "HAPPYHRS", "TENOFF"}, actual)
+}
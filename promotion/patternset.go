@@ -0,0 +1,54 @@
+package promotion
+
+// patternSet answers "which pattern is this line" with a single hash lookup,
+// so scanning a chunk costs the same whether there is one pattern or tens of
+// thousands.
+type patternSet struct {
+	// index maps a pattern to its position in patterns.
+	index    map[string]int
+	patterns []string
+	// Lines outside the pattern lengths cannot match, and skip hashing.
+	minLen, maxLen int
+}
+
+func newPatternSet(patterns [][]byte) *patternSet {
+	set := &patternSet{
+		index:    make(map[string]int, len(patterns)),
+		patterns: make([]string, 0, len(patterns)),
+		minLen:   -1,
+	}
+
+	for _, pattern := range patterns {
+		// Duplicate patterns are counted once.
+		if _, exists := set.index[string(pattern)]; exists {
+			continue
+		}
+
+		set.index[string(pattern)] = len(set.patterns)
+		set.patterns = append(set.patterns, string(pattern))
+
+		if set.minLen == -1 || len(pattern) < set.minLen {
+			set.minLen = len(pattern)
+		}
+
+		set.maxLen = max(set.maxLen, len(pattern))
+	}
+
+	return set
+}
+
+// find returns the position of the line in the set, or -1 if it is not a
+// pattern.
+func (ps *patternSet) find(line []byte) int {
+	if len(line) < ps.minLen || len(line) > ps.maxLen {
+		return -1
+	}
+
+	// The compiler does not allocate for a string conversion used only as a
+	// map key.
+	if i, ok := ps.index[string(line)]; ok {
+		return i
+	}
+
+	return -1
+}
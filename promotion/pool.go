@@ -0,0 +1,196 @@
+package promotion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// ErrInvalidLimits is returned for limits that are negative.
+var ErrInvalidLimits = errors.New("invalid search limits")
+
+// Limits bound the resources used by the searches that share a Pool.
+type Limits struct {
+	// Workers is the number of chunks searched at once, across every file.
+	// The default is GOMAXPROCS.
+	Workers int
+	// MaxFiles is the number of files searched at once. Zero is no limit.
+	MaxFiles int
+	// MaxMappedBytes is the total size of the files that are mmapped at once.
+	// A file is only mapped once its size fits in what is left, files larger
+	// than the limit are read with pread instead. Zero is no limit.
+	MaxMappedBytes int64
+}
+
+// Pool is a worker pool that is shared by searches, so that searching many
+// files does not start GOMAXPROCS goroutines, and map the whole file, for
+// every file at once.
+//
+// A nil *Pool has no limits, each file is searched by GOMAXPROCS goroutines of
+// its own.
+type Pool struct {
+	workers chan struct{}
+	files   chan struct{}
+	mapped  *weightedSemaphore
+}
+
+// NewPool returns a pool enforcing the limits.
+func NewPool(limits Limits) (*Pool, error) {
+	if limits.Workers < 0 || limits.MaxFiles < 0 || limits.MaxMappedBytes < 0 {
+		return nil, fmt.Errorf("%w, workers %d, max files %d, max mapped bytes %d",
+			ErrInvalidLimits, limits.Workers, limits.MaxFiles, limits.MaxMappedBytes)
+	}
+
+	workers := limits.Workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	pool := &Pool{workers: make(chan struct{}, workers)}
+
+	if limits.MaxFiles > 0 {
+		pool.files = make(chan struct{}, limits.MaxFiles)
+	}
+
+	if limits.MaxMappedBytes > 0 {
+		pool.mapped = newWeightedSemaphore(limits.MaxMappedBytes)
+	}
+
+	return pool, nil
+}
+
+// numWorkers is the number of chunks searched at once, which is also the
+// number of chunks a file is split into.
+func (p *Pool) numWorkers() int {
+	if p == nil {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	return cap(p.workers)
+}
+
+// goChunk runs the task on a worker, waiting for a worker to be free. It
+// reports false, and the task is not run, if the context is done first.
+func (p *Pool) goChunk(ctx context.Context, wg *sync.WaitGroup, task func()) bool {
+	if p == nil {
+		if ctx.Err() != nil {
+			return false
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			task()
+		}()
+
+		return true
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		defer func() { <-p.workers }()
+
+		task()
+	}()
+
+	return true
+}
+
+// acquireFile waits until another file can be searched, the returned func
+// releases it. It returns the context's error if the context is done first.
+func (p *Pool) acquireFile(ctx context.Context) (func(), error) {
+	if p == nil || p.files == nil {
+		return func() {}, ctx.Err()
+	}
+
+	select {
+	case p.files <- struct{}{}:
+		return func() { <-p.files }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireMapped waits until size bytes can be mapped, the returned func
+// releases them. It reports false, without waiting, if the file could never
+// be mapped within the limit, and returns the context's error if the context
+// is done first.
+func (p *Pool) acquireMapped(ctx context.Context, size int64) (func(), bool, error) {
+	if p == nil || p.mapped == nil {
+		return func() {}, true, ctx.Err()
+	}
+
+	if size > p.mapped.size {
+		return nil, false, ctx.Err()
+	}
+
+	if err := p.mapped.acquire(ctx, size); err != nil {
+		return nil, true, err
+	}
+
+	return func() { p.mapped.release(size) }, true, nil
+}
+
+// weightedSemaphore is a counting semaphore where each acquire takes a
+// number of units.
+type weightedSemaphore struct {
+	size int64
+	used int64
+	mu   sync.Mutex
+	cond *sync.Cond
+}
+
+func newWeightedSemaphore(size int64) *weightedSemaphore {
+	sem := &weightedSemaphore{size: size}
+	sem.cond = sync.NewCond(&sem.mu)
+
+	return sem
+}
+
+// acquire waits until n units are free, n must not be larger than the size.
+// It returns the context's error if the context is done first.
+func (s *weightedSemaphore) acquire(ctx context.Context, n int64) error {
+	// Wake the waiters when the context is done, so that they can give up.
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.cond.Broadcast()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.used+n > s.size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		s.cond.Wait()
+	}
+
+	s.used += n
+
+	return nil
+}
+
+func (s *weightedSemaphore) release(n int64) {
+	s.mu.Lock()
+	s.used -= n
+	s.mu.Unlock()
+
+	s.cond.Broadcast()
+}
@@ -0,0 +1,103 @@
+package promotion_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPool(t *testing.T) {
+	testcases := map[string]struct {
+		limits        promotion.Limits
+		expectedError error
+	}{
+		"No limits":          {},
+		"All limits":         {limits: promotion.Limits{Workers: 2, MaxFiles: 3, MaxMappedBytes: 1 << 20}},
+		"Negative workers":   {limits: promotion.Limits{Workers: -1}, expectedError: promotion.ErrInvalidLimits},
+		"Negative max files": {limits: promotion.Limits{MaxFiles: -1}, expectedError: promotion.ErrInvalidLimits},
+		"Negative max mapped": {
+			limits:        promotion.Limits{MaxMappedBytes: -1},
+			expectedError: promotion.ErrInvalidLimits,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			pool, actualError := promotion.NewPool(tc.limits)
+
+			if tc.expectedError != nil {
+				assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+				return
+			}
+
+			require.NoError(t, actualError)
+			assert.NotNil(t, pool)
+		})
+	}
+}
+
+// TestPoolLimits checks that a shared pool, with tight limits, finds the same
+// results as searching without limits, including files too large to be
+// mapped.
+func TestPoolLimits(t *testing.T) {
+	dir := t.TempDir()
+	files := make([]string, 0, 20)
+
+	for i := range cap(files) {
+		lines := 1_000
+		if i%5 == 0 {
+			// Larger than MaxMappedBytes, read with pread instead.
+			lines = 600_000
+		}
+
+		path := writeSyntheticCoupons(t, dir, lines)
+		renamed := filepath.Join(dir, fmt.Sprintf("coupons-%d.txt", i))
+		require.NoError(t, os.Rename(path, renamed))
+
+		files = append(files, renamed)
+	}
+
+	files = append(files, filepath.Join("testdata", "coupons.gz"))
+	patterns := []string{syntheticCode(0), syntheticCode(999), syntheticCode(599_999), "FIFTYOFF", "MISSING"}
+
+	expected, err := promotion.Scanner{}.Report(t.Context(), patterns, files)
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		limits promotion.Limits
+	}{
+		"One of everything": {limits: promotion.Limits{Workers: 1, MaxFiles: 1, MaxMappedBytes: 1 << 20}},
+		"Few files":         {limits: promotion.Limits{Workers: 4, MaxFiles: 2, MaxMappedBytes: 4 << 20}},
+		"Workers only":      {limits: promotion.Limits{Workers: 3}},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			pool, err := promotion.NewPool(tc.limits)
+			require.NoError(t, err)
+
+			for _, strategy := range readStrategies {
+				actual, err := promotion.Scanner{Reader: strategy, Pool: pool}.Report(t.Context(), patterns, files)
+
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual, strategy.String())
+			}
+
+			search, err := promotion.NewSearch(
+				newStubStore(), promotion.WithPool(pool), promotion.WithCacheMode(promotion.CacheOff),
+			)
+			require.NoError(t, err)
+
+			results, err := search.IsValidBatch(t.Context(), patterns, files)
+			require.NoError(t, err)
+
+			assert.Equal(t, len(files)-1, results[syntheticCode(0)].FileCount)
+			assert.Equal(t, 4, results[syntheticCode(599_999)].FileCount)
+			assert.Equal(t, 1, results["FIFTYOFF"].FileCount)
+			assert.False(t, results["MISSING"].Valid)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package promotion
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// preadExtendSize is how much more is read, at a time, to finish a line that
+// runs past the end of a block.
+const preadExtendSize = 64 << 10
+
+// searchPread searches a regular file by reading blocks of it with pread(2)
+// (ReadAt), on the pool's workers. Unlike mmap, a read that blocks only
+// parks the goroutine, and the memory used is bounded by the number of
+// workers, so it suits files on network file systems.
+//
+// Each block searches the lines that start in it, reading on past its end to
+// finish the last line.
+func searchPread(
+	ctx context.Context, f *os.File, size int64, patterns *patternSet, normalisation Normalisation, pool *Pool,
+	progress *fileProgress,
+) (map[string]Match, error) {
+	numBlocks := int((size + streamBlockSize - 1) / streamBlockSize)
+
+	chunks := make([]chunkResult, numBlocks)
+	errs := make([]error, numBlocks)
+
+	// The buffers are reused for every block, which bounds the memory used
+	// to roughly numWorkers * streamBlockSize.
+	free := make(chan []byte, min(pool.numWorkers(), numBlocks))
+	for range cap(free) {
+		free <- make([]byte, 0, streamBlockSize+1)
+	}
+
+	var wg sync.WaitGroup
+
+	for block := range numBlocks {
+		var buf []byte
+
+		select {
+		case buf = <-free:
+		case <-ctx.Done():
+		}
+
+		if buf == nil || !pool.goChunk(ctx, &wg, func() {
+			data, start, err := readBlock(f, size, int64(block), buf)
+			if err == nil {
+				chunks[block] = scanChunk(ctx, data[start:], patterns, normalisation, progress)
+			}
+
+			errs[block] = err
+			free <- data[:0]
+		}) {
+			break
+		}
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return combineChunks(chunks), nil
+}
+
+// readBlock reads the block into buf, and returns it with the offset of the
+// first line that starts in the block, data[start:] is every line that starts
+// in the block.
+// A line starts in the block when the byte before it, which may be the last
+// byte of the previous block, is a newline. So the read starts one byte early.
+func readBlock(f *os.File, size, block int64, buf []byte) (data []byte, start int, err error) {
+	blockStart := block * streamBlockSize
+	blockEnd := min(blockStart+streamBlockSize, size)
+	readStart := max(blockStart-1, 0)
+
+	data, err = readAt(f, buf[:0], readStart, blockEnd-readStart)
+	if err != nil {
+		return data, 0, err
+	}
+
+	if block > 0 {
+		newline := bytes.IndexByte(data, '\n')
+		if newline == -1 {
+			// The block is the middle of a line that started earlier.
+			return data, len(data), nil
+		}
+
+		start = newline + 1
+	}
+
+	// Finish the last line.
+	for end := blockEnd; end < size && data[len(data)-1] != '\n'; {
+		more := len(data)
+
+		data, err = readAt(f, data, end, min(preadExtendSize, size-end))
+		if err != nil {
+			return data, 0, err
+		}
+
+		if newline := bytes.IndexByte(data[more:], '\n'); newline != -1 {
+			data = data[:more+newline+1]
+			break
+		}
+
+		end += int64(len(data) - more)
+	}
+
+	return data, start, nil
+}
+
+// readAt appends length bytes, read from the offset, to buf.
+func readAt(f *os.File, buf []byte, offset, length int64) ([]byte, error) {
+	filled := len(buf)
+	buf = slices.Grow(buf, int(length))[:filled+int(length)]
+
+	n, err := f.ReadAt(buf[filled:], offset)
+	if err != nil && (!errors.Is(err, io.EOF) || int64(n) != length) {
+		return buf[:filled+n], fmt.Errorf("failed to read file at %d: %w", offset, err)
+	}
+
+	return buf, nil
+}
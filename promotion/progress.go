@@ -0,0 +1,198 @@
+package promotion
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressInterval is the least time between progress events, apart from the
+// event when a file is finished.
+const progressInterval = 100 * time.Millisecond
+
+// Progress is a snapshot of how far a search has got, see Scanner.Progress.
+// Sizes are the bytes read from the files, so a compressed file is measured
+// by its compressed size.
+type Progress struct {
+	// File is the file the event is about.
+	File string
+	// FileScanned is how much of the file has been read.
+	FileScanned int64
+	// FileSize is the size of the file, or -1 when it is unknown, eg. stdin.
+	FileSize int64
+	// FileDone is set, on the last event for the file, once it has been
+	// searched.
+	FileDone bool
+	// FilesDone, of Files, is how many files have been searched.
+	FilesDone int
+	Files     int
+	// Scanned is how much of all the files has been read.
+	Scanned int64
+	// Total is the size of all the files, or -1 when any size is unknown.
+	Total int64
+	// Elapsed is the time since the search started.
+	Elapsed time.Duration
+	// Throughput is the bytes read per second.
+	Throughput float64
+	// ETA is the estimated time left, or -1 when it cannot be estimated.
+	ETA time.Duration
+}
+
+// progressTracker publishes the progress of the search of a set of files.
+// The events are published one at a time, from whichever goroutine made the
+// progress, so the func must not block for long.
+type progressTracker struct {
+	publish func(Progress)
+	start   time.Time
+
+	mu        sync.Mutex
+	last      time.Time
+	scanned   int64
+	total     int64
+	filesDone int
+	files     []*fileProgress
+}
+
+// newProgressTracker returns a tracker for the files, or nil if there is
+// nothing to publish to.
+func newProgressTracker(publish func(Progress), files []string) *progressTracker {
+	if publish == nil {
+		return nil
+	}
+
+	tracker := &progressTracker{
+		publish: publish,
+		start:   time.Now(),
+		files:   make([]*fileProgress, 0, len(files)),
+	}
+
+	for _, file := range files {
+		size := int64(-1)
+
+		if file != StdinPath {
+			if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() {
+				size = info.Size()
+			}
+		}
+
+		if size < 0 || tracker.total < 0 {
+			tracker.total = -1
+		} else {
+			tracker.total += size
+		}
+
+		tracker.files = append(tracker.files, &fileProgress{tracker: tracker, file: file, size: size})
+	}
+
+	return tracker
+}
+
+// file returns the progress of the i'th file, nil is a valid *fileProgress
+// that publishes nothing.
+func (t *progressTracker) file(i int) *fileProgress {
+	if t == nil {
+		return nil
+	}
+
+	return t.files[i]
+}
+
+// fileProgress is the progress of one of the files.
+type fileProgress struct {
+	tracker *progressTracker
+	file    string
+	size    int64
+	scanned int64
+	done    bool
+}
+
+// add records n more bytes of the file as read.
+func (fp *fileProgress) add(n int64) {
+	if fp == nil || n == 0 {
+		return
+	}
+
+	t := fp.tracker
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fp.scanned += n
+	t.scanned += n
+
+	if now := time.Now(); now.Sub(t.last) >= progressInterval {
+		t.last = now
+		t.publish(t.snapshot(fp, now))
+	}
+}
+
+// finish records the file as searched. Anything that was not read, eg. a
+// file answered by its index, is counted as read.
+func (fp *fileProgress) finish() {
+	if fp == nil {
+		return
+	}
+
+	t := fp.tracker
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if fp.done {
+		return
+	}
+
+	if fp.size > fp.scanned {
+		t.scanned += fp.size - fp.scanned
+		fp.scanned = fp.size
+	}
+
+	fp.done = true
+	t.filesDone++
+
+	now := time.Now()
+	t.last = now
+	t.publish(t.snapshot(fp, now))
+}
+
+// snapshot is the progress, as of now, for an event about the file. The
+// tracker must be locked.
+func (t *progressTracker) snapshot(fp *fileProgress, now time.Time) Progress {
+	progress := Progress{
+		File:        fp.file,
+		FileScanned: fp.scanned,
+		FileSize:    fp.size,
+		FileDone:    fp.done,
+		FilesDone:   t.filesDone,
+		Files:       len(t.files),
+		Scanned:     t.scanned,
+		Total:       t.total,
+		Elapsed:     now.Sub(t.start),
+		ETA:         -1,
+	}
+
+	if seconds := progress.Elapsed.Seconds(); seconds > 0 {
+		progress.Throughput = float64(t.scanned) / seconds
+	}
+
+	if t.total >= 0 && progress.Throughput > 0 {
+		progress.ETA = time.Duration(float64(t.total-t.scanned) / progress.Throughput * float64(time.Second))
+	}
+
+	return progress
+}
+
+// progressReader records the bytes read through it as progress, for files
+// that are read as a stream.
+type progressReader struct {
+	reader   io.Reader
+	progress *fileProgress
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.progress.add(int64(n))
+
+	return n, err
+}
@@ -0,0 +1,180 @@
+package promotion_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// progressRecorder collects the progress events of a search.
+type progressRecorder struct {
+	mu     sync.Mutex
+	events []promotion.Progress
+}
+
+func (r *progressRecorder) publish(progress promotion.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, progress)
+}
+
+// last returns the last event, and checks that the bytes scanned never went
+// backwards.
+func (r *progressRecorder) last(t *testing.T) promotion.Progress {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	require.NotEmpty(t, r.events)
+
+	for i := 1; i < len(r.events); i++ {
+		assert.GreaterOrEqual(t, r.events[i].Scanned, r.events[i-1].Scanned)
+	}
+
+	return r.events[len(r.events)-1]
+}
+
+func TestSearchFileProgress(t *testing.T) {
+	path := writeSyntheticCoupons(t, t.TempDir(), 1_000_000)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	compressed := filepath.Join("testdata", "coupons.zst")
+	compressedInfo, err := os.Stat(compressed)
+	require.NoError(t, err)
+
+	testcases := map[string]struct {
+		file string
+		size int64
+	}{
+		"Plain text": {file: path, size: info.Size()},
+		"Compressed": {file: compressed, size: compressedInfo.Size()},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		for _, strategy := range readStrategies {
+			t.Run(name+"/"+strategy.String(), func(t *testing.T) {
+				recorder := &progressRecorder{}
+
+				_, err := promotion.Scanner{Reader: strategy, Progress: recorder.publish}.SearchFile(
+					t.Context(), tc.file, []string{"FIFTYOFF"},
+				)
+				require.NoError(t, err)
+
+				last := recorder.last(t)
+
+				assert.Equal(t, tc.file, last.File)
+				assert.True(t, last.FileDone)
+				assert.Equal(t, tc.size, last.FileSize)
+				assert.Equal(t, tc.size, last.FileScanned)
+				assert.Equal(t, tc.size, last.Scanned)
+				assert.Equal(t, tc.size, last.Total)
+				assert.Equal(t, 1, last.Files)
+				assert.Equal(t, 1, last.FilesDone)
+				assert.Zero(t, last.ETA)
+			})
+		}
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	files := []string{
+		writeSyntheticCoupons(t, t.TempDir(), 500_000),
+		filepath.Join("testdata", "coupons.txt"),
+		filepath.Join("testdata", "coupons.gz"),
+	}
+
+	total := int64(0)
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+
+		total += info.Size()
+	}
+
+	recorder := &progressRecorder{}
+
+	_, err := promotion.Scanner{Progress: recorder.publish}.Report(t.Context(), []string{"FIFTYOFF"}, files)
+	require.NoError(t, err)
+
+	last := recorder.last(t)
+
+	assert.Equal(t, total, last.Scanned)
+	assert.Equal(t, total, last.Total)
+	assert.Equal(t, len(files), last.FilesDone)
+	assert.Positive(t, last.Throughput)
+
+	done := map[string]bool{}
+
+	for _, event := range recorder.events {
+		if event.FileDone {
+			done[event.File] = true
+		}
+	}
+
+	assert.Len(t, done, len(files))
+}
+
+func TestProgressStdin(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+
+	stdin := os.Stdin
+	os.Stdin = reader
+
+	defer func() { os.Stdin = stdin }()
+
+	go func() {
+		defer writer.Close()
+
+		_, _ = writer.WriteString("FIFTYOFF\nTENOFF\n")
+	}()
+
+	recorder := &progressRecorder{}
+
+	_, err = promotion.Scanner{Progress: recorder.publish}.SearchFile(t.Context(), promotion.StdinPath, []string{"FIFTYOFF"})
+	require.NoError(t, err)
+
+	last := recorder.last(t)
+
+	// The size of stdin is not known up front, so there is no ETA.
+	assert.True(t, last.FileDone)
+	assert.Equal(t, int64(16), last.Scanned)
+	assert.Equal(t, int64(-1), last.Total)
+	assert.Equal(t, int64(-1), last.FileSize)
+	assert.Negative(t, last.ETA)
+}
+
+func TestIsValidBatchProgress(t *testing.T) {
+	dir := t.TempDir()
+	indexed := writeSyntheticCoupons(t, dir, 1_000)
+
+	indexes, err := promotion.NewIndexDir(filepath.Join(dir, "index"), promotion.DefaultNormalisation)
+	require.NoError(t, err)
+
+	_, err = indexes.Build(indexed)
+	require.NoError(t, err)
+
+	files := []string{indexed, filepath.Join("testdata", "coupons.txt")}
+	recorder := &progressRecorder{}
+
+	search, err := promotion.NewSearch(newStubStore(),
+		promotion.WithIndexDir(indexes), promotion.WithProgress(recorder.publish))
+	require.NoError(t, err)
+
+	_, err = search.IsValidBatch(t.Context(), []string{"FIFTYOFF"}, files)
+	require.NoError(t, err)
+
+	// The indexed file is answered without being read, but is still counted.
+	last := recorder.last(t)
+
+	assert.Equal(t, 2, last.FilesDone)
+	assert.Equal(t, last.Total, last.Scanned)
+}
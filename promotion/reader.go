@@ -0,0 +1,109 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// StdinPath is the file name that searches standard input.
+const StdinPath = "-"
+
+// ErrUnknownReadStrategy is returned when parsing a read strategy that does
+// not exist.
+var ErrUnknownReadStrategy = errors.New("unknown read strategy")
+
+// ErrReadStrategy is returned when the read strategy cannot read the file,
+// eg. a pipe cannot be mmapped.
+var ErrReadStrategy = errors.New("read strategy cannot be used")
+
+// ReadStrategy is how a file is read to be searched.
+type ReadStrategy int
+
+const (
+	// ReadAuto chooses mmap for regular files on local file systems, pread
+	// for regular files on network file systems, and stream for everything
+	// else (stdin, named pipes, devices).
+	ReadAuto ReadStrategy = iota
+	// ReadMmap maps the whole file into memory, and searches chunks of it
+	// concurrently. It is the fastest for local files, but page faults block
+	// OS threads, which is slow when the file is not in the page cache, and
+	// worse over a network.
+	ReadMmap
+	// ReadPread reads blocks of the file with pread(2), from a pool of
+	// workers. The Go runtime can schedule around the reads, and only the
+	// blocks being searched are held in memory.
+	ReadPread
+	// ReadStream reads the file from start to end, searching blocks
+	// concurrently. It is the only strategy that works for pipes.
+	ReadStream
+)
+
+var readStrategyNames = map[ReadStrategy]string{
+	ReadAuto:   "auto",
+	ReadMmap:   "mmap",
+	ReadPread:  "pread",
+	ReadStream: "stream",
+}
+
+func (r ReadStrategy) String() string {
+	if name, ok := readStrategyNames[r]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ReadStrategy(%d)", int(r))
+}
+
+// ParseReadStrategy parses the name of a read strategy, as returned by String.
+func ParseReadStrategy(name string) (ReadStrategy, error) {
+	for strategy, strategyName := range readStrategyNames {
+		if name == strategyName {
+			return strategy, nil
+		}
+	}
+
+	return ReadAuto, fmt.Errorf("%w %q", ErrUnknownReadStrategy, name)
+}
+
+// openSource opens the file, or returns standard input for StdinPath. The
+// returned function closes the file (but never standard input).
+func openSource(path string) (*os.File, func(), error) {
+	if path == StdinPath {
+		return os.Stdin, func() {}, nil
+	}
+
+	f, err := os.Open(path) // #nosec G304 -- Coupon files are chosen by the operator.
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return f, func() { _ = f.Close() }, nil
+}
+
+// resolve chooses the strategy for the file, and checks that a strategy that
+// was asked for can read it.
+func (r ReadStrategy) resolve(f *os.File, info os.FileInfo) (ReadStrategy, error) {
+	regular := info.Mode().IsRegular()
+
+	switch r {
+	case ReadAuto:
+		switch {
+		case !regular:
+			return ReadStream, nil
+		case isNetworkFileSystem(f):
+			return ReadPread, nil
+		default:
+			return ReadMmap, nil
+		}
+	case ReadMmap, ReadPread:
+		if !regular {
+			return r, fmt.Errorf("%w, %s needs a regular file, use stream", ErrReadStrategy, r)
+		}
+
+		return r, nil
+	case ReadStream:
+		return r, nil
+	default:
+		return r, fmt.Errorf("%w %s", ErrUnknownReadStrategy, r)
+	}
+}
@@ -0,0 +1,184 @@
+package promotion_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+var readStrategies = []promotion.ReadStrategy{
+	promotion.ReadAuto,
+	promotion.ReadMmap,
+	promotion.ReadPread,
+	promotion.ReadStream,
+}
+
+func TestParseReadStrategy(t *testing.T) {
+	for _, strategy := range readStrategies {
+		actual, err := promotion.ParseReadStrategy(strategy.String())
+		require.NoError(t, err)
+		assert.Equal(t, strategy, actual)
+	}
+
+	_, err := promotion.ParseReadStrategy("io_uring")
+	assert.ErrorIs(t, err, promotion.ErrUnknownReadStrategy)
+}
+
+// TestReadStrategies checks that every strategy finds the same matches,
+// including lines that straddle the blocks a file is read in, and a line that
+// is longer than a block.
+func TestReadStrategies(t *testing.T) {
+	var large bytes.Buffer
+
+	for i := range 600_000 {
+		fmt.Fprintf(&large, "CODE%07d\n", i)
+
+		if i%1000 == 0 {
+			large.WriteString("NEEDLE\n")
+		}
+	}
+
+	large.WriteString(strings.Repeat("X", 9<<20) + "\n")
+	large.WriteString("NEEDLE")
+
+	largePath := filepath.Join(t.TempDir(), "large.txt")
+	require.NoError(t, os.WriteFile(largePath, large.Bytes(), 0o600))
+
+	small := map[string]promotion.Match{"FIFTYOFF": {Count: 3, FirstLine: 1}, "TENOFF": {Count: 1, FirstLine: 5}}
+
+	testcases := map[string]struct {
+		file     string
+		patterns []string
+		expected map[string]promotion.Match
+	}{
+		"Plain text": {
+			file:     filepath.Join("testdata", "coupons.txt"),
+			patterns: []string{"FIFTYOFF", "TENOFF"},
+			expected: small,
+		},
+		"Compressed": {
+			file:     filepath.Join("testdata", "coupons.xz"),
+			patterns: []string{"FIFTYOFF", "TENOFF"},
+			expected: small,
+		},
+		"Lines across blocks": {
+			file:     largePath,
+			patterns: []string{"NEEDLE", "CODE0599999", "CODE0349525"},
+			expected: map[string]promotion.Match{
+				"NEEDLE":      {Count: 601, FirstLine: 2},
+				"CODE0599999": {Count: 1, FirstLine: 600_600},
+				"CODE0349525": {Count: 1, FirstLine: 349_876},
+			},
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		for _, strategy := range readStrategies {
+			t.Run(name+"/"+strategy.String(), func(t *testing.T) {
+				actual, err := promotion.Scanner{Reader: strategy}.SearchFile(t.Context(), tc.file, tc.patterns)
+
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			})
+		}
+	}
+}
+
+func TestReadStrategyStdin(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+
+	stdin := os.Stdin
+	os.Stdin = reader
+
+	defer func() { os.Stdin = stdin }()
+
+	go func() {
+		defer writer.Close()
+
+		_, _ = writer.WriteString("FIFTYOFF\nTENOFF\nFIFTYOFF\n")
+	}()
+
+	actual, err := promotion.Scanner{}.SearchFile(t.Context(), promotion.StdinPath, []string{"FIFTYOFF"})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]promotion.Match{"FIFTYOFF": {Count: 2, FirstLine: 1}}, actual)
+
+	_, err = promotion.FileSetFingerprint([]string{promotion.StdinPath})
+	assert.ErrorIs(t, err, promotion.ErrUncacheable)
+}
+
+func TestReadStrategyNamedPipe(t *testing.T) {
+	testcases := map[string]struct {
+		strategy      promotion.ReadStrategy
+		expectedError error
+	}{
+		"Auto":   {strategy: promotion.ReadAuto},
+		"Stream": {strategy: promotion.ReadStream},
+		"Mmap":   {strategy: promotion.ReadMmap, expectedError: promotion.ErrReadStrategy},
+		"Pread":  {strategy: promotion.ReadPread, expectedError: promotion.ErrReadStrategy},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "coupons.fifo")
+			require.NoError(t, unix.Mkfifo(path, 0o600))
+
+			written := make(chan struct{})
+
+			go func() {
+				defer close(written)
+
+				writer, err := os.OpenFile(path, os.O_WRONLY, 0)
+				if err != nil {
+					return
+				}
+				defer writer.Close()
+
+				_, _ = writer.WriteString("FIFTYOFF\n")
+			}()
+
+			actual, actualError := promotion.Scanner{Reader: tc.strategy}.SearchFile(t.Context(), path, []string{"FIFTYOFF"})
+			<-written
+
+			if tc.expectedError != nil {
+				assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+
+				return
+			}
+
+			require.NoError(t, actualError)
+			assert.Equal(t, 1, actual["FIFTYOFF"].Count)
+		})
+	}
+}
+
+// BenchmarkReadStrategy compares the strategies on a local file, where mmap
+// is expected to be fastest.
+func BenchmarkReadStrategy(b *testing.B) {
+	const lines = 64 << 20 / 12
+
+	path := writeSyntheticCoupons(b, b.TempDir(), lines)
+	size := syntheticFileSize(b, path)
+	patterns := []string{syntheticCode(1), syntheticCode(lines / 2)}
+
+	for _, strategy := range readStrategies[1:] {
+		scanner := promotion.Scanner{Reader: strategy}
+
+		b.Run(strategy.String(), func(b *testing.B) {
+			b.SetBytes(size)
+
+			for b.Loop() {
+				if _, err := scanner.SearchFile(b.Context(), path, patterns); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shanehowearth/kart/internal/validation"
+)
+
+// Redemption errors. A redemption refused because of the code's limits wraps
+// one of ErrCouponNotActive, ErrCouponUsedUp, ErrCustomerRequired, or
+// ErrCustomerLimit.
+
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrInvalidCouponLimits = errors.New("invalid coupon limits")
+	ErrInvalidRedemption   = errors.New("invalid redemption")
+	ErrCouponNotActive     = errors.New("coupon is not active")
+	ErrCouponUsedUp        = errors.New("coupon has been used up")
+	ErrCustomerRequired    = errors.New("coupon can only be used by a known customer")
+	ErrCustomerLimit       = errors.New("coupon has been used up by the customer")
+)
+
+// CouponLimits restrict how often, and when, a code can be redeemed. The zero
+// value is no limits.
+type CouponLimits struct {
+	// MaxUses is the number of orders that can use the code, zero is no
+	// limit.
+	MaxUses int
+	// MaxUsesPerCustomer is the number of orders each customer can use the
+	// code in, zero is no limit. Orders without a customer cannot use a code
+	// with a limit per customer.
+	MaxUsesPerCustomer int
+	// ValidFrom is when the code can first be used, zero is always.
+	ValidFrom time.Time
+	// ValidUntil is when the code can no longer be used, zero is never.
+	ValidUntil time.Time
+}
+
+// Validate checks that the limits make sense.
+func (l CouponLimits) Validate() error {
+	if l.MaxUses < 0 || l.MaxUsesPerCustomer < 0 {
+		return fmt.Errorf("%w uses cannot be negative", ErrInvalidCouponLimits)
+	}
+
+	if !l.ValidFrom.IsZero() && !l.ValidUntil.IsZero() && !l.ValidFrom.Before(l.ValidUntil) {
+		return fmt.Errorf("%w valid from %s is not before valid until %s",
+			ErrInvalidCouponLimits, l.ValidFrom.Format(time.RFC3339), l.ValidUntil.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Check returns an error if the code cannot be redeemed at the given time, by
+// the customer, when it has already been used uses times, customerUses of
+// them by the customer. Stores call it while holding the lock that makes the
+// check, and the recording of the redemption, atomic.
+func (l CouponLimits) Check(at time.Time, customerID string, uses, customerUses int) error {
+	if !l.ValidFrom.IsZero() && at.Before(l.ValidFrom) {
+		return fmt.Errorf("%w until %s", ErrCouponNotActive, l.ValidFrom.Format(time.RFC3339))
+	}
+
+	if !l.ValidUntil.IsZero() && !at.Before(l.ValidUntil) {
+		return fmt.Errorf("%w since %s", ErrCouponNotActive, l.ValidUntil.Format(time.RFC3339))
+	}
+
+	if l.MaxUses > 0 && uses >= l.MaxUses {
+		return fmt.Errorf("%w, all %d uses taken", ErrCouponUsedUp, l.MaxUses)
+	}
+
+	if l.MaxUsesPerCustomer > 0 {
+		if customerID == "" {
+			return ErrCustomerRequired
+		}
+
+		if customerUses >= l.MaxUsesPerCustomer {
+			return fmt.Errorf("%w, all %d uses taken", ErrCustomerLimit, l.MaxUsesPerCustomer)
+		}
+	}
+
+	return nil
+}
+
+// Redemption is the use of a code by an order, as recorded in the ledger.
+type Redemption struct {
+	Code       string
+	OrderID    string
+	CustomerID string
+	RedeemedAt time.Time
+	// CancelledAt is when the order was cancelled, and the use of the code
+	// returned. Zero while the redemption counts against the limits.
+	CancelledAt time.Time
+}
+
+// RedemptionStore holds the limits of each code, and the ledger of their
+// redemptions. Codes are stored upper case.
+type RedemptionStore interface {
+	// SetLimits replaces the limits of the code.
+	SetLimits(code string, limits CouponLimits) error
+	// GetLimits returns the limits of the code, the zero CouponLimits if it
+	// has none.
+	GetLimits(code string) (CouponLimits, error)
+	// Redeem checks the redemption against the code's limits, and, if they
+	// allow it, records it. The check and the record are atomic, so
+	// concurrent orders cannot both take the last use.
+	Redeem(redemption Redemption) error
+	// CancelRedemption marks the order's redemption cancelled, returning its
+	// use of the code. It is not an error for the order to have no
+	// redemption, or for it to be cancelled already.
+	CancelRedemption(orderID string, at time.Time) error
+	// ListRedemptions returns the redemptions of the code, oldest first.
+	ListRedemptions(code string) ([]Redemption, error)
+}
+
+// Ledger records coupon redemptions for orders.
+type Ledger struct {
+	store RedemptionStore
+	now   func() time.Time
+}
+
+// NewLedger creates a ledger kept in the store.
+func NewLedger(store RedemptionStore) (*Ledger, error) {
+	return NewLedgerWithClock(store, time.Now)
+}
+
+// NewLedgerWithClock creates a ledger that reads the time from now, for
+// tests.
+func NewLedgerWithClock(store RedemptionStore, now func() time.Time) (*Ledger, error) {
+	if validation.IsNil(store) {
+		return nil, fmt.Errorf("%w redemption store is nil", ErrInvalidRedemption)
+	}
+
+	return &Ledger{store: store, now: now}, nil
+}
+
+// RedeemCoupon records the use of the code by the order, failing if the
+// code's limits do not allow it.
+func (l *Ledger) RedeemCoupon(code, orderID, customerID string) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	if code == "" || orderID == "" {
+		return fmt.Errorf("%w code and order ID are required", ErrInvalidRedemption)
+	}
+
+	return l.store.Redeem(Redemption{
+		Code:       code,
+		OrderID:    orderID,
+		CustomerID: customerID,
+		RedeemedAt: l.now().UTC(),
+	})
+}
+
+// ReleaseCoupon returns the use of a code by a cancelled order.
+func (l *Ledger) ReleaseCoupon(orderID string) error {
+	return l.store.CancelRedemption(orderID, l.now().UTC())
+}
@@ -0,0 +1,208 @@
+package promotion_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubRedemptionStore is an in memory promotion.RedemptionStore.
+type stubRedemptionStore struct {
+	limits      map[string]promotion.CouponLimits
+	redemptions []promotion.Redemption
+}
+
+func newStubRedemptionStore() *stubRedemptionStore {
+	return &stubRedemptionStore{limits: map[string]promotion.CouponLimits{}}
+}
+
+func (s *stubRedemptionStore) SetLimits(code string, limits promotion.CouponLimits) error {
+	s.limits[code] = limits
+
+	return nil
+}
+
+func (s *stubRedemptionStore) GetLimits(code string) (promotion.CouponLimits, error) {
+	return s.limits[code], nil
+}
+
+func (s *stubRedemptionStore) Redeem(redemption promotion.Redemption) error {
+	uses, customerUses := 0, 0
+
+	for _, redeemed := range s.redemptions {
+		if redeemed.Code != redemption.Code || !redeemed.CancelledAt.IsZero() {
+			continue
+		}
+
+		uses++
+
+		if redemption.CustomerID != "" && redeemed.CustomerID == redemption.CustomerID {
+			customerUses++
+		}
+	}
+
+	if err := s.limits[redemption.Code].Check(redemption.RedeemedAt, redemption.CustomerID, uses, customerUses); err != nil {
+		return err
+	}
+
+	s.redemptions = append(s.redemptions, redemption)
+
+	return nil
+}
+
+func (s *stubRedemptionStore) CancelRedemption(orderID string, at time.Time) error {
+	for i := range s.redemptions {
+		if s.redemptions[i].OrderID == orderID && s.redemptions[i].CancelledAt.IsZero() {
+			s.redemptions[i].CancelledAt = at
+		}
+	}
+
+	return nil
+}
+
+func (s *stubRedemptionStore) ListRedemptions(code string) ([]promotion.Redemption, error) {
+	redemptions := []promotion.Redemption{}
+
+	for _, redemption := range s.redemptions {
+		if redemption.Code == code {
+			redemptions = append(redemptions, redemption)
+		}
+	}
+
+	return redemptions, nil
+}
+
+func TestCouponLimitsValidate(t *testing.T) {
+	march := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	testcases := map[string]struct {
+		limits        promotion.CouponLimits
+		expectedError error
+	}{
+		"No limits": {},
+		"Every limit": {
+			limits: promotion.CouponLimits{
+				MaxUses: 100, MaxUsesPerCustomer: 1, ValidFrom: march, ValidUntil: march.AddDate(0, 1, 0),
+			},
+		},
+		"Negative uses": {
+			limits:        promotion.CouponLimits{MaxUses: -1},
+			expectedError: promotion.ErrInvalidCouponLimits,
+		},
+		"Ends before it starts": {
+			limits:        promotion.CouponLimits{ValidFrom: march, ValidUntil: march},
+			expectedError: promotion.ErrInvalidCouponLimits,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actualError := tc.limits.Validate()
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+		})
+	}
+}
+
+func TestCouponLimitsCheck(t *testing.T) {
+	march := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	april := march.AddDate(0, 1, 0)
+
+	testcases := map[string]struct {
+		limits        promotion.CouponLimits
+		at            time.Time
+		customerID    string
+		uses          int
+		customerUses  int
+		expectedError error
+	}{
+		"No limits": {
+			at:   march,
+			uses: 1000,
+		},
+		"Uses left": {
+			limits: promotion.CouponLimits{MaxUses: 2},
+			at:     march,
+			uses:   1,
+		},
+		"Used up": {
+			limits:        promotion.CouponLimits{MaxUses: 2},
+			at:            march,
+			uses:          2,
+			expectedError: promotion.ErrCouponUsedUp,
+		},
+		"Customer uses left": {
+			limits:       promotion.CouponLimits{MaxUsesPerCustomer: 2},
+			at:           march,
+			customerID:   "customer-1",
+			uses:         10,
+			customerUses: 1,
+		},
+		"Used up by the customer": {
+			limits:        promotion.CouponLimits{MaxUsesPerCustomer: 1},
+			at:            march,
+			customerID:    "customer-1",
+			uses:          1,
+			customerUses:  1,
+			expectedError: promotion.ErrCustomerLimit,
+		},
+		"Customer limit without a customer": {
+			limits:        promotion.CouponLimits{MaxUsesPerCustomer: 1},
+			at:            march,
+			expectedError: promotion.ErrCustomerRequired,
+		},
+		"Before the window": {
+			limits:        promotion.CouponLimits{ValidFrom: march, ValidUntil: april},
+			at:            march.Add(-time.Second),
+			expectedError: promotion.ErrCouponNotActive,
+		},
+		"Start of the window": {
+			limits: promotion.CouponLimits{ValidFrom: march, ValidUntil: april},
+			at:     march,
+		},
+		"End of the window": {
+			limits:        promotion.CouponLimits{ValidFrom: march, ValidUntil: april},
+			at:            april,
+			expectedError: promotion.ErrCouponNotActive,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actualError := tc.limits.Check(tc.at, tc.customerID, tc.uses, tc.customerUses)
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+		})
+	}
+}
+
+func TestLedger(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	store := newStubRedemptionStore()
+
+	require.NoError(t, store.SetLimits("FIFTYOFF", promotion.CouponLimits{MaxUses: 1}))
+
+	_, err := promotion.NewLedger(nil)
+	assert.ErrorIs(t, err, promotion.ErrInvalidRedemption)
+
+	ledger, err := promotion.NewLedgerWithClock(store, func() time.Time { return now })
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, ledger.RedeemCoupon(" ", "order-1", ""), promotion.ErrInvalidRedemption)
+
+	// Codes are case insensitive.
+	require.NoError(t, ledger.RedeemCoupon("fiftyoff", "order-1", "customer-1"))
+	assert.ErrorIs(t, ledger.RedeemCoupon("FIFTYOFF", "order-2", "customer-2"), promotion.ErrCouponUsedUp)
+
+	// Cancelling the first order returns its use.
+	require.NoError(t, ledger.ReleaseCoupon("order-1"))
+	require.NoError(t, ledger.RedeemCoupon("FIFTYOFF", "order-2", "customer-2"))
+
+	redemptions, err := store.ListRedemptions("FIFTYOFF")
+	require.NoError(t, err)
+	assert.Equal(t, []promotion.Redemption{
+		{Code: "FIFTYOFF", OrderID: "order-1", CustomerID: "customer-1", RedeemedAt: now, CancelledAt: now},
+		{Code: "FIFTYOFF", OrderID: "order-2", CustomerID: "customer-2", RedeemedAt: now},
+	}, redemptions)
+}
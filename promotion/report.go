@@ -0,0 +1,82 @@
+package promotion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// CodeReport is where a code was found.
+type CodeReport struct {
+	// Code is the pattern as given.
+	Code string
+	// Files are the files containing the code, in the order they were given.
+	Files []FileMatch
+}
+
+// FileMatch is where a code was found in a single file.
+type FileMatch struct {
+	File string
+	Match
+}
+
+// Report searches every file for the patterns, and reports, for each pattern,
+// the files it is in, how many times, and the first line it is on.
+// Neither the cache nor indexes can answer this, they only hold counts, so
+// the files are always searched.
+// The reports are in the same order as the patterns.
+// When the context is done the search stops, and an error wrapping
+// ErrCancelled is returned.
+func (sc Scanner) Report(ctx context.Context, patterns []string, files []string) ([]CodeReport, error) {
+	progress := newProgressTracker(sc.Progress, files)
+	fileMatches := make([]map[string]Match, len(files))
+	errs := make([]error, len(files))
+
+	var wg sync.WaitGroup
+
+	for i, file := range files {
+		release, err := sc.Pool.acquireFile(ctx)
+		if err != nil {
+			break
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer release()
+
+			fileMatches[i], errs[i] = sc.search(ctx, file, patterns, progress.file(i))
+		}()
+	}
+
+	wg.Wait()
+
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("searching %s: %w", files[i], err)
+		}
+	}
+
+	reports := make([]CodeReport, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		report := CodeReport{Code: pattern, Files: []FileMatch{}}
+		code := strings.ToUpper(pattern)
+
+		for i, file := range files {
+			if match, ok := fileMatches[i][code]; ok {
+				report.Files = append(report.Files, FileMatch{File: file, Match: match})
+			}
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
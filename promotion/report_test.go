@@ -0,0 +1,80 @@
+package promotion_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	plain := filepath.Join("testdata", "coupons.txt")
+	compressed := filepath.Join("testdata", "coupons.zst")
+
+	actual, err := promotion.Scanner{}.Report(t.Context(), []string{"tenoff", "FIFTYOFF", "MISSING"}, []string{plain, compressed})
+	require.NoError(t, err)
+
+	assert.Equal(t, []promotion.CodeReport{
+		{Code: "tenoff", Files: []promotion.FileMatch{
+			{File: plain, Match: promotion.Match{Count: 1, FirstLine: 5}},
+			{File: compressed, Match: promotion.Match{Count: 1, FirstLine: 5}},
+		}},
+		{Code: "FIFTYOFF", Files: []promotion.FileMatch{
+			{File: plain, Match: promotion.Match{Count: 3, FirstLine: 1}},
+			{File: compressed, Match: promotion.Match{Count: 3, FirstLine: 1}},
+		}},
+		{Code: "MISSING", Files: []promotion.FileMatch{}},
+	}, actual)
+}
+
+func TestReportMissingFile(t *testing.T) {
+	_, err := promotion.Scanner{}.Report(t.Context(), []string{"FIFTYOFF"}, []string{filepath.Join("testdata", "missing.txt")})
+
+	assert.Error(t, err)
+}
+
+// TestSearchFileMatchesLineNumbers checks that line numbers are counted across
+// the chunks a file is split into, and the blocks a stream is cut into.
+func TestSearchFileMatchesLineNumbers(t *testing.T) {
+	// Several chunks, even on a single CPU.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var plain bytes.Buffer
+
+	const lines = 1_000_000
+	for i := range lines {
+		fmt.Fprintf(&plain, "CODE%07d\n", i)
+	}
+
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "large.txt")
+	require.NoError(t, os.WriteFile(plainPath, plain.Bytes(), 0o600))
+
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write(plain.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	compressedPath := filepath.Join(dir, "large.gz")
+	require.NoError(t, os.WriteFile(compressedPath, compressed.Bytes(), 0o600))
+
+	for _, path := range []string{plainPath, compressedPath} {
+		actual, err := promotion.SearchFileMatches(t.Context(), path, []string{"CODE0000000", "CODE0500000", "CODE0999999"})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]promotion.Match{
+			"CODE0000000": {Count: 1, FirstLine: 1},
+			"CODE0500000": {Count: 1, FirstLine: 500_001},
+			"CODE0999999": {Count: 1, FirstLine: lines},
+		}, actual, path)
+	}
+}
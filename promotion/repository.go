@@ -1,12 +1,29 @@
 package promotion
 
+import "time"
+
+// Store caches, for a file set, the number of files each code was found in.
+// The file set is identified by its FileSetFingerprint, so a change to any of
+// the files means that the old results are no longer found.
 type Store interface {
-	GetCodeFileMatchCounts([]string) (map[string]CacheResult, error)
-	AddCodeFileMatchCounts(map[string]int) error
+	GetCodeFileMatchCounts(fileSet string, codes []string) (map[string]CacheResult, error)
+	// AddCodeFileMatchCounts caches the counts, created at the time, and
+	// used for the TTL (zero is forever). A count of zero caches that the
+	// code was not found. Codes already cached have their results replaced.
+	AddCodeFileMatchCounts(fileSet string, counts map[string]int, created time.Time, ttl time.Duration) error
+	DeleteCodeFileMatchCounts(fileSet string, codes []string) error
 	InitialiseDataStore() error
 }
 
 type CacheResult struct {
 	MatchCount int
 	Found      bool
+	// CreatedAt and TTL are those the result was cached with.
+	CreatedAt time.Time
+	TTL       time.Duration
+}
+
+// Expired reports whether the result is too old to use at now.
+func (c CacheResult) Expired(now time.Time) bool {
+	return expired(c.CreatedAt, c.TTL, now)
 }
@@ -0,0 +1,237 @@
+package promotion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidRules is returned when validity rules cannot be used.
+var ErrInvalidRules = errors.New("invalid coupon rules")
+
+// Rule names a single check a code is put through.
+type Rule string
+
+// The rules a code can be checked against.
+const (
+	RuleMinFileCount  Rule = "min-file-count"
+	RuleRequiredFiles Rule = "required-files"
+	RuleExcludedFiles Rule = "excluded-files"
+	RuleLength        Rule = "length"
+	RuleCharset       Rule = "charset"
+)
+
+// Rules decide whether a code is a valid coupon for a campaign. The zero value
+// of each field turns its rule off.
+type Rules struct {
+	// MinFileCount is the number of the searched files that the code must be
+	// found in.
+	MinFileCount int `json:"minFileCount"`
+	// RequiredFiles must all contain the code, eg. the campaign's master list.
+	RequiredFiles []string `json:"requiredFiles"`
+	// ExcludedFiles must not contain the code, eg. revocation lists.
+	ExcludedFiles []string `json:"excludedFiles"`
+	// MinLength and MaxLength bound the number of characters in the code.
+	MinLength int `json:"minLength"`
+	MaxLength int `json:"maxLength"`
+	// Charset is every character a code may contain. Codes are upper cased
+	// before they are checked, so only upper case letters are needed.
+	Charset string `json:"charset"`
+}
+
+// DefaultRules is a code that appears in at least two of the files.
+func DefaultRules() Rules {
+	return Rules{MinFileCount: minFileCount}
+}
+
+// Validate reports every problem with the rules.
+func (r Rules) Validate() error {
+	var errs []error
+
+	if r.MinFileCount < 0 {
+		errs = append(errs, fmt.Errorf("%w minFileCount %d cannot be negative", ErrInvalidRules, r.MinFileCount))
+	}
+
+	if r.MinLength < 0 || r.MaxLength < 0 {
+		errs = append(errs, fmt.Errorf("%w lengths cannot be negative", ErrInvalidRules))
+	}
+
+	if r.MaxLength > 0 && r.MinLength > r.MaxLength {
+		errs = append(errs, fmt.Errorf(
+			"%w minLength %d is greater than maxLength %d", ErrInvalidRules, r.MinLength, r.MaxLength,
+		))
+	}
+
+	for _, file := range r.RequiredFiles {
+		if file == "" {
+			errs = append(errs, fmt.Errorf("%w requiredFiles cannot contain an empty path", ErrInvalidRules))
+		}
+	}
+
+	for _, file := range r.ExcludedFiles {
+		if file == "" {
+			errs = append(errs, fmt.Errorf("%w excludedFiles cannot contain an empty path", ErrInvalidRules))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// LoadCampaigns reads the rules for each campaign from a JSON file, an object
+// mapping the campaign name to its rules, eg.
+//
+//	{"summer": {"minFileCount": 2, "excludedFiles": ["revoked.txt"]}}
+//
+// Relative required and excluded files are relative to the rules file.
+func LoadCampaigns(path string) (map[string]Rules, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- The rules file is chosen by the operator.
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+
+	campaigns := map[string]Rules{}
+	if err := decoder.Decode(&campaigns); err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrInvalidRules, path, err)
+	}
+
+	dir := filepath.Dir(path)
+
+	var errs []error
+
+	for name, rules := range campaigns {
+		if err := rules.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("campaign %s: %w", name, err))
+		}
+
+		rules.RequiredFiles = relativeTo(dir, rules.RequiredFiles)
+		rules.ExcludedFiles = relativeTo(dir, rules.ExcludedFiles)
+		campaigns[name] = rules
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return campaigns, nil
+}
+
+func relativeTo(dir string, files []string) []string {
+	resolved := make([]string, 0, len(files))
+
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+
+		resolved = append(resolved, file)
+	}
+
+	return resolved
+}
+
+// RuleResult is the outcome of checking a code against a single rule.
+type RuleResult struct {
+	Rule   Rule
+	Passed bool
+	// Detail explains a failure.
+	Detail string
+}
+
+// Validity is whether a code is a valid coupon, and why.
+type Validity struct {
+	// Valid is true when every rule passed.
+	Valid bool
+	// FileCount is the number of searched files the code was found in.
+	FileCount int
+	Rules     []RuleResult
+	// Uncertain is true when some of the files could not be searched, so
+	// the result may be wrong.
+	Uncertain bool
+	// Cache is where the result came from.
+	Cache CacheStatus
+}
+
+// Failed returns the rules that the code failed.
+func (v Validity) Failed() []RuleResult {
+	failed := []RuleResult{}
+
+	for _, result := range v.Rules {
+		if !result.Passed {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+// codeMatches is where a code was found.
+type codeMatches struct {
+	fileCount int
+	numFiles  int
+	// missingRequired are the required files without the code.
+	missingRequired []string
+	// presentExcluded are the excluded files with the code.
+	presentExcluded []string
+}
+
+// evaluate checks the (upper cased) code against every rule that is turned
+// on.
+func (r Rules) evaluate(code string, matches codeMatches) Validity {
+	validity := Validity{Valid: true, FileCount: matches.fileCount}
+
+	check := func(rule Rule, passed bool, detail string) {
+		result := RuleResult{Rule: rule, Passed: passed}
+		if !passed {
+			result.Detail = detail
+			validity.Valid = false
+		}
+
+		validity.Rules = append(validity.Rules, result)
+	}
+
+	if r.MinFileCount > 0 {
+		check(RuleMinFileCount, matches.fileCount >= r.MinFileCount, fmt.Sprintf(
+			"found in %d of %d files, needs %d", matches.fileCount, matches.numFiles, r.MinFileCount,
+		))
+	}
+
+	if len(r.RequiredFiles) > 0 {
+		check(RuleRequiredFiles, len(matches.missingRequired) == 0,
+			"not found in "+strings.Join(matches.missingRequired, ", "))
+	}
+
+	if len(r.ExcludedFiles) > 0 {
+		check(RuleExcludedFiles, len(matches.presentExcluded) == 0,
+			"found in "+strings.Join(matches.presentExcluded, ", "))
+	}
+
+	if r.MinLength > 0 || r.MaxLength > 0 {
+		length := utf8.RuneCountInString(code)
+		passed := length >= r.MinLength && (r.MaxLength == 0 || length <= r.MaxLength)
+
+		check(RuleLength, passed, fmt.Sprintf("%d characters", length))
+	}
+
+	if r.Charset != "" {
+		invalid := strings.IndexFunc(code, func(char rune) bool {
+			return !strings.ContainsRune(r.Charset, char)
+		})
+
+		detail := ""
+		if invalid != -1 {
+			char, _ := utf8.DecodeRuneInString(code[invalid:])
+			detail = fmt.Sprintf("contains %q", char)
+		}
+
+		check(RuleCharset, invalid == -1, detail)
+	}
+
+	return validity
+}
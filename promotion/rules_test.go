@@ -0,0 +1,160 @@
+package promotion_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRulesValidate(t *testing.T) {
+	testcases := map[string]struct {
+		rules         promotion.Rules
+		expectedError error
+	}{
+		"Default":                {rules: promotion.DefaultRules()},
+		"Every rule off":         {rules: promotion.Rules{}},
+		"Negative file count":    {rules: promotion.Rules{MinFileCount: -1}, expectedError: promotion.ErrInvalidRules},
+		"Negative length":        {rules: promotion.Rules{MinLength: -1}, expectedError: promotion.ErrInvalidRules},
+		"Min length above max":   {rules: promotion.Rules{MinLength: 9, MaxLength: 8}, expectedError: promotion.ErrInvalidRules},
+		"Min length without max": {rules: promotion.Rules{MinLength: 9}},
+		"Empty required file": {
+			rules:         promotion.Rules{RequiredFiles: []string{""}},
+			expectedError: promotion.ErrInvalidRules,
+		},
+		"Empty excluded file": {
+			rules:         promotion.Rules{ExcludedFiles: []string{""}},
+			expectedError: promotion.ErrInvalidRules,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actualError := tc.rules.Validate()
+			if tc.expectedError == nil {
+				assert.NoError(t, actualError)
+				return
+			}
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+		})
+	}
+}
+
+func TestLoadCampaigns(t *testing.T) {
+	testcases := map[string]struct {
+		content       string
+		expected      map[string]promotion.Rules
+		expectedError error
+	}{
+		"Relative files are relative to the rules file": {
+			content: `{"summer": {"minFileCount": 1, "requiredFiles": ["master.txt"], "excludedFiles": ["/revoked.txt"]}}`,
+			expected: map[string]promotion.Rules{"summer": {
+				MinFileCount:  1,
+				RequiredFiles: []string{"DIR/master.txt"},
+				ExcludedFiles: []string{"/revoked.txt"},
+			}},
+		},
+		"Unknown rule": {
+			content:       `{"summer": {"minFiles": 1}}`,
+			expectedError: promotion.ErrInvalidRules,
+		},
+		"Invalid rule": {
+			content:       `{"summer": {"minLength": 9, "maxLength": 8}}`,
+			expectedError: promotion.ErrInvalidRules,
+		},
+		"Not JSON": {
+			content:       `summer`,
+			expectedError: promotion.ErrInvalidRules,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "rules.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+
+			actual, actualError := promotion.LoadCampaigns(path)
+			if tc.expectedError != nil {
+				assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+				return
+			}
+
+			require.NoError(t, actualError)
+
+			for name, rules := range tc.expected {
+				for i, file := range rules.RequiredFiles {
+					rules.RequiredFiles[i] = filepath.Join(dir, file[len("DIR/"):])
+				}
+
+				tc.expected[name] = rules
+			}
+
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestIsValidBatchRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		return path
+	}
+
+	first := write("first.txt", "FIFTYOFF\nTENOFF\nBAD-CODE\nREVOKED\n")
+	second := write("second.txt", "FIFTYOFF\nTENOFF\nBAD-CODE\nREVOKED\n")
+	master := write("master.txt", "FIFTYOFF\nBAD-CODE\nREVOKED\n")
+	revoked := write("revoked.txt", "REVOKED\n")
+
+	rules := promotion.Rules{
+		MinFileCount:  2,
+		RequiredFiles: []string{master},
+		ExcludedFiles: []string{revoked},
+		MinLength:     7,
+		MaxLength:     8,
+		Charset:       "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	}
+
+	search, err := promotion.NewSearch(newStubStore(), promotion.WithRules(rules))
+	require.NoError(t, err)
+
+	results, err := search.IsValidBatch(t.Context(), []string{"fiftyoff", "TENOFF", "BAD-CODE", "REVOKED", "MISSING"}, []string{first, second})
+	require.NoError(t, err)
+
+	failed := map[string][]promotion.Rule{}
+
+	for code, validity := range results {
+		assert.Len(t, validity.Rules, 5, "every rule is reported for %s", code)
+
+		failed[code] = []promotion.Rule{}
+		for _, result := range validity.Failed() {
+			assert.NotEmpty(t, result.Detail)
+
+			failed[code] = append(failed[code], result.Rule)
+		}
+
+		assert.Equal(t, len(failed[code]) == 0, validity.Valid)
+	}
+
+	assert.Equal(t, map[string][]promotion.Rule{
+		"fiftyoff": {},
+		"TENOFF":   {promotion.RuleRequiredFiles, promotion.RuleLength},
+		"BAD-CODE": {promotion.RuleCharset},
+		"REVOKED":  {promotion.RuleExcludedFiles},
+		"MISSING":  {promotion.RuleMinFileCount, promotion.RuleRequiredFiles},
+	}, failed)
+
+	assert.Equal(t, 2, results["fiftyoff"].FileCount)
+	assert.Equal(t, 0, results["MISSING"].FileCount)
+}
+
+func TestNewSearchInvalidRules(t *testing.T) {
+	_, err := promotion.NewSearch(newStubStore(), promotion.WithRules(promotion.Rules{MinFileCount: -1}))
+
+	assert.ErrorIs(t, err, promotion.ErrInvalidRules)
+}
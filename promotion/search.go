@@ -1,36 +1,230 @@
 package promotion
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
 
-// SearchFileParallel searches files using concurrency.
-// File is mmapped for faster access (kernel manages access), and then broken up
-// into chunks that are then passed to goroutines to be searched.
-func SearchFileParallel(filepath string, patterns []string) (map[string]int, error) {
-	f, err := os.Open(filepath)
+// Match is where a pattern was found in a file.
+type Match struct {
+	Count int
+	// FirstLine is the (1 based) line number of the first match.
+	FirstLine int
+}
+
+// Scanner searches coupon files for codes. The zero value matches lines
+// exactly.
+type Scanner struct {
+	// Normalisation is applied to each line, and each code, before they are
+	// compared.
+	Normalisation Normalisation
+	// Reader is how files are read, the zero value chooses for each file.
+	Reader ReadStrategy
+	// Pool limits the resources used, when it is shared by the searches of
+	// many files. The zero value has no limits.
+	Pool *Pool
+	// Progress, if set, is called with the progress of each search, at
+	// most every 100ms, and when each file is finished. It is called from
+	// the search's goroutines, one call at a time, and must not block for
+	// long.
+	Progress func(Progress)
+}
+
+// SearchFileParallel searches files using concurrency, and returns the number
+// of lines exactly matching each (upper cased) pattern.
+func SearchFileParallel(ctx context.Context, filepath string, patterns []string) (map[string]int, error) {
+	return Scanner{}.SearchFileCounts(ctx, filepath, patterns)
+}
+
+// SearchFileMatches searches files using concurrency, and returns where the
+// lines exactly matching each (upper cased) pattern are.
+func SearchFileMatches(ctx context.Context, filepath string, patterns []string) (map[string]Match, error) {
+	return Scanner{}.SearchFile(ctx, filepath, patterns)
+}
+
+// SearchFileCounts searches the file, and returns the number of lines
+// matching each pattern.
+func (sc Scanner) SearchFileCounts(ctx context.Context, filepath string, patterns []string) (map[string]int, error) {
+	matches, err := sc.SearchFile(ctx, filepath, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return matchCounts(matches), nil
+}
+
+// matchCounts reduces the matches to the number of lines matching each
+// pattern.
+func matchCounts(matches map[string]Match) map[string]int {
+	counts := make(map[string]int, len(matches))
+	for pattern, match := range matches {
+		counts[pattern] = match.Count
+	}
+
+	return counts
+}
+
+// SearchFile searches files using concurrency.
+// Regular files are mmapped for faster access (kernel manages access), or
+// read in blocks with pread on network file systems, see ReadStrategy.
+// StdinPath searches standard input, which, like named pipes, is read as a
+// stream.
+// The results are keyed by the upper cased patterns.
+// When the context is done the search stops, the file is unmapped, and an
+// error wrapping ErrCancelled is returned.
+func (sc Scanner) SearchFile(ctx context.Context, filepath string, patterns []string) (map[string]Match, error) {
+	return sc.search(ctx, filepath, patterns, newProgressTracker(sc.Progress, []string{filepath}).file(0))
+}
+
+// search is SearchFile, publishing its progress as one of many files.
+func (sc Scanner) search(
+	ctx context.Context, filepath string, patterns []string, progress *fileProgress,
+) (map[string]Match, error) {
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+
+	f, closeFile, err := openSource(filepath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, err
 	}
-	defer f.Close()
+	defer closeFile()
 
 	fi, err := f.Stat()
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
-	size := int(fi.Size())
 
-	if size == 0 {
-		return map[string]int{}, nil
+	strategy, err := sc.Reader.resolve(f, fi)
+	if err != nil {
+		return nil, err
 	}
 
+	// Patterns are upper cased, and normalised like the lines. Without
+	// NormaliseCase the file must only contain uppercase codes.
+	normalisedPatterns := make([][]byte, 0, len(patterns))
+	for _, pattern := range patterns {
+		normalisedPatterns = append(normalisedPatterns, sc.Normalisation.normalisePattern(pattern))
+	}
+
+	// The set is built once, and shared by every chunk.
+	patternSet := newPatternSet(normalisedPatterns)
+
+	var matches map[string]Match
+
+	switch size := fi.Size(); {
+	case strategy == ReadStream:
+		matches, err = sc.searchStream(ctx, f, patternSet, progress)
+	case size == 0:
+		matches = map[string]Match{}
+	default:
+		matches, err = sc.searchRegular(ctx, f, size, strategy, patternSet, progress)
+	}
+
+	// The chunks stop early when the context is done, so the matches are
+	// incomplete, and the errors are a consequence of stopping.
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	progress.finish()
+
+	return keyByPattern(patterns, normalisedPatterns, matches), nil
+}
+
+// searchStream searches a file that can only be read sequentially. The
+// compression is detected from the start of the stream.
+func (sc Scanner) searchStream(
+	ctx context.Context, f *os.File, patterns *patternSet, progress *fileProgress,
+) (map[string]Match, error) {
+	// A read from a pipe can block for ever, the deadline unblocks it when
+	// the context is done. It is cleared again for the next search of stdin.
+	stop := context.AfterFunc(ctx, func() {
+		_ = f.SetReadDeadline(time.Now())
+	})
+	defer func() {
+		if !stop() {
+			_ = f.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	reader := bufio.NewReaderSize(progressReader{reader: f, progress: progress}, streamBlockSize)
+
+	header, err := reader.Peek(magicHeaderLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	decompressor, err := newDecompressor(DetectCompression(header), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s file: %w", DetectCompression(header), err)
+	}
+	defer decompressor.Close()
+
+	return searchStream(ctx, decompressor, patterns, sc.Normalisation, sc.Pool)
+}
+
+// searchRegular searches a regular file, with the mmap or pread strategy.
+// Compressed files (gzip, bzip2, zstd, xz), detected by their magic number,
+// cannot be searched in blocks, and are decompressed as a stream instead.
+func (sc Scanner) searchRegular(
+	ctx context.Context, f *os.File, size int64, strategy ReadStrategy, patterns *patternSet, progress *fileProgress,
+) (map[string]Match, error) {
+	header := make([]byte, magicHeaderLen)
+
+	n, err := f.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	if compression := DetectCompression(header[:n]); compression != CompressionNone {
+		decompressor, err := newDecompressor(compression, progressReader{reader: f, progress: progress})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s file: %w", compression, err)
+		}
+		defer decompressor.Close()
+
+		return searchStream(ctx, decompressor, patterns, sc.Normalisation, sc.Pool)
+	}
+
+	if strategy == ReadMmap {
+		// Wait until the file fits in the pool's mapped bytes, a file that
+		// never will is read with pread.
+		release, ok, err := sc.Pool.acquireMapped(ctx, size)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			defer release()
+
+			return searchMmap(ctx, f, int(size), patterns, sc.Normalisation, sc.Pool, progress)
+		}
+	}
+
+	return searchPread(ctx, f, size, patterns, sc.Normalisation, sc.Pool, progress)
+}
+
+// searchMmap searches a regular file through an mmap, broken up into chunks
+// that are then passed to the pool's workers to be searched.
+func searchMmap(
+	ctx context.Context, f *os.File, size int, patterns *patternSet, normalisation Normalisation, pool *Pool,
+	progress *fileProgress,
+) (map[string]Match, error) {
 	// Mmap the entire file.
 	// For an excellent discussion see: https://news.ycombinator.com/item?id=45687796
 	//
@@ -52,6 +246,7 @@ func SearchFileParallel(filepath string, patterns []string) (map[string]int, err
 	// Alternative approaches:
 	//   - io_uring (Linux-only): Similar performance with better async support and cross-platform path
 	//   - Buffered read() with worker pool: More portable, Go runtime can reschedule during I/O
+	//     (ReadPread, chosen automatically for network file systems)
 	//   - For this assignment: mmap is optimal given read-heavy, multi-GB files, parallel access pattern
 
 	fullData, err := unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
@@ -60,37 +255,16 @@ func SearchFileParallel(filepath string, patterns []string) (map[string]int, err
 	}
 	defer unix.Munmap(fullData)
 
-	// Note: This assumes that the file only contains uppercase codes.
-	// Need a requirement check that is correct.
-	upperPatterns := make([][]byte, 0, len(patterns))
-	for _, pattern := range patterns {
-		upperPatterns = append(upperPatterns, []byte(strings.ToUpper(pattern)))
-	}
-
 	// Determine parallelism
-	numCores := runtime.GOMAXPROCS(0)
-	if numCores == 0 {
-		numCores = 4
-	}
+	numCores := pool.numWorkers()
 
 	chunkSize := size / numCores
 
 	var wg sync.WaitGroup
 
-	countChan := make(chan map[string]int)
-
-	var sumWg sync.WaitGroup
-	sumWg.Add(1)
-
-	totalMatches := map[string]int{}
-	go func() {
-		defer sumWg.Done()
-		for counts := range countChan {
-			for pattern, count := range counts {
-				totalMatches[pattern] += count
-			}
-		}
-	}()
+	// Each chunk has its own slot, the results are combined in file order
+	// so that line numbers can be worked out.
+	chunks := make([]chunkResult, numCores)
 
 	// Divide file into chunks and launch goroutines
 	currentStart := 0
@@ -109,9 +283,14 @@ func SearchFileParallel(filepath string, patterns []string) (map[string]int, err
 		}
 
 		if currentEnd > currentStart {
-			wg.Add(1)
 			chunk := fullData[currentStart:currentEnd]
-			go SearchChunks(chunk, upperPatterns, &wg, countChan)
+			// The file is unmapped once the chunks that were started
+			// have stopped.
+			if !pool.goChunk(ctx, &wg, func() {
+				chunks[i] = scanChunk(ctx, chunk, patterns, normalisation, progress)
+			}) {
+				break
+			}
 
 			currentStart = currentEnd
 		}
@@ -122,23 +301,121 @@ func SearchFileParallel(filepath string, patterns []string) (map[string]int, err
 	}
 
 	wg.Wait()
-	close(countChan)
-	sumWg.Wait() // Wait for the aggregation goroutine to finish
 
-	return totalMatches, nil
+	return combineChunks(chunks), nil
+}
+
+// keyByPattern re-keys the matches, from the normalised patterns, to the
+// upper cased patterns.
+func keyByPattern(patterns []string, normalisedPatterns [][]byte, matches map[string]Match) map[string]Match {
+	keyed := make(map[string]Match, len(matches))
+
+	for i, pattern := range patterns {
+		if match, ok := matches[string(normalisedPatterns[i])]; ok {
+			keyed[strings.ToUpper(pattern)] = match
+		}
+	}
+
+	return keyed
+}
+
+// chunkResult is what was found in one chunk of a file.
+type chunkResult struct {
+	// matches has line numbers relative to the start of the chunk.
+	matches map[string]Match
+	// lines is the number of lines in the chunk.
+	lines int
+}
+
+// combineChunks totals the results of the chunks of a file, which must be in
+// file order.
+func combineChunks(chunks []chunkResult) map[string]Match {
+	total := map[string]Match{}
+	linesBefore := 0
+
+	for _, chunk := range chunks {
+		for pattern, match := range chunk.matches {
+			sum := total[pattern]
+			if sum.Count == 0 {
+				sum.FirstLine = linesBefore + match.FirstLine
+			}
+
+			sum.Count += match.Count
+			total[pattern] = sum
+		}
+
+		linesBefore += chunk.lines
+	}
+
+	return total
+}
+
+// SearchChunks searches the provided file chunk for the patterns, and sends
+// the counts to countChan.
+// The search stops early when the context is done, the counts sent are then
+// incomplete, and the caller must check the context.
+func SearchChunks(
+	ctx context.Context, data []byte, patterns [][]byte, wg *sync.WaitGroup, countChan chan<- map[string]int,
+) {
+	searchChunks(ctx, data, newPatternSet(patterns), wg, countChan)
 }
 
-// SearchChunk searches the provided file chunk for the string.
+// searchChunks is SearchChunks for a pattern set that is shared between the
+// chunks of a file.
+func searchChunks(
+	ctx context.Context, data []byte, patterns *patternSet, wg *sync.WaitGroup, countChan chan<- map[string]int,
+) {
+	defer wg.Done()
+
+	// Send the total non-overlapping occurrences found in this chunk
+	countChan <- searchChunk(ctx, data, patterns)
+}
+
+// searchChunk counts the lines in data that match each pattern.
+func searchChunk(ctx context.Context, data []byte, patterns *patternSet) map[string]int {
+	chunk := scanChunk(ctx, data, patterns, NormaliseNone, nil)
+
+	matches := make(map[string]int, len(chunk.matches))
+	for pattern, match := range chunk.matches {
+		matches[pattern] = match.Count
+	}
+
+	return matches
+}
+
+// scanChunk finds the lines in data that match each pattern.
 // The string matching is by bytes, converting to runes will cause allocations
 // and slow things down, and would only be useful is we were looking for the nth
 // character.
-func SearchChunks(data []byte, patterns [][]byte, wg *sync.WaitGroup, countChan chan<- map[string]int) {
-	defer wg.Done()
-
+// Each line is looked up in the pattern set, rather than compared with every
+// pattern, so the time taken depends on the size of the data alone.
+// Lines are normalised before they are looked up, lines that are already
+// normal are not copied.
+// The context is checked, and the progress recorded, every cancelCheckBytes.
+// The scan stops, with what has been found so far, when the context is done.
+func scanChunk(
+	ctx context.Context, data []byte, patterns *patternSet, normalisation Normalisation, progress *fileProgress,
+) chunkResult {
+	normaliser := lineNormaliser{normalisation: normalisation}
+	done := ctx.Done()
+	nextCheck := cancelCheckBytes
 	currentOffset := 0
-	matches := map[string]int{}
+	lineNumber := 0
+	counts := make([]int, len(patterns.patterns))
+	firstLines := make([]int, len(patterns.patterns))
 
 	for currentOffset < len(data) {
+		if currentOffset >= nextCheck {
+			if isDone(done) {
+				break
+			}
+
+			progress.add(int64(currentOffset - (nextCheck - cancelCheckBytes)))
+			nextCheck = currentOffset + cancelCheckBytes
+		}
+
+		lineNumber++
+
 		// Find the next newline character (end of the current line within the chunk)
 		lineEnd := bytes.IndexByte(data[currentOffset:], '\n')
 
@@ -153,15 +430,26 @@ func SearchChunks(data []byte, patterns [][]byte, wg *sync.WaitGroup, countChan
 			currentOffset = currentOffset + lineEnd + 1 // Move offset past the newline
 		}
 
-		for i, pattern := range patterns {
-			// Case insensitive search - pattern is already UCase, and files are
-			// assumed to be UCase codes only.
-			if bytes.Equal(line, pattern) {
-				matches[string(patterns[i])]++
+		line, _ = normaliser.normalise(line)
+
+		if i := patterns.find(line); i != -1 {
+			if counts[i] == 0 {
+				firstLines[i] = lineNumber
 			}
+
+			counts[i]++
 		}
 	}
 
-	// Send the total non-overlapping occurrences found in this chunk
-	countChan <- matches
+	progress.add(int64(currentOffset - (nextCheck - cancelCheckBytes)))
+
+	result := chunkResult{matches: map[string]Match{}, lines: lineNumber}
+
+	for i, count := range counts {
+		if count > 0 {
+			result.matches[patterns.patterns[i]] = Match{Count: count, FirstLine: firstLines[i]}
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,191 @@
+package promotion_test
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchChunks(t *testing.T) {
+	testcases := map[string]struct {
+		data     string
+		patterns []string
+		expected map[string]int
+	}{
+		"Single pattern": {
+			data:     "FIFTYOFF\nTENOFF\nFIFTYOFF",
+			patterns: []string{"FIFTYOFF"},
+			expected: map[string]int{"FIFTYOFF": 2},
+		},
+		"Duplicate patterns are counted once": {
+			data:     "FIFTYOFF\nTENOFF\n",
+			patterns: []string{"FIFTYOFF", "FIFTYOFF"},
+			expected: map[string]int{"FIFTYOFF": 1},
+		},
+		"Whole lines only": {
+			data:     "FIFTYOFFX\nXFIFTYOFF\nFIFTY\n",
+			patterns: []string{"FIFTYOFF", "FIFTY"},
+			expected: map[string]int{"FIFTY": 1},
+		},
+		"Empty lines do not match": {
+			data:     "\n\nTENOFF\n",
+			patterns: []string{"TENOFF"},
+			expected: map[string]int{"TENOFF": 1},
+		},
+		"No patterns": {
+			data:     "TENOFF\n",
+			patterns: []string{},
+			expected: map[string]int{},
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			patterns := make([][]byte, 0, len(tc.patterns))
+			for _, pattern := range tc.patterns {
+				patterns = append(patterns, []byte(pattern))
+			}
+
+			var wg sync.WaitGroup
+
+			countChan := make(chan map[string]int, 1)
+
+			wg.Add(1)
+			promotion.SearchChunks(t.Context(), []byte(tc.data), patterns, &wg, countChan)
+
+			assert.Equal(t, tc.expected, <-countChan)
+		})
+	}
+}
+
+func TestSearchFileParallelManyPatterns(t *testing.T) {
+	path := writeSyntheticCoupons(t, t.TempDir(), 100_000)
+
+	// Every other pattern is in the file.
+	patterns := make([]string, 0, 50_000)
+	for i := range 50_000 {
+		patterns = append(patterns, syntheticCode(i*4))
+	}
+
+	actual, err := promotion.SearchFileParallel(t.Context(), path, patterns)
+	require.NoError(t, err)
+
+	assert.Len(t, actual, 25_000)
+	assert.Equal(t, 1, actual[syntheticCode(0)])
+	assert.Equal(t, 1, actual[syntheticCode(99_996)])
+	assert.Zero(t, actual[syntheticCode(100_000)])
+}
+
+// BenchmarkSearchFileParallel shows that the search time depends on the size
+// of the file, and not the number of patterns.
+// The file is 64MiB by default, set KART_BENCH_MIB for larger files, eg.
+//
+//	KART_BENCH_MIB=4096 go test -run '^$' -bench SearchFileParallel ./promotion
+func BenchmarkSearchFileParallel(b *testing.B) {
+	sizeMiB := 64
+
+	if env := os.Getenv("KART_BENCH_MIB"); env != "" {
+		var err error
+
+		sizeMiB, err = strconv.Atoi(env)
+		require.NoError(b, err)
+	}
+
+	// Lines are 12 bytes, "CODE0000000\n", until there are ten million
+	// codes, after which they are longer. Throughput is measured from the size
+	// of the file that was written, so that it is right for either.
+	lines := sizeMiB << 20 / 12
+	path := writeSyntheticCoupons(b, b.TempDir(), lines)
+	size := syntheticFileSize(b, path)
+
+	for _, numPatterns := range []int{1, 100, 10_000, 50_000} {
+		patterns := make([]string, 0, numPatterns)
+		for i := range numPatterns {
+			// Spread the patterns through the file, half are missing.
+			patterns = append(patterns, syntheticCode(i*(2*lines/numPatterns)))
+		}
+
+		b.Run(fmt.Sprintf("%dMiB/%d_patterns", sizeMiB, numPatterns), func(b *testing.B) {
+			b.SetBytes(size)
+
+			for b.Loop() {
+				if _, err := promotion.SearchFileParallel(b.Context(), path, patterns); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScannerNormalisation shows the cost of normalising lines that are
+// already normal, the common case.
+func BenchmarkScannerNormalisation(b *testing.B) {
+	const lines = 64 << 20 / 12
+
+	path := writeSyntheticCoupons(b, b.TempDir(), lines)
+	size := syntheticFileSize(b, path)
+	patterns := []string{syntheticCode(1), syntheticCode(lines / 2)}
+
+	for _, normalisation := range []promotion.Normalisation{
+		promotion.NormaliseNone,
+		promotion.DefaultNormalisation,
+		promotion.DefaultNormalisation | promotion.NormaliseNFKC,
+	} {
+		scanner := promotion.Scanner{Normalisation: normalisation}
+
+		b.Run(normalisation.String(), func(b *testing.B) {
+			b.SetBytes(size)
+
+			for b.Loop() {
+				if _, err := scanner.SearchFile(b.Context(), path, patterns); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func syntheticCode(i int) string {
+	return fmt.Sprintf("CODE%07d", i)
+}
+
+// writeSyntheticCoupons writes a coupon file with the given number of unique
+// codes.
+func writeSyntheticCoupons(tb testing.TB, dir string, lines int) string {
+	tb.Helper()
+
+	path := filepath.Join(dir, "synthetic.txt")
+
+	f, err := os.Create(path)
+	require.NoError(tb, err)
+
+	writer := bufio.NewWriterSize(f, 1<<20)
+
+	for i := range lines {
+		_, err := writer.WriteString(syntheticCode(i) + "\n")
+		require.NoError(tb, err)
+	}
+
+	require.NoError(tb, writer.Flush())
+	require.NoError(tb, f.Close())
+
+	return path
+}
+
+// syntheticFileSize is the size of the coupon file, so that throughput is
+// measured by what was searched, whatever the length of the codes.
+func syntheticFileSize(tb testing.TB, path string) int64 {
+	tb.Helper()
+
+	info, err := os.Stat(path)
+	require.NoError(tb, err)
+
+	return info.Size()
+}
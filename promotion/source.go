@@ -0,0 +1,160 @@
+package promotion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrInvalidSource    = errors.New("invalid coupon source")
+	ErrUnknownSourceSet = errors.New("unknown coupon source set")
+)
+
+// Source is a coupon file registered under the name of a source set, so that
+// searches can name the set rather than list its files.
+type Source struct {
+	// Set is the name of the source set the file belongs to.
+	Set string
+	// Path is the absolute path of the file.
+	Path        string
+	Description string
+	Added       time.Time
+	// Checksum is the hex SHA-256 of the file's content when it was added.
+	Checksum string
+}
+
+// SourceStore holds the registered coupon sources, and which cached file
+// sets were searched for each source set.
+type SourceStore interface {
+	// AddSources registers the sources, replacing any already registered
+	// with the same set and path.
+	AddSources(sources []Source) error
+	// ListSources returns the sources of the set, or of every set when set
+	// is "", ordered by set and path.
+	ListSources(set string) ([]Source, error)
+	// RemoveSources removes the paths from the set, or the whole set when
+	// there are no paths, along with the cached results of the set.
+	RemoveSources(set string, paths []string) error
+	// LinkFileSet records that the results cached for the file set (see
+	// Store) belong to the source set.
+	LinkFileSet(set, fileSet string) error
+}
+
+// NewSource reads the file to make a Source of it.
+func NewSource(set, path, description string, added time.Time) (Source, error) {
+	if set == "" {
+		return Source{}, fmt.Errorf("%w, a source set needs a name", ErrInvalidSource)
+	}
+
+	if path == StdinPath {
+		return Source{}, fmt.Errorf("%w, standard input cannot be registered", ErrInvalidSource)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Source{}, fmt.Errorf("resolving path of %s: %w", path, err)
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return Source{}, fmt.Errorf("%w %w", ErrInvalidSource, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return Source{}, fmt.Errorf("%w %s is not a regular file", ErrInvalidSource, abs)
+	}
+
+	checksum, err := FileChecksum(abs)
+	if err != nil {
+		return Source{}, err
+	}
+
+	return Source{Set: set, Path: abs, Description: description, Added: added, Checksum: checksum}, nil
+}
+
+// FileChecksum is the hex SHA-256 of the file's content.
+func FileChecksum(path string) (string, error) {
+	f, err := os.Open(path) // #nosec G304 -- The user chooses the coupon files.
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// SourceState is the result of checking a source against its file.
+type SourceState int
+
+const (
+	// SourceOK is a file whose content is unchanged.
+	SourceOK SourceState = iota
+	// SourceChanged is a file whose content has changed since it was added.
+	SourceChanged
+	// SourceMissing is a file that cannot be read.
+	SourceMissing
+)
+
+var sourceStateNames = map[SourceState]string{
+	SourceOK:      "ok",
+	SourceChanged: "changed",
+	SourceMissing: "missing",
+}
+
+func (s SourceState) String() string {
+	if name, ok := sourceStateNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SourceState(%d)", int(s))
+}
+
+// VerifySource checks the source's file against its checksum. The error is
+// why a missing file cannot be read.
+func VerifySource(source Source) (SourceState, error) {
+	checksum, err := FileChecksum(source.Path)
+	if err != nil {
+		return SourceMissing, err
+	}
+
+	if checksum != source.Checksum {
+		return SourceChanged, nil
+	}
+
+	return SourceOK, nil
+}
+
+// SourceSetFiles returns the paths of the files in the source set.
+func SourceSetFiles(store SourceStore, set string) ([]string, error) {
+	if set == "" {
+		return nil, fmt.Errorf("%w, a source set needs a name", ErrInvalidSource)
+	}
+
+	sources, err := store.ListSources(set)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSourceSet, set)
+	}
+
+	files := make([]string, 0, len(sources))
+	for _, source := range sources {
+		files = append(files, source.Path)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,162 @@
+package promotion_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubSourceStore is an in memory promotion.SourceStore.
+type stubSourceStore struct {
+	sources []promotion.Source
+	// links k=source set, v=file sets linked to it.
+	links map[string][]string
+}
+
+func newStubSourceStore() *stubSourceStore {
+	return &stubSourceStore{links: map[string][]string{}}
+}
+
+func (s *stubSourceStore) AddSources(sources []promotion.Source) error {
+	s.sources = append(s.sources, sources...)
+
+	return nil
+}
+
+func (s *stubSourceStore) ListSources(set string) ([]promotion.Source, error) {
+	sources := []promotion.Source{}
+
+	for _, source := range s.sources {
+		if set == "" || source.Set == set {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources, nil
+}
+
+func (s *stubSourceStore) RemoveSources(string, []string) error { return nil }
+
+func (s *stubSourceStore) LinkFileSet(set, fileSet string) error {
+	s.links[set] = append(s.links[set], fileSet)
+
+	return nil
+}
+
+func TestNewSource(t *testing.T) {
+	dir := t.TempDir()
+	coupons := filepath.Join(dir, "coupons.txt")
+	require.NoError(t, os.WriteFile(coupons, []byte("FIFTYOFF\n"), 0o600))
+
+	added := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	testcases := map[string]struct {
+		set           string
+		path          string
+		expected      promotion.Source
+		expectedError error
+	}{
+		"Regular file": {
+			set:  "march",
+			path: coupons,
+			expected: promotion.Source{
+				Set:         "march",
+				Path:        coupons,
+				Description: "march feed",
+				Added:       added,
+				// sha256sum of "FIFTYOFF\n".
+				Checksum: "87997cbb37a85b6674cd25e5fa265903eb2ee04864246d9b3894807b472f2714",
+			},
+		},
+		"No set name": {
+			path:          coupons,
+			expectedError: promotion.ErrInvalidSource,
+		},
+		"Missing file": {
+			set:           "march",
+			path:          filepath.Join(dir, "missing.txt"),
+			expectedError: promotion.ErrInvalidSource,
+		},
+		"Directory": {
+			set:           "march",
+			path:          dir,
+			expectedError: promotion.ErrInvalidSource,
+		},
+		"Standard input": {
+			set:           "march",
+			path:          promotion.StdinPath,
+			expectedError: promotion.ErrInvalidSource,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			actual, actualError := promotion.NewSource(tc.set, tc.path, "march feed", added)
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+
+			if tc.expectedError == nil {
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestVerifySource(t *testing.T) {
+	dir := t.TempDir()
+	coupons := filepath.Join(dir, "coupons.txt")
+	require.NoError(t, os.WriteFile(coupons, []byte("FIFTYOFF\n"), 0o600))
+
+	source, err := promotion.NewSource("march", coupons, "", time.Now())
+	require.NoError(t, err)
+
+	state, err := promotion.VerifySource(source)
+	require.NoError(t, err)
+	assert.Equal(t, promotion.SourceOK, state)
+
+	require.NoError(t, os.WriteFile(coupons, []byte("TENOFF\n"), 0o600))
+
+	state, err = promotion.VerifySource(source)
+	require.NoError(t, err)
+	assert.Equal(t, promotion.SourceChanged, state)
+
+	require.NoError(t, os.Remove(coupons))
+
+	state, err = promotion.VerifySource(source)
+	assert.Error(t, err)
+	assert.Equal(t, promotion.SourceMissing, state)
+}
+
+func TestSearchSourceSet(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("FIFTYOFF\n"), 0o600))
+
+	sources := newStubSourceStore()
+
+	for _, file := range []string{first, second} {
+		source, err := promotion.NewSource("march", file, "", time.Now())
+		require.NoError(t, err)
+		require.NoError(t, sources.AddSources([]promotion.Source{source}))
+	}
+
+	_, err := promotion.SourceSetFiles(sources, "april")
+	assert.ErrorIs(t, err, promotion.ErrUnknownSourceSet)
+
+	files, err := promotion.SourceSetFiles(sources, "march")
+	require.NoError(t, err)
+	assert.Equal(t, []string{first, second}, files)
+
+	search, err := promotion.NewSearch(newStubStore(), promotion.WithSourceSet(sources, "march"))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]bool{"FIFTYOFF": true}, valid(t, search, []string{"FIFTYOFF"}, files))
+	assert.Equal(t, map[string][]string{"march": {fileSetKey(t, files...)}}, sources.links)
+}
@@ -0,0 +1,159 @@
+package promotion
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// streamBlockSize is the size of the blocks read from a stream. Each block is
+// searched by its own goroutine, so it needs to be large enough that the
+// goroutine overhead is negligible, but small enough that all the workers are
+// kept busy.
+const streamBlockSize = 4 << 20
+
+// searchStream searches a stream that cannot be mmapped, eg. the output of a
+// decompressor.
+//
+// The stream is necessarily read sequentially, but it is cut into blocks that
+// end on a line boundary, and the blocks are searched concurrently by the
+// pool's workers. For compressed files this means that decompression (one
+// goroutine) overlaps with searching, so the search runs at the speed of the
+// decompressor.
+func searchStream(
+	ctx context.Context, reader io.Reader, patterns *patternSet, normalisation Normalisation, pool *Pool,
+) (map[string]Match, error) {
+	numWorkers := pool.numWorkers()
+
+	// Blocks are recycled through free, which bounds the memory used to
+	// roughly (numWorkers * 2) * streamBlockSize.
+	free := make(chan []byte, numWorkers*2)
+	for range cap(free) {
+		free <- make([]byte, streamBlockSize)
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	// The blocks are searched out of order, but the results are put back in
+	// stream order, so that line numbers can be worked out.
+	chunks := []chunkResult{}
+
+	readErr := readBlocks(ctx, reader, free, func(block streamBlock) bool {
+		return pool.goChunk(ctx, &wg, func() {
+			result := scanChunk(ctx, block.data, patterns, normalisation, nil)
+
+			mu.Lock()
+			if block.seq >= len(chunks) {
+				chunks = append(chunks, make([]chunkResult, block.seq-len(chunks)+1)...)
+			}
+
+			chunks[block.seq] = result
+			mu.Unlock()
+
+			// Return the full capacity buffer to the pool.
+			free <- block.data[:cap(block.data)]
+		})
+	})
+
+	wg.Wait()
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	return combineChunks(chunks), nil
+}
+
+// streamBlock is a block of a stream, and its position in the stream.
+type streamBlock struct {
+	seq  int
+	data []byte
+}
+
+// readBlocks fills buffers from free with the stream, and passes them to
+// search. Every block ends on a line boundary (or the end of the stream), the
+// partial line at the end of a buffer is carried over to the next buffer.
+// Reading stops when search reports false, or the context is done.
+func readBlocks(ctx context.Context, reader io.Reader, free chan []byte, search func(streamBlock) bool) error {
+	buf := <-free
+	filled := 0
+	seq := 0
+
+	for {
+		if err := cancelled(ctx); err != nil {
+			return err
+		}
+
+		n, err := fill(reader, buf[filled:])
+		filled += n
+
+		// io.ErrUnexpectedEOF is not the end of the stream, decompressors
+		// return it for truncated files.
+		eof := errors.Is(err, io.EOF)
+		if err != nil && !eof {
+			free <- buf
+
+			return fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		if eof {
+			if filled > 0 {
+				if !search(streamBlock{seq: seq, data: buf[:filled]}) {
+					return cancelled(ctx)
+				}
+			} else {
+				free <- buf
+			}
+
+			return nil
+		}
+
+		lastNewline := bytes.LastIndexByte(buf[:filled], '\n')
+		if lastNewline == -1 {
+			// A single line is longer than the buffer, grow it and keep
+			// reading.
+			buf = append(buf[:filled], make([]byte, len(buf))...)
+			buf = buf[:cap(buf)]
+
+			continue
+		}
+
+		next := <-free
+		if len(next) < filled-lastNewline-1 {
+			next = make([]byte, len(buf))
+		}
+
+		carried := copy(next, buf[lastNewline+1:filled])
+		if !search(streamBlock{seq: seq, data: buf[:lastNewline+1]}) {
+			return cancelled(ctx)
+		}
+
+		seq++
+
+		buf = next
+		filled = carried
+	}
+}
+
+// fill reads until buf is full, or the reader returns an error. Unlike
+// io.ReadFull, the reader's errors are returned unaltered.
+func fill(reader io.Reader, buf []byte) (int, error) {
+	filled := 0
+
+	for filled < len(buf) {
+		n, err := reader.Read(buf[filled:])
+		filled += n
+
+		if err != nil {
+			return filled, err
+		}
+	}
+
+	return filled, nil
+}
@@ -1,26 +1,222 @@
 package promotion
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/shanehowearth/kart/internal/validation"
 )
 
 const minFileCount = 2
 
+// CacheMode controls how a Search uses the Store.
+type CacheMode int
+
+const (
+	// CacheUse looks codes up in the cache, and caches the codes that are
+	// searched for, including those that are not found.
+	CacheUse CacheMode = iota
+	// CacheRefresh searches for every code, replacing the cached results.
+	CacheRefresh
+	// CacheOff searches for every code, and leaves the cache untouched.
+	CacheOff
+)
+
+// CacheStatus is where a code's result came from.
+type CacheStatus int
+
+const (
+	// CacheHit is a result that was wholly in the cache.
+	CacheHit CacheStatus = iota
+	// CacheMiss is a result that had to be searched for, and was cached.
+	CacheMiss
+	// CacheBypass is a result that had to be searched for, and was not
+	// cached, eg. the cache is off, the files are uncacheable, or the result
+	// is incomplete.
+	CacheBypass
+)
+
+var cacheStatusNames = map[CacheStatus]string{
+	CacheHit:    "hit",
+	CacheMiss:   "miss",
+	CacheBypass: "bypass",
+}
+
+func (c CacheStatus) String() string {
+	if name, ok := cacheStatusNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("CacheStatus(%d)", int(c))
+}
+
+// ErrorPolicy decides what happens when a file cannot be searched.
+type ErrorPolicy int
+
+const (
+	// FailFast fails the whole batch.
+	FailFast ErrorPolicy = iota
+	// Partial returns the results from the files that could be searched,
+	// with the affected codes marked as uncertain. Uncertain results are not
+	// cached.
+	Partial
+)
+
+// Search errors.
+//
+//nolint:revive // Sentinal errors, no need to comment.
+var (
+	ErrSearchFailed      = errors.New("coupon search failed")
+	ErrIncompleteResults = errors.New("coupon results are incomplete")
+	ErrInvalidStore      = errors.New("invalid coupon store")
+)
+
 type Search struct {
-	repo Store
+	repo        Store
+	indexDir    *IndexDir
+	cacheMode   CacheMode
+	cacheTTL    time.Duration
+	now         func() time.Time
+	rules       Rules
+	errorPolicy ErrorPolicy
+	scanner     Scanner
+	// sources, when set, has the file sets cached linked to sourceSet.
+	sources   SourceStore
+	sourceSet string
+	// publish, when set, is given each result as it is resolved.
+	publish func(pattern string, validity Validity)
+}
+
+// Option configures a Search.
+type Option func(*Search)
+
+// WithIndexDir has cache misses use the pre-built indexes in the directory,
+// for files whose index is up to date, rather than scanning the files.
+func WithIndexDir(indexDir *IndexDir) Option {
+	return func(s *Search) {
+		s.indexDir = indexDir
+	}
+}
+
+// WithCacheMode sets how the cache is used, the default is CacheUse.
+func WithCacheMode(mode CacheMode) Option {
+	return func(s *Search) {
+		s.cacheMode = mode
+	}
+}
+
+// WithCacheTTL sets how long the results cached by the search are used for,
+// zero is forever. The default is DefaultCacheTTL. Results already cached keep
+// the TTL they were cached with.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(s *Search) {
+		s.cacheTTL = ttl
+	}
+}
+
+// WithClock sets the clock results are cached, and expired, by. The default
+// is time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(s *Search) {
+		s.now = now
+	}
+}
+
+// WithRules sets the rules a code must pass to be valid, the default is
+// DefaultRules.
+func WithRules(rules Rules) Option {
+	return func(s *Search) {
+		s.rules = rules
+	}
+}
+
+// WithErrorPolicy sets what happens when a file cannot be searched, the
+// default is FailFast.
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return func(s *Search) {
+		s.errorPolicy = policy
+	}
+}
+
+// WithReadStrategy sets how files are read, the default is ReadAuto.
+func WithReadStrategy(strategy ReadStrategy) Option {
+	return func(s *Search) {
+		s.scanner.Reader = strategy
+	}
+}
+
+// WithNormalisation sets the changes made to lines before they are compared
+// with the codes, the default is DefaultNormalisation.
+func WithNormalisation(normalisation Normalisation) Option {
+	return func(s *Search) {
+		s.scanner.Normalisation = normalisation
+	}
+}
+
+// WithPool shares the pool's workers and limits with the search, the default
+// searches every file at once, each with GOMAXPROCS goroutines of its own.
+func WithPool(pool *Pool) Option {
+	return func(s *Search) {
+		s.scanner.Pool = pool
+	}
+}
+
+// WithSourceSet links the results cached by the search to the named source
+// set, so that they are removed with the set. The files searched are still
+// those passed to IsValidBatch, see SourceSetFiles.
+func WithSourceSet(store SourceStore, set string) Option {
+	return func(s *Search) {
+		s.sources = store
+		s.sourceSet = set
+	}
 }
 
-func NewSearch(repo Store) (*Search, error) {
+// WithProgress publishes the progress of the files searched, see
+// Scanner.Progress. Each set of files searched, eg. the required files, has
+// its own progress.
+func WithProgress(publish func(Progress)) Option {
+	return func(s *Search) {
+		s.scanner.Progress = publish
+	}
+}
+
+// WithResults publishes each pattern's result as soon as its code is resolved,
+// rather than when the whole batch is. Codes whose results are all cached are
+// published before any file is searched, and the others once the files have
+// been searched, each in the order of the patterns. See IsValidBatch for the
+// results that are not published when a search fails.
+func WithResults(publish func(pattern string, validity Validity)) Option {
+	return func(s *Search) {
+		s.publish = publish
+	}
+}
+
+func NewSearch(repo Store, opts ...Option) (*Search, error) {
 	if validation.IsNil(repo) {
-		// TODO sentinel error
-		return nil, fmt.Errorf("supplied store is nil")
+		return nil, fmt.Errorf("%w supplied store is nil", ErrInvalidStore)
+	}
+
+	search := &Search{
+		repo:     repo,
+		cacheTTL: DefaultCacheTTL,
+		now:      time.Now,
+		rules:    DefaultRules(),
+		scanner:  Scanner{Normalisation: DefaultNormalisation},
+	}
+	for _, opt := range opts {
+		opt(search)
+	}
+
+	if err := search.rules.Validate(); err != nil {
+		return nil, err
 	}
 
-	return &Search{repo: repo}, nil
+	return search, nil
 }
 
 // Result struct used by main to hold results from parallel file processing
@@ -30,42 +226,262 @@ type FileResult struct {
 	Err      error
 }
 
-func (s *Search) IsValidBatch(patterns []string, files []string) map[string]bool {
-	missedPatterns := []string{}
-	results := map[string]bool{}
+// IsValidBatch checks each pattern against the rules. Patterns are case
+// insensitive, and the results are keyed by the patterns as given.
+//
+// If a file cannot be searched, the FailFast policy returns an error wrapping
+// ErrSearchFailed, and no results. The Partial policy returns the results,
+// with the codes that could not be fully searched marked Uncertain, and an
+// error wrapping ErrIncompleteResults.
+//
+// When the context is done the search stops, whatever the policy, and an
+// error wrapping ErrCancelled is returned with no results.
+//
+// With WithResults, the results of the codes that were wholly cached have
+// already been published when a search fails, or is cancelled, the others
+// are only published when they are returned.
+func (s *Search) IsValidBatch(ctx context.Context, patterns []string, files []string) (map[string]Validity, error) {
+	codes := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		codes = append(codes, strings.ToUpper(pattern))
+	}
 
-	// Check the cache.
-	// TODO: What to do if there are partial misses.
-	cachedResults, err := s.repo.GetCodeFileMatchCounts(patterns)
-	if err != nil {
-		log.Println("Cache error, please fix %w", err)
+	// Each code is looked up, and searched for, once.
+	uniqueCodes := UniquePatterns(codes)
+
+	// Each required and excluded file is a file set of its own, so that it
+	// has its own cached results.
+	fileSets := [][]string{files}
+	for _, file := range s.rules.RequiredFiles {
+		fileSets = append(fileSets, []string{file})
+	}
+
+	for _, file := range s.rules.ExcludedFiles {
+		fileSets = append(fileSets, []string{file})
+	}
+
+	// Every file set is looked up in the cache before any is searched, so
+	// that the codes that are wholly cached are resolved first.
+	lookups := make([]fileSetLookup, 0, len(fileSets))
+	missed := map[string]bool{}
+
+	for _, fileSet := range fileSets {
+		lookup := s.lookUpFiles(uniqueCodes, fileSet)
+		for _, code := range lookup.missed {
+			missed[code] = true
+		}
+
+		lookups = append(lookups, lookup)
+	}
+
+	var errs []error
+
+	counts := make([]map[string]int, len(fileSets))
+	uncertain := map[string]bool{}
+	cacheStatus := map[string]CacheStatus{}
+
+	evaluate := func(code string) Validity {
+		matches := codeMatches{fileCount: counts[0][code], numFiles: len(files)}
+
+		for j, file := range s.rules.RequiredFiles {
+			if counts[1+j][code] == 0 {
+				matches.missingRequired = append(matches.missingRequired, file)
+			}
+		}
+
+		for j, file := range s.rules.ExcludedFiles {
+			if counts[1+len(s.rules.RequiredFiles)+j][code] > 0 {
+				matches.presentExcluded = append(matches.presentExcluded, file)
+			}
+		}
+
+		validity := s.rules.evaluate(code, matches)
+		validity.Uncertain = uncertain[code]
+		validity.Cache = cacheStatus[code]
+
+		return validity
+	}
+
+	for i, lookup := range lookups {
+		counts[i] = lookup.counts
+	}
+
+	if s.publish != nil && cancelled(ctx) == nil {
+		for i, pattern := range patterns {
+			if !missed[codes[i]] {
+				s.publish(pattern, evaluate(codes[i]))
+			}
+		}
+	}
+
+	for i, lookup := range lookups {
+		result, err := s.countFiles(ctx, lookup, fileSets[i])
+		if err != nil {
+			errs = append(errs, err)
+
+			for _, code := range result.searched {
+				uncertain[code] = true
+			}
+		}
+
+		// A code is only a hit if every file set's result was cached.
+		status := CacheMiss
+		if !result.cached {
+			status = CacheBypass
+		}
+
+		for _, code := range result.searched {
+			cacheStatus[code] = max(cacheStatus[code], status)
+		}
+
+		counts[i] = result.counts
+	}
+
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+
+	if len(errs) > 0 && s.errorPolicy == FailFast {
+		return nil, fmt.Errorf("%w: %w", ErrSearchFailed, errors.Join(errs...))
+	}
+
+	results := map[string]Validity{}
+
+	for i, pattern := range patterns {
+		validity := evaluate(codes[i])
+		results[pattern] = validity
+
+		if s.publish != nil && missed[codes[i]] {
+			s.publish(pattern, validity)
+		}
+	}
+
+	if len(errs) > 0 {
+		return results, fmt.Errorf("%w: %w", ErrIncompleteResults, errors.Join(errs...))
+	}
+
+	return results, nil
+}
+
+// IsValid checks a single pattern against the rules, see IsValidBatch.
+func (s *Search) IsValid(ctx context.Context, pattern string, files []string) (Validity, error) {
+	results, err := s.IsValidBatch(ctx, []string{pattern}, files)
+
+	return results[pattern], err
+}
+
+// fileSetCounts is what countFiles found for a file set.
+type fileSetCounts struct {
+	// counts is the number of files that each (upper cased) code is found
+	// in. Codes found in no files are omitted.
+	counts map[string]int
+	// searched are the codes that were not in the cache, and had to be
+	// searched for.
+	searched []string
+	// cached is true when the results of the codes searched for were
+	// cached.
+	cached bool
+}
+
+// fileSetLookup is what the cache holds for a file set.
+type fileSetLookup struct {
+	fileSet   string
+	cacheMode CacheMode
+	// counts is the cached number of files that each (upper cased) code is
+	// found in. Codes found in no files are omitted.
+	counts map[string]int
+	// missed are the codes that were not in the cache, and must be
+	// searched for.
+	missed []string
+}
+
+// lookUpFiles looks the (upper cased) codes up in the cache of the files.
+func (s *Search) lookUpFiles(codes []string, files []string) fileSetLookup {
+	lookup := fileSetLookup{cacheMode: s.cacheMode, counts: map[string]int{}, missed: []string{}}
+
+	// Cached results are only valid for the exact same files.
+	fileSet, err := s.fileSetKey(files)
+	if err != nil && lookup.cacheMode != CacheOff {
+		if !errors.Is(err, ErrUncacheable) {
+			log.Printf("Cannot fingerprint files, not using the cache: %v", err)
+		}
+
+		lookup.cacheMode = CacheOff
+	}
+
+	lookup.fileSet = fileSet
+
+	if lookup.cacheMode != CacheOff && s.sources != nil {
+		if err := s.sources.LinkFileSet(s.sourceSet, fileSet); err != nil {
+			log.Printf("Linking cached results to source set %q failed with error: %v", s.sourceSet, err)
+		}
 	}
 
-	for pattern, result := range cachedResults {
-		if result.Found {
-			// Cache hit - use cached value
-			results[pattern] = result.MatchCount >= minFileCount
-		} else {
-			// Cache miss - need to search
-			missedPatterns = append(missedPatterns, pattern)
+	switch lookup.cacheMode {
+	case CacheUse:
+		// Check the cache.
+		cachedResults, err := s.repo.GetCodeFileMatchCounts(fileSet, codes)
+		if err != nil {
+			log.Printf("Cache error, please fix %v", err)
+		}
+
+		now := s.now()
+
+		for _, code := range codes {
+			result, ok := cachedResults[code]
+
+			if !ok || !result.Found || result.Expired(now) {
+				// Cache miss, or expired - need to search, the
+				// result replaces the expired one.
+				lookup.missed = append(lookup.missed, code)
+
+				continue
+			}
+
+			// Cache hit - use cached value, codes cached as not
+			// found have no count.
+			if result.MatchCount > 0 {
+				lookup.counts[code] = result.MatchCount
+			}
 		}
+	case CacheRefresh, CacheOff:
+		lookup.missed = codes
 	}
 
+	return lookup
+}
+
+// countFiles returns the number of files that each (upper cased) code is
+// found in, searching the files for the codes that the lookup missed.
+// If any file cannot be searched, the errors are returned with the counts
+// from the other files, and nothing is cached.
+func (s *Search) countFiles(ctx context.Context, lookup fileSetLookup, files []string) (fileSetCounts, error) {
+	missedPatterns, results := lookup.missed, lookup.counts
+	fileSet, cacheMode := lookup.fileSet, lookup.cacheMode
+
 	if len(missedPatterns) == 0 {
 		// nothing left to do.
-		return results
+		return fileSetCounts{counts: results, searched: missedPatterns, cached: true}, nil
 	}
 
 	resultsChan := make(chan FileResult, len(files))
 	var fileWg sync.WaitGroup
 
-	// Process each file concurrently
-	for _, filepath := range files {
+	progress := newProgressTracker(s.scanner.Progress, files)
+
+	// Process each file concurrently, as many at once as the pool allows.
+	for i, filepath := range files {
+		release, err := s.scanner.Pool.acquireFile(ctx)
+		if err != nil {
+			break
+		}
+
 		fileWg.Add(1)
 		// Launch a goroutine for each file search
 		go func(fp string) {
 			defer fileWg.Done()
-			counts, err := SearchFileParallel(fp, missedPatterns)
+			defer release()
+			counts, err := s.searchFile(ctx, fp, missedPatterns, progress.file(i))
 			resultsChan <- FileResult{FilePath: fp, Counts: counts, Err: err}
 		}(filepath)
 	}
@@ -73,17 +489,25 @@ func (s *Search) IsValidBatch(patterns []string, files []string) map[string]bool
 	fileWg.Wait()
 	close(resultsChan)
 
-	tmpResults := map[string]int{}
+	// A cancelled search has not searched every file, its counts must not
+	// be cached.
+	if err := cancelled(ctx); err != nil {
+		return fileSetCounts{searched: missedPatterns}, err
+	}
+
+	var errs []error
+
+	// Every code searched for is cached, codes found in no files with a
+	// count of zero, so that they are not searched for again. A refresh
+	// replaces the old counts, including those of codes no longer found.
+	tmpResults := make(map[string]int, len(missedPatterns))
+	for _, code := range missedPatterns {
+		tmpResults[code] = 0
+	}
+
 	for res := range resultsChan {
 		if res.Err != nil {
-			// TODO: Need a requirement here, should the search keep on
-			// trucking, which could result in false positives/negatives
-			// (depending on the way things are calculated), or should
-			// everything die in a fire.
-			// Am just producing a log so that the user can make a decision on
-			// what to do - fix, or rerun (with the cache needing to be
-			// rectified).
-			log.Printf("Error processing file %s: %v", res.FilePath, res.Err)
+			errs = append(errs, fmt.Errorf("searching %s: %w", res.FilePath, res.Err))
 			continue
 		}
 
@@ -94,23 +518,97 @@ func (s *Search) IsValidBatch(patterns []string, files []string) map[string]bool
 		}
 	}
 
-	// Populate the cache.
-	if err := s.repo.AddCodeFileMatchCounts(tmpResults); err != nil {
-		// only log the issue, the result has already been calculated.
-		log.Printf("Caching result failed with error: %v", err)
+	// Populate the cache, unless the counts are incomplete.
+	cached := cacheMode != CacheOff && len(errs) == 0
+	if cached {
+		if err := s.repo.AddCodeFileMatchCounts(fileSet, tmpResults, s.now(), s.cacheTTL); err != nil {
+			// only log the issue, the result has already been calculated.
+			log.Printf("Caching result failed with error: %v", err)
+
+			cached = false
+		}
 	}
 
 	// Add results for patterns that were searched.
-	for k, v := range tmpResults {
-		results[k] = v >= minFileCount
+	for code, count := range tmpResults {
+		if count > 0 {
+			results[code] = count
+		}
+	}
+
+	return fileSetCounts{counts: results, searched: missedPatterns, cached: cached}, errors.Join(errs...)
+}
+
+// fileSetKey is the key of the file set's results in the cache. Searches
+// with a different normalisation can find different results, so they have
+// their own results.
+func (s *Search) fileSetKey(files []string) (string, error) {
+	fingerprint, err := FileSetFingerprint(files)
+	if err != nil {
+		return "", err
+	}
+
+	return fingerprint + "/" + s.scanner.Normalisation.String(), nil
+}
+
+// splitFileSetKey splits a file set's key into the FileSetFingerprint of its
+// files, and its normalisation.
+func splitFileSetKey(fileSet string) (string, string) {
+	fingerprint, normalisation, _ := strings.Cut(fileSet, "/")
+
+	return fingerprint, normalisation
+}
+
+// searchFile counts the patterns in the file, using the file's index if it is
+// up to date, and scanning the file otherwise.
+func (s *Search) searchFile(
+	ctx context.Context, filepath string, patterns []string, progress *fileProgress,
+) (map[string]int, error) {
+	if err := cancelled(ctx); err != nil {
+		return nil, err
+	}
+
+	scan := func() (map[string]int, error) {
+		matches, err := s.scanner.search(ctx, filepath, patterns, progress)
+		if err != nil {
+			return nil, err
+		}
+
+		return matchCounts(matches), nil
+	}
+
+	if s.indexDir == nil {
+		return scan()
 	}
 
-	// Add false for patterns that had zero matches.
-	for _, pattern := range missedPatterns {
-		if _, exists := results[pattern]; !exists {
-			results[pattern] = false
+	idx, err := s.indexDir.Open(filepath)
+	if err != nil {
+		if !errors.Is(err, ErrIndexMissing) {
+			log.Printf("Not using index for %s: %v", filepath, err)
 		}
+
+		return scan()
+	}
+	defer idx.Close()
+
+	if idx.Normalisation != s.scanner.Normalisation {
+		log.Printf("Not using index for %s: it is normalised with %s", filepath, idx.Normalisation)
+
+		return scan()
 	}
 
-	return results
+	counts := map[string]int{}
+
+	for _, pattern := range patterns {
+		// The index holds normalised lines, the keys match those from
+		// SearchFileCounts.
+		code := s.scanner.Normalisation.normalisePattern(pattern)
+		if count := idx.Count(code); count > 0 {
+			counts[strings.ToUpper(pattern)] = count
+		}
+	}
+
+	progress.finish()
+
+	return counts, nil
 }
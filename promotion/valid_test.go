@@ -0,0 +1,416 @@
+package promotion_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shanehowearth/kart/promotion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubStore is an in memory promotion.Store.
+type stubStore struct {
+	cache map[string]map[string]promotion.CacheResult
+	// lookups counts the calls to GetCodeFileMatchCounts.
+	lookups int
+}
+
+func newStubStore() *stubStore {
+	return &stubStore{cache: map[string]map[string]promotion.CacheResult{}}
+}
+
+func (s *stubStore) GetCodeFileMatchCounts(fileSet string, codes []string) (map[string]promotion.CacheResult, error) {
+	s.lookups++
+
+	results := map[string]promotion.CacheResult{}
+
+	for _, code := range codes {
+		results[code] = s.cache[fileSet][code]
+	}
+
+	return results, nil
+}
+
+func (s *stubStore) AddCodeFileMatchCounts(
+	fileSet string, counts map[string]int, created time.Time, ttl time.Duration,
+) error {
+	if s.cache[fileSet] == nil {
+		s.cache[fileSet] = map[string]promotion.CacheResult{}
+	}
+
+	for code, count := range counts {
+		s.cache[fileSet][code] = promotion.CacheResult{MatchCount: count, Found: true, CreatedAt: created, TTL: ttl}
+	}
+
+	return nil
+}
+
+func (s *stubStore) DeleteCodeFileMatchCounts(fileSet string, codes []string) error {
+	for _, code := range codes {
+		delete(s.cache[fileSet], code)
+	}
+
+	return nil
+}
+
+func (s *stubStore) InitialiseDataStore() error { return nil }
+
+// counts returns the cached counts for the files.
+func (s *stubStore) counts(t *testing.T, files ...string) map[string]int {
+	t.Helper()
+
+	var counts map[string]int
+
+	for code, result := range s.cache[fileSetKey(t, files...)] {
+		if counts == nil {
+			counts = map[string]int{}
+		}
+
+		counts[code] = result.MatchCount
+	}
+
+	return counts
+}
+
+// fileSetKey is the cache key of the files, searched with the default
+// normalisation.
+func fileSetKey(t *testing.T, files ...string) string {
+	t.Helper()
+
+	fingerprint, err := promotion.FileSetFingerprint(files)
+	require.NoError(t, err)
+
+	return fingerprint + "/" + promotion.DefaultNormalisation.String()
+}
+
+// valid runs the search, and reduces the results to whether each code is
+// valid.
+func valid(t *testing.T, search *promotion.Search, patterns, files []string) map[string]bool {
+	t.Helper()
+
+	results, err := search.IsValidBatch(t.Context(), patterns, files)
+	require.NoError(t, err)
+
+	valid := map[string]bool{}
+	for code, validity := range results {
+		valid[code] = validity.Valid
+	}
+
+	return valid
+}
+
+func TestFileSetFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("TENOFF\n"), 0o600))
+
+	fingerprint := func(files ...string) string {
+		t.Helper()
+
+		actual, err := promotion.FileSetFingerprint(files)
+		require.NoError(t, err)
+
+		return actual
+	}
+
+	original := fingerprint(first, second)
+
+	assert.Equal(t, original, fingerprint(second, first), "order does not matter")
+	assert.Equal(t, original, fingerprint(first, second, first), "duplicates do not matter")
+	assert.NotEqual(t, original, fingerprint(first), "the files matter")
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(second, later, later))
+	assert.NotEqual(t, original, fingerprint(first, second), "modification time matters")
+
+	_, err := promotion.FileSetFingerprint([]string{filepath.Join(dir, "missing.txt")})
+	assert.Error(t, err)
+}
+
+func TestNewSearch(t *testing.T) {
+	_, err := promotion.NewSearch(nil)
+	assert.ErrorIs(t, err, promotion.ErrInvalidStore)
+
+	var store *stubStore
+
+	_, err = promotion.NewSearch(store)
+	assert.ErrorIs(t, err, promotion.ErrInvalidStore, "a typed nil store is rejected")
+}
+
+func TestIsValidBatchCacheModes(t *testing.T) {
+	testcases := map[string]struct {
+		mode            promotion.CacheMode
+		expectedResults map[string]bool
+		expectedCache   map[string]int
+		expectedLookups int
+		expectedStatus  promotion.CacheStatus
+	}{
+		"Use the cache": {
+			mode:            promotion.CacheUse,
+			expectedResults: map[string]bool{"FIFTYOFF": true},
+			expectedCache:   map[string]int{"FIFTYOFF": 2},
+			expectedLookups: 1,
+			expectedStatus:  promotion.CacheHit,
+		},
+		"Refresh the cache": {
+			mode:            promotion.CacheRefresh,
+			expectedResults: map[string]bool{"FIFTYOFF": false},
+			expectedCache:   map[string]int{"FIFTYOFF": 1},
+			expectedLookups: 0,
+			expectedStatus:  promotion.CacheMiss,
+		},
+		"Ignore the cache": {
+			mode:            promotion.CacheOff,
+			expectedResults: map[string]bool{"FIFTYOFF": false},
+			expectedCache:   map[string]int{"FIFTYOFF": 2},
+			expectedLookups: 0,
+			expectedStatus:  promotion.CacheBypass,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			first := filepath.Join(dir, "first.txt")
+			second := filepath.Join(dir, "second.txt")
+
+			require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\n"), 0o600))
+			require.NoError(t, os.WriteFile(second, []byte("TENOFF\n"), 0o600))
+
+			// A cached result that disagrees with the files.
+			store := newStubStore()
+			require.NoError(t, store.AddCodeFileMatchCounts(fileSetKey(t, first, second), map[string]int{"FIFTYOFF": 2}, time.Now(), 0))
+
+			search, err := promotion.NewSearch(store, promotion.WithCacheMode(tc.mode))
+			require.NoError(t, err)
+
+			results, err := search.IsValidBatch(t.Context(), []string{"FIFTYOFF"}, []string{first, second})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedResults, map[string]bool{"FIFTYOFF": results["FIFTYOFF"].Valid})
+			assert.Equal(t, tc.expectedStatus, results["FIFTYOFF"].Cache)
+			assert.Equal(t, tc.expectedCache, store.counts(t, first, second))
+			assert.Equal(t, tc.expectedLookups, store.lookups)
+		})
+	}
+}
+
+func TestIsValidBatchPublishesResults(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\nTENOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("FIFTYOFF\nTENOFF\n"), 0o600))
+
+	data, err := os.ReadFile(filepath.Join("testdata", "coupons.gz"))
+	require.NoError(t, err)
+
+	truncated := filepath.Join(dir, "truncated.gz")
+	require.NoError(t, os.WriteFile(truncated, data[:len(data)-10], 0o600))
+
+	testcases := map[string]struct {
+		files             []string
+		expectedPublished []string
+		expectedError     error
+	}{
+		"Cached codes are published first": {
+			files:             []string{first, second},
+			expectedPublished: []string{"fiftyoff valid", "tenoff valid", "happyhrs invalid"},
+		},
+		"Only cached codes are published when the search fails": {
+			files:             []string{first, second, truncated},
+			expectedPublished: []string{"fiftyoff valid"},
+			expectedError:     promotion.ErrSearchFailed,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			store := newStubStore()
+			require.NoError(t, store.AddCodeFileMatchCounts(fileSetKey(t, tc.files...), map[string]int{"FIFTYOFF": 2}, time.Now(), 0))
+
+			published := []string{}
+			publish := func(pattern string, validity promotion.Validity) {
+				state := "invalid"
+				if validity.Valid {
+					state = "valid"
+				}
+
+				published = append(published, pattern+" "+state)
+			}
+
+			search, err := promotion.NewSearch(store, promotion.WithResults(publish))
+			require.NoError(t, err)
+
+			_, err = search.IsValidBatch(t.Context(), []string{"tenoff", "fiftyoff", "happyhrs"}, tc.files)
+			assert.ErrorIsf(t, err, tc.expectedError, "expected error %v, but got %v", tc.expectedError, err)
+
+			assert.Equal(t, tc.expectedPublished, published)
+		})
+	}
+}
+
+func TestIsValidBatchInvalidatesChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("FIFTYOFF\n"), 0o600))
+
+	store := newStubStore()
+	search, err := promotion.NewSearch(store)
+	require.NoError(t, err)
+
+	files := []string{first, second}
+	assert.Equal(t, map[string]bool{"FIFTYOFF": true}, valid(t, search, []string{"FIFTYOFF"}, files))
+
+	// The code is removed from a file, which must not be answered from the
+	// cache.
+	require.NoError(t, os.WriteFile(second, []byte("TENOFF\n"), 0o600))
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(second, later, later))
+
+	assert.Equal(t, map[string]bool{"FIFTYOFF": false}, valid(t, search, []string{"FIFTYOFF"}, files))
+
+	// A different set of files has its own results.
+	assert.Equal(t, map[string]bool{"FIFTYOFF": false}, valid(t, search, []string{"FIFTYOFF"}, files[:1]))
+}
+
+func TestIsValidBatchCacheTTL(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("TENOFF\n"), 0o600))
+
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	ttl := time.Hour
+
+	// A cached result that disagrees with the files.
+	store := newStubStore()
+	require.NoError(t, store.AddCodeFileMatchCounts(fileSetKey(t, first, second), map[string]int{"FIFTYOFF": 2}, now, ttl))
+
+	search, err := promotion.NewSearch(store,
+		promotion.WithCacheTTL(2*ttl), promotion.WithClock(func() time.Time { return now }))
+	require.NoError(t, err)
+
+	results, err := search.IsValidBatch(t.Context(), []string{"FIFTYOFF"}, []string{first, second})
+	require.NoError(t, err)
+	assert.True(t, results["FIFTYOFF"].Valid, "the result has not expired")
+	assert.Equal(t, promotion.CacheHit, results["FIFTYOFF"].Cache)
+
+	// Once expired the files are searched again, and the result replaced
+	// with one cached with the search's TTL.
+	now = now.Add(ttl)
+
+	results, err = search.IsValidBatch(t.Context(), []string{"FIFTYOFF"}, []string{first, second})
+	require.NoError(t, err)
+	assert.False(t, results["FIFTYOFF"].Valid)
+	assert.Equal(t, promotion.CacheMiss, results["FIFTYOFF"].Cache)
+	assert.Equal(t, promotion.CacheResult{MatchCount: 1, Found: true, CreatedAt: now, TTL: 2 * ttl},
+		store.cache[fileSetKey(t, first, second)]["FIFTYOFF"])
+}
+
+func TestIsValidBatchCachesCodesNotFound(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("FIFTYOFF\n"), 0o600))
+
+	store := newStubStore()
+	search, err := promotion.NewSearch(store)
+	require.NoError(t, err)
+
+	results, err := search.IsValidBatch(t.Context(), []string{"FIFTYOFF", "SUPER100"}, []string{first, second})
+	require.NoError(t, err)
+	assert.Equal(t, promotion.CacheMiss, results["SUPER100"].Cache)
+	assert.Equal(t, map[string]int{"FIFTYOFF": 2, "SUPER100": 0}, store.counts(t, first, second))
+
+	// The code is not searched for again.
+	results, err = search.IsValidBatch(t.Context(), []string{"SUPER100"}, []string{first, second})
+	require.NoError(t, err)
+	assert.False(t, results["SUPER100"].Valid)
+	assert.Equal(t, promotion.CacheHit, results["SUPER100"].Cache)
+
+	// A refresh replaces a stale count with the code not being found.
+	require.NoError(t, store.AddCodeFileMatchCounts(fileSetKey(t, first, second), map[string]int{"TENOFF": 2}, time.Now(), 0))
+
+	refresh, err := promotion.NewSearch(store, promotion.WithCacheMode(promotion.CacheRefresh))
+	require.NoError(t, err)
+
+	results, err = refresh.IsValidBatch(t.Context(), []string{"TENOFF"}, []string{first, second})
+	require.NoError(t, err)
+	assert.False(t, results["TENOFF"].Valid)
+	assert.Equal(t, map[string]int{"FIFTYOFF": 2, "SUPER100": 0, "TENOFF": 0}, store.counts(t, first, second))
+}
+
+func TestIsValidBatchErrorPolicy(t *testing.T) {
+	testcases := map[string]struct {
+		policy          promotion.ErrorPolicy
+		expectedResults map[string]promotion.Validity
+		expectedError   error
+	}{
+		"Fail fast": {
+			policy:        promotion.FailFast,
+			expectedError: promotion.ErrSearchFailed,
+		},
+		"Partial results": {
+			policy: promotion.Partial,
+			expectedResults: map[string]promotion.Validity{
+				"FIFTYOFF": {
+					Valid:     true,
+					FileCount: 2,
+					Rules:     []promotion.RuleResult{{Rule: promotion.RuleMinFileCount, Passed: true}},
+					Uncertain: true,
+					Cache:     promotion.CacheBypass,
+				},
+				// Cached results are not affected by the failure.
+				"CACHED": {
+					Valid:     true,
+					FileCount: 3,
+					Rules:     []promotion.RuleResult{{Rule: promotion.RuleMinFileCount, Passed: true}},
+				},
+			},
+			expectedError: promotion.ErrIncompleteResults,
+		},
+	}
+	for name, tc := range testcases { //nolint:varnamelen // tc is fine in a test.
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			first := filepath.Join(dir, "first.txt")
+			second := filepath.Join(dir, "second.txt")
+			truncated := filepath.Join(dir, "truncated.gz")
+
+			require.NoError(t, os.WriteFile(first, []byte("FIFTYOFF\n"), 0o600))
+			require.NoError(t, os.WriteFile(second, []byte("FIFTYOFF\n"), 0o600))
+
+			data, err := os.ReadFile(filepath.Join("testdata", "coupons.gz"))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(truncated, data[:len(data)-10], 0o600))
+
+			files := []string{first, second, truncated}
+
+			store := newStubStore()
+			require.NoError(t, store.AddCodeFileMatchCounts(fileSetKey(t, files...), map[string]int{"CACHED": 3}, time.Now(), 0))
+
+			search, err := promotion.NewSearch(store, promotion.WithErrorPolicy(tc.policy))
+			require.NoError(t, err)
+
+			results, actualError := search.IsValidBatch(t.Context(), []string{"FIFTYOFF", "CACHED"}, files)
+
+			assert.ErrorIsf(t, actualError, tc.expectedError, "expected error %v, but got %v", tc.expectedError, actualError)
+			assert.Equal(t, tc.expectedResults, results)
+			assert.Equal(t, map[string]int{"CACHED": 3}, store.counts(t, files...), "incomplete results are not cached")
+		})
+	}
+}
@@ -27,7 +27,7 @@ RESPONSE=$(curl -s -X POST localhost:8080/api/order \
 
 echo $RESPONSE
 echo "================"
-ORDER_ID=$(echo "$RESPONSE"| awk -F'"' '/"ID":/ {print $4; exit}')
+ORDER_ID=$(echo "$RESPONSE"| awk -F'"' '/"id":/ {print $4; exit}')
 
 echo "Created order: $ORDER_ID"
 echo "================"
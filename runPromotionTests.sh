@@ -4,19 +4,22 @@
 # the given examples.
 
 # Directory holding the promotion code files.
-PROMOTION_CODE_DIR="$HOME/Downloads/oolio/"
+PROMOTION_CODE_DIR="${PROMOTION_CODE_DIR:-$HOME/Downloads/oolio/}"
+
+# Register the files once, the searches name the source set.
+go run ./cmd/coupons source add -description "oolio coupon bases" oolio "$PROMOTION_CODE_DIR"couponbase{1,2,3}
 
 # Mixed case check
-go run cmd/coupons/main.go -p fifTyOff "$PROMOTION_CODE_DIR"couponbase{1,2,3}
+go run ./cmd/coupons -source oolio -p fifTyOff
 # Lower case check
-go run cmd/coupons/main.go -p happyhrs "$PROMOTION_CODE_DIR"couponbase{1,2,3}
+go run ./cmd/coupons -source oolio -p happyhrs
 # Cache hit check
-go run cmd/coupons/main.go -p FIFTYOFF "$PROMOTION_CODE_DIR"couponbase{1,2,3}
+go run ./cmd/coupons -source oolio -p FIFTYOFF
 # Invalid check.
-go run cmd/coupons/main.go -p super1001 "$PROMOTION_CODE_DIR"couponbase{1,2,3}
+go run ./cmd/coupons -source oolio -p super1001
 # Multiple successful
-go run cmd/coupons/main.go -p FIFTYOFF -p happyhrs "$PROMOTION_CODE_DIR"couponbase{1,2,3}
+go run ./cmd/coupons -source oolio -p FIFTYOFF -p happyhrs
 # Multiple Unsuccessful
-go run cmd/coupons/main.go -p super1001 -p seven "$PROMOTION_CODE_DIR"couponbase{1,2,3}
+go run ./cmd/coupons -source oolio -p super1001 -p seven
 # Multiple mixed success and fail
-go run cmd/coupons/main.go -p FIFTYOFF -p happyhrs -p tomato -p orange "$PROMOTION_CODE_DIR"couponbase{1,2,3}
+go run ./cmd/coupons -source oolio -p FIFTYOFF -p happyhrs -p tomato -p orange